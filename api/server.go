@@ -0,0 +1,566 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/inventory"
+	"github.com/phoebesimon/version_tracker/metrics"
+	"github.com/phoebesimon/version_tracker/tracker"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	osPath         = "/v1/os"
+	historyPath    = "/v1/history"
+	compliancePath = "/v1/compliance"
+	reportPath     = "/v1/inventory/report"
+	reloadPath     = "/v1/admin/reload"
+
+	maxInventorySize = 16 << 20
+	metricsPath      = "/metrics"
+
+	// Generous enough for a full inventory upload, short enough that slow clients can't hold connections open
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = time.Minute
+	writeTimeout      = time.Minute
+	idleTimeout       = 2 * time.Minute
+)
+
+type scrapeStatusResponse struct {
+	Source      string    `json:"source"`
+	LastScrape  time.Time `json:"last_scrape"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+}
+
+type osResponse struct {
+	OSType          string                            `json:"os_type"`
+	LatestVersions  map[string]string                 `json:"latest_versions"`
+	LatestBuilds    map[string]string                 `json:"latest_builds"`
+	SecurityUpdates map[string]tracker.SecurityUpdate `json:"security_updates"`
+	FullInstallers  map[string]tracker.FullInstaller  `json:"full_installers"`
+	LastModified    time.Time                         `json:"last_modified"`
+	ScrapeStatus    []scrapeStatusResponse            `json:"scrape_status"`
+}
+
+type lineResponse struct {
+	OSType         string                  `json:"os_type"`
+	Line           string                  `json:"line"`
+	Version        string                  `json:"version,omitempty"`
+	Build          string                  `json:"build,omitempty"`
+	SecurityUpdate *tracker.SecurityUpdate `json:"security_update,omitempty"`
+	FullInstaller  *tracker.FullInstaller  `json:"full_installer,omitempty"`
+	LastModified   time.Time               `json:"last_modified"`
+}
+
+type releaseResponse struct {
+	ProductKey string    `json:"product_key"`
+	Kind       string    `json:"kind"`
+	OSType     string    `json:"os_type"`
+	Line       string    `json:"line"`
+	Version    string    `json:"version"`
+	Build      string    `json:"build,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	Channel    string    `json:"channel"`
+	Catalogs   []string  `json:"catalogs"`
+	PostDate   time.Time `json:"post_date,omitempty"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+type complianceResponse struct {
+	*tracker.ComplianceResult
+	NewestMissingAgeDays float64 `json:"newest_missing_age_days"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type reloadResponse struct {
+	ReloadedAt time.Time `json:"reloaded_at"`
+}
+
+/**
+ * Server exposes the tracker's versions over HTTP as JSON
+ */
+type Server struct {
+	tracker    *tracker.Tracker
+	evaluator  *tracker.Evaluator
+	adminToken string       // Bearer token for the admin endpoints, which are disabled without one
+	reload     func() error // Re-reads the configuration for /v1/admin/reload
+	mux        *http.ServeMux
+	server     *http.Server
+	mtx        sync.RWMutex
+}
+
+func NewServer(t *tracker.Tracker, evaluator *tracker.Evaluator, addr string) *Server {
+	s := &Server{
+		tracker:   t,
+		evaluator: evaluator,
+		mux:       http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc(osPath, s.handleListOS)
+	s.mux.HandleFunc(osPath+"/", s.handleOS)
+	s.mux.HandleFunc(historyPath, s.handleHistory)
+	s.mux.HandleFunc(historyPath+"/", s.handleHistory)
+	s.mux.HandleFunc(compliancePath+"/", s.handleCompliance)
+	s.mux.HandleFunc(reportPath, s.handleReport)
+	s.mux.HandleFunc(reloadPath, s.handleReload)
+	s.mux.Handle(metricsPath, metrics.Handler())
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	return s
+}
+
+/**
+ * Replaces the evaluator behind the compliance and report endpoints, e.g. to apply a different policy
+ */
+func (s *Server) SetEvaluator(evaluator *tracker.Evaluator) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.evaluator = evaluator
+}
+
+/**
+ * Sets the Bearer token the admin endpoints require. An empty token disables them.
+ */
+func (s *Server) SetAdminToken(token string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.adminToken = token
+}
+
+/**
+ * Sets what POST /v1/admin/reload calls
+ */
+func (s *Server) SetReloadFunc(reload func() error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.reload = reload
+}
+
+func (s *Server) currentEvaluator() *tracker.Evaluator {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.evaluator
+}
+
+/**
+ * Adds a handler alongside the version endpoints
+ */
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+/**
+ * Listens on the configured address and serves requests until Close is called
+ */
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"timestamp": time.Now().UnixNano(),
+		"addr":      listener.Addr().String(),
+	}).Info("Serving API")
+
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"timestamp": time.Now().UnixNano(),
+				"err":       err,
+			}).Error("Error serving API")
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) Close(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) handleListOS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	osTypes := s.tracker.OSTypes()
+	resp := make([]osResponse, 0, len(osTypes))
+	for _, osType := range osTypes {
+		resp = append(resp, s.makeOSResponse(osType, s.readVersions(osType, asOf)))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+/**
+ * Handles /v1/os/{osType} and /v1/os/{osType}/{line}, optionally ?as_of= a past date
+ */
+func (s *Server) handleOS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, osPath), "/"), "/")
+	if len(parts) == 0 || parts[0] == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	osType := parts[0]
+	if s.tracker.ReadVersions(osType) == nil {
+		writeError(w, http.StatusNotFound, "Unknown OS type")
+		return
+	}
+	versionsInfo := s.readVersions(osType, asOf)
+
+	if len(parts) == 1 {
+		writeJSON(w, http.StatusOK, s.makeOSResponse(osType, versionsInfo))
+		return
+	}
+
+	// A line that only has security updates or installers (e.g. one we started tracking after its last point release) still counts
+	line := parts[1]
+	ver, hasVersion := versionsInfo.LatestVersions[line]
+	update, hasSecurityUpdate := versionsInfo.SecurityUpdates[line]
+	installer, hasFullInstaller := versionsInfo.FullInstallers[line]
+	if !hasVersion && !hasSecurityUpdate && !hasFullInstaller {
+		writeError(w, http.StatusNotFound, "Unknown release line")
+		return
+	}
+
+	resp := lineResponse{
+		OSType:       osType,
+		Line:         line,
+		Build:        versionsInfo.LatestBuilds[line],
+		LastModified: versionsInfo.LastModified,
+	}
+	if hasVersion {
+		resp.Version = ver.String()
+	}
+	if hasSecurityUpdate {
+		resp.SecurityUpdate = &update
+	}
+	if hasFullInstaller {
+		resp.FullInstaller = &installer
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+/**
+ * Handles /v1/history[/{osType}[/{line}]], optionally filtered by ?channel=, ?kind=, ?since= and ?until=
+ */
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := tracker.HistoryQuery{
+		Channel: r.URL.Query().Get("channel"),
+		Kind:    r.URL.Query().Get("kind"),
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, historyPath), "/"), "/")
+	if len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if parts[0] != "" {
+		query.OSType = parts[0]
+		if s.tracker.ReadVersions(query.OSType) == nil {
+			writeError(w, http.StatusNotFound, "Unknown OS type")
+			return
+		}
+	}
+	if len(parts) == 2 {
+		query.Line = parts[1]
+	}
+
+	var err error
+	if since := r.URL.Query().Get("since"); since != "" {
+		query.Since, err = tracker.ParseQueryTime(since)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if until := r.URL.Query().Get("until"); until != "" {
+		query.Until, err = tracker.ParseQueryTime(until)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	records := s.tracker.History(query)
+	resp := make([]releaseResponse, 0, len(records))
+	for _, record := range records {
+		resp = append(resp, releaseResponse{
+			ProductKey: record.Key,
+			Kind:       record.ReleaseKind(),
+			OSType:     record.OSType,
+			Line:       record.Line,
+			Version:    record.Version,
+			Build:      record.Build,
+			Title:      record.Title,
+			Channel:    record.Channel,
+			Catalogs:   record.Catalogs,
+			PostDate:   record.PostDate,
+			FirstSeen:  record.FirstSeen,
+			LastSeen:   record.LastSeen,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+/**
+ * Handles /v1/compliance/{osType}/{version}, optionally with ?build=
+ */
+func (s *Server) handleCompliance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, compliancePath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	if s.tracker.ReadVersions(parts[0]) == nil {
+		writeError(w, http.StatusNotFound, "Unknown OS type")
+		return
+	}
+
+	result, err := s.currentEvaluator().Evaluate(parts[0], parts[1], r.URL.Query().Get("build"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, complianceResponse{
+		ComplianceResult:     result,
+		NewestMissingAgeDays: result.NewestMissingAge.Hours() / 24,
+	})
+}
+
+/**
+ * Handles POST /v1/inventory/report. The body is a JSON or (with Content-Type: text/csv) CSV
+ * inventory; the report comes back as JSON unless ?format=csv or ?format=table is given.
+ */
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = inventory.FormatJSON
+	}
+
+	var hosts []inventory.Host
+	var err error
+	body := http.MaxBytesReader(w, r.Body, maxInventorySize)
+	if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+		hosts, err = inventory.ParseCSV(body)
+	} else {
+		hosts, err = inventory.ParseJSON(body)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report := inventory.MakeReport(s.currentEvaluator(), hosts)
+
+	switch format {
+	case inventory.FormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+	case inventory.FormatCSV:
+		w.Header().Set("Content-Type", "text/csv")
+	case inventory.FormatTable:
+		w.Header().Set("Content-Type", "text/plain")
+	default:
+		writeError(w, http.StatusBadRequest, "Unknown format")
+		return
+	}
+
+	err = report.Write(w, format)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"err":       err,
+		}).Error("Error writing response")
+	}
+}
+
+/**
+ * Handles POST /v1/admin/reload, which re-reads the configuration just like a SIGHUP.
+ * Only available when an admin token is configured, and only to requests that present it.
+ */
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	s.mtx.RLock()
+	token, reload := s.adminToken, s.reload
+	s.mtx.RUnlock()
+
+	if token == "" || reload == nil {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !authorized(r, token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err := reload()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, reloadResponse{ReloadedAt: time.Now()})
+}
+
+func authorized(r *http.Request, token string) bool {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, prefix)), []byte(token)) == 1
+}
+
+/**
+ * Returns the live versions, or the versions as of asOf if it is set
+ */
+func (s *Server) readVersions(osType string, asOf time.Time) *tracker.VersionsInfo {
+	if asOf.IsZero() {
+		return s.tracker.ReadVersions(osType)
+	}
+	return s.tracker.VersionsAsOf(osType, asOf)
+}
+
+func parseAsOf(r *http.Request) (time.Time, error) {
+	asOf := r.URL.Query().Get("as_of")
+	if asOf == "" {
+		return time.Time{}, nil
+	}
+	return tracker.ParseQueryTime(asOf)
+}
+
+func (s *Server) makeOSResponse(osType string, versionsInfo *tracker.VersionsInfo) osResponse {
+	resp := osResponse{
+		OSType:          osType,
+		LatestVersions:  map[string]string{},
+		LatestBuilds:    map[string]string{},
+		SecurityUpdates: map[string]tracker.SecurityUpdate{},
+		FullInstallers:  map[string]tracker.FullInstaller{},
+		ScrapeStatus:    []scrapeStatusResponse{},
+	}
+
+	if versionsInfo != nil {
+		for line, ver := range versionsInfo.LatestVersions {
+			resp.LatestVersions[line] = ver.String()
+		}
+		for line, build := range versionsInfo.LatestBuilds {
+			resp.LatestBuilds[line] = build
+		}
+		for line, update := range versionsInfo.SecurityUpdates {
+			resp.SecurityUpdates[line] = update
+		}
+		for line, installer := range versionsInfo.FullInstallers {
+			resp.FullInstallers[line] = installer
+		}
+		resp.LastModified = versionsInfo.LastModified
+	}
+
+	for _, status := range s.tracker.ReadScrapeStatus(osType) {
+		statusResp := scrapeStatusResponse{
+			Source:      status.Source,
+			LastScrape:  status.LastScrape,
+			LastSuccess: status.LastSuccess,
+			DurationMS:  int64(status.Duration / time.Millisecond),
+		}
+		if status.LastError != nil {
+			statusResp.LastError = status.LastError.Error()
+		}
+		resp.ScrapeStatus = append(resp.ScrapeStatus, statusResp)
+	}
+
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"err":       err,
+		}).Error("Error writing response")
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, msg string) {
+	writeJSON(w, statusCode, errorResponse{Error: msg})
+}
@@ -0,0 +1,365 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/api"
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+)
+
+var (
+	released15_1 = time.Now().Add(-30 * 24 * time.Hour)
+	released15_2 = time.Now().Add(-24 * time.Hour)
+)
+
+/**
+ * Sequoia 15.1 and 15.2, and a Sequoia seed
+ */
+func sequoiaScraper() *trackertest.Scraper {
+	seed := trackertest.Release("c", "Sequoia-DeveloperSeed", "15.3", "24D5034f", released15_2)
+	seed.Channel = tracker.ChannelDeveloperSeed
+
+	records := []tracker.ProductRecord{
+		trackertest.Release("a", "Sequoia", "15.1", "24B83", released15_1),
+		trackertest.Release("b", "Sequoia", "15.2", "24C101", released15_2),
+		seed,
+	}
+	for i := range records {
+		records[i].Catalogs = []string{"15"}
+	}
+
+	return &trackertest.Scraper{SourceName: "sequoia", Records: records}
+}
+
+func newServer(t *testing.T) *api.Server {
+	versionTracker := tracker.MakeTracker(300)
+	err := versionTracker.Register(sequoiaScraper())
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionTracker.Scrape(context.Background())
+
+	evaluator, err := tracker.NewEvaluator(versionTracker, tracker.DefaultCompliancePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api.NewServer(versionTracker, evaluator, "127.0.0.1:0")
+}
+
+/**
+ * Makes a request against the server and decodes the JSON response into v, if given
+ */
+func do(t *testing.T, server http.Handler, method string, path string, header http.Header, body string, v interface{}) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if v != nil {
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Fatalf("%s %s: Content-Type = %q, want application/json", method, path, got)
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), v)
+		if err != nil {
+			t.Fatalf("%s %s: %v\n%s", method, path, err, rec.Body.String())
+		}
+	}
+	return rec
+}
+
+func TestListOS(t *testing.T) {
+	server := newServer(t)
+
+	var resp []struct {
+		OSType         string            `json:"os_type"`
+		LatestVersions map[string]string `json:"latest_versions"`
+		ScrapeStatus   []struct {
+			Source    string `json:"source"`
+			LastError string `json:"last_error"`
+		} `json:"scrape_status"`
+	}
+	rec := do(t, server, http.MethodGet, "/v1/os", nil, "", &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+
+	found := false
+	for _, os := range resp {
+		if os.OSType != tracker.OSTypeMac {
+			continue
+		}
+		found = true
+
+		if os.LatestVersions["Sequoia"] != "15.2.0" || os.LatestVersions["Sequoia-DeveloperSeed"] != "15.3.0" {
+			t.Errorf("latest_versions = %v", os.LatestVersions)
+		}
+		if len(os.ScrapeStatus) != 1 || os.ScrapeStatus[0].Source != "sequoia" || os.ScrapeStatus[0].LastError != "" {
+			t.Errorf("scrape_status = %+v", os.ScrapeStatus)
+		}
+	}
+	if !found {
+		t.Errorf("No %s in %+v", tracker.OSTypeMac, resp)
+	}
+}
+
+func TestOSLine(t *testing.T) {
+	server := newServer(t)
+
+	var resp struct {
+		OSType  string `json:"os_type"`
+		Line    string `json:"line"`
+		Version string `json:"version"`
+		Build   string `json:"build"`
+	}
+	rec := do(t, server, http.MethodGet, "/v1/os/macOS/Sequoia", nil, "", &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+	if resp.OSType != tracker.OSTypeMac || resp.Line != "Sequoia" || resp.Version != "15.2.0" || resp.Build != "24C101" {
+		t.Errorf("Response = %+v", resp)
+	}
+
+	// As of before 15.2 came out, 15.1 was the latest
+	asOf := released15_2.Add(-time.Hour).UTC().Format(time.RFC3339)
+	rec = do(t, server, http.MethodGet, "/v1/os/macOS/Sequoia?as_of="+asOf, nil, "", &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("as_of status = %d, want 200", rec.Code)
+	}
+	if resp.Version != "15.1.0" || resp.Build != "24B83" {
+		t.Errorf("as_of response = %+v, want 15.1 (24B83)", resp)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := newServer(t)
+	server.SetAdminToken("s3cret")
+	server.SetReloadFunc(func() error { return nil })
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/v1/os/Windows95", http.StatusNotFound},
+		{http.MethodGet, "/v1/os/macOS/Tahoe", http.StatusNotFound},
+		{http.MethodGet, "/v1/os/macOS/Sequoia/extra", http.StatusNotFound},
+		{http.MethodGet, "/v1/os/macOS?as_of=yesterday-ish", http.StatusBadRequest},
+		{http.MethodPost, "/v1/os", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/v1/os/macOS", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/history/Windows95", http.StatusNotFound},
+		{http.MethodGet, "/v1/history/macOS/Sequoia/extra", http.StatusNotFound},
+		{http.MethodGet, "/v1/history?since=never", http.StatusBadRequest},
+		{http.MethodPut, "/v1/history", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/compliance/macOS", http.StatusNotFound},
+		{http.MethodGet, "/v1/compliance/Windows95/15.1", http.StatusNotFound},
+		{http.MethodGet, "/v1/compliance/macOS/not-a-version", http.StatusBadRequest},
+		{http.MethodPost, "/v1/compliance/macOS/15.1", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/inventory/report", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/admin/reload", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		rec := do(t, server, test.method, test.path, nil, "", &resp)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: status = %d, want %d", test.method, test.path, rec.Code, test.status)
+		}
+		if resp.Error == "" {
+			t.Errorf("%s %s: no error message", test.method, test.path)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	server := newServer(t)
+
+	type release struct {
+		ProductKey string   `json:"product_key"`
+		Kind       string   `json:"kind"`
+		Line       string   `json:"line"`
+		Version    string   `json:"version"`
+		Build      string   `json:"build"`
+		Channel    string   `json:"channel"`
+		Catalogs   []string `json:"catalogs"`
+	}
+
+	tests := []struct {
+		path string
+		keys []string
+	}{
+		{"/v1/history", []string{"a", "b", "c"}},
+		{"/v1/history/macOS/Sequoia", []string{"a", "b"}},
+		{"/v1/history/macOS?channel=DeveloperSeed", []string{"c"}},
+		{"/v1/history?since=" + released15_2.Add(-time.Hour).UTC().Format(time.RFC3339) + "&channel=Release", []string{"b"}},
+	}
+
+	for _, test := range tests {
+		var resp []release
+		rec := do(t, server, http.MethodGet, test.path, nil, "", &resp)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want 200", test.path, rec.Code)
+			continue
+		}
+
+		keys := []string{}
+		for _, r := range resp {
+			keys = append(keys, r.ProductKey)
+		}
+		if strings.Join(keys, ",") != strings.Join(test.keys, ",") {
+			t.Errorf("GET %s: products = %v, want %v", test.path, keys, test.keys)
+		}
+	}
+
+	var resp []release
+	do(t, server, http.MethodGet, "/v1/history/macOS/Sequoia", nil, "", &resp)
+	if len(resp) > 0 {
+		r := resp[0]
+		if r.Kind != tracker.ReleaseKindUpdate || r.Version != "15.1" || r.Build != "24B83" || r.Channel != tracker.ChannelRelease || len(r.Catalogs) != 1 {
+			t.Errorf("Release = %+v", r)
+		}
+	}
+}
+
+func TestCompliance(t *testing.T) {
+	server := newServer(t)
+
+	tests := []struct {
+		path          string
+		status        string
+		patchesBehind int
+	}{
+		{"/v1/compliance/macOS/15.2?build=24C101", tracker.ComplianceStatusPass, 0},
+		{"/v1/compliance/macOS/15.1", tracker.ComplianceStatusWarn, 1},
+	}
+
+	for _, test := range tests {
+		var resp struct {
+			Status               string  `json:"status"`
+			Line                 string  `json:"line"`
+			PatchesBehind        int     `json:"patches_behind"`
+			NewestMissingAgeDays float64 `json:"newest_missing_age_days"`
+		}
+		rec := do(t, server, http.MethodGet, test.path, nil, "", &resp)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want 200", test.path, rec.Code)
+			continue
+		}
+		if resp.Status != test.status || resp.PatchesBehind != test.patchesBehind || resp.Line != "Sequoia" {
+			t.Errorf("GET %s = %+v", test.path, resp)
+		}
+		if test.patchesBehind > 0 && (resp.NewestMissingAgeDays < 0.9 || resp.NewestMissingAgeDays > 1.1) {
+			t.Errorf("GET %s: newest_missing_age_days = %v, want about 1", test.path, resp.NewestMissingAgeDays)
+		}
+	}
+}
+
+func TestInventoryReport(t *testing.T) {
+	server := newServer(t)
+
+	body := `[{"hostname": "a", "os_type": "macOS", "version": "15.2", "build": "24C101"},
+		{"hostname": "b", "os_type": "macOS", "version": "15.1"}]`
+
+	var resp struct {
+		Summary map[string]int `json:"summary"`
+		Hosts   []struct {
+			Hostname string `json:"hostname"`
+			Result   struct {
+				Status string `json:"status"`
+			} `json:"result"`
+		} `json:"hosts"`
+	}
+	rec := do(t, server, http.MethodPost, "/v1/inventory/report", nil, body, &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Summary[tracker.ComplianceStatusPass] != 1 || resp.Summary[tracker.ComplianceStatusWarn] != 1 {
+		t.Errorf("summary = %v", resp.Summary)
+	}
+	if len(resp.Hosts) != 2 {
+		t.Errorf("hosts = %+v", resp.Hosts)
+	}
+
+	csvBody := "hostname,os_type,version\nb,macOS,15.1\n"
+	rec = do(t, server, http.MethodPost, "/v1/inventory/report?format=csv", http.Header{"Content-Type": {"text/csv"}}, csvBody, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("CSV report: status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "b,") {
+		t.Errorf("CSV report doesn't list the host:\n%s", rec.Body.String())
+	}
+
+	for _, path := range []string{"/v1/inventory/report?format=xml", "/v1/inventory/report"} {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		body := "not json"
+		if strings.Contains(path, "xml") {
+			body = "[]"
+		}
+		rec = do(t, server, http.MethodPost, path, nil, body, &errResp)
+		if rec.Code != http.StatusBadRequest || errResp.Error == "" {
+			t.Errorf("POST %s with %q: status %d, error %q", path, body, rec.Code, errResp.Error)
+		}
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	server := newServer(t)
+
+	// Without a token the endpoint doesn't exist
+	rec := do(t, server, http.MethodPost, "/v1/admin/reload", nil, "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Without an admin token: status = %d, want 404", rec.Code)
+	}
+
+	reloads := 0
+	var reloadErr error
+	server.SetAdminToken("s3cret")
+	server.SetReloadFunc(func() error {
+		reloads++
+		return reloadErr
+	})
+
+	rec = do(t, server, http.MethodPost, "/v1/admin/reload", http.Header{"Authorization": {"Bearer wrong"}}, "", nil)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("Wrong token: status = %d, want 401 with a challenge", rec.Code)
+	}
+	rec = do(t, server, http.MethodPost, "/v1/admin/reload", nil, "", nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("No token: status = %d, want 401", rec.Code)
+	}
+	if reloads != 0 {
+		t.Fatalf("Unauthorized requests reloaded %d times", reloads)
+	}
+
+	auth := http.Header{"Authorization": {"Bearer s3cret"}}
+	var resp struct {
+		ReloadedAt time.Time `json:"reloaded_at"`
+	}
+	rec = do(t, server, http.MethodPost, "/v1/admin/reload", auth, "", &resp)
+	if rec.Code != http.StatusOK || reloads != 1 || resp.ReloadedAt.IsZero() {
+		t.Errorf("Reload: status = %d, reloads = %d, response = %+v", rec.Code, reloads, resp)
+	}
+
+	reloadErr = errors.New("invalid config")
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	rec = do(t, server, http.MethodPost, "/v1/admin/reload", auth, "", &errResp)
+	if rec.Code != http.StatusInternalServerError || errResp.Error != "invalid config" {
+		t.Errorf("Failed reload: status = %d, error = %q", rec.Code, errResp.Error)
+	}
+}
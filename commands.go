@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/config"
+	"github.com/phoebesimon/version_tracker/inventory"
+	"github.com/phoebesimon/version_tracker/output"
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/urfave/cli"
+)
+
+type scrapeStatusView struct {
+	Source      string    `json:"source"`
+	OSType      string    `json:"os_type"`
+	LastScrape  time.Time `json:"last_scrape"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type lineView struct {
+	OSType         string                  `json:"os_type"`
+	Line           string                  `json:"line"`
+	Version        string                  `json:"version,omitempty"`
+	Build          string                  `json:"build,omitempty"`
+	SecurityUpdate *tracker.SecurityUpdate `json:"security_update,omitempty"`
+	FullInstaller  *tracker.FullInstaller  `json:"full_installer,omitempty"`
+	LastModified   time.Time               `json:"last_modified"`
+}
+
+type osView struct {
+	OSType       string             `json:"os_type"`
+	Lines        []lineView         `json:"lines"`
+	LastModified time.Time          `json:"last_modified"`
+	ScrapeStatus []scrapeStatusView `json:"scrape_status"`
+}
+
+type lineDetailView struct {
+	lineView
+	History []tracker.ProductRecord `json:"history"`
+}
+
+func formatFlag() cli.StringFlag {
+	return cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format: table, json or yaml",
+		Value: output.FormatTable,
+	}
+}
+
+func commands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:  "serve",
+			Usage: "Run the tracker and serve the versions it finds over HTTP",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen",
+					Usage: "Address to serve the API on (defaults to :8080)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runTracker(c, true)
+			},
+		},
+		{
+			Name:   "check",
+			Usage:  "Scrape every source once, print the latest versions and exit non-zero if any scrape failed",
+			Flags:  []cli.Flag{formatFlag()},
+			Action: runCheck,
+		},
+		{
+			Name:   "list",
+			Usage:  "Print the latest version of every tracked OS release line",
+			Flags:  []cli.Flag{formatFlag()},
+			Action: runList,
+		},
+		{
+			Name:      "show",
+			Usage:     "Print the latest versions and scrape status for an OS, or the latest version and history of one line",
+			ArgsUsage: "<os> [line]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "as-of",
+					Usage: "Show the versions that were current at this time (YYYY-MM-DD or RFC 3339) instead of now",
+				},
+				formatFlag(),
+			},
+			Action: runShow,
+		},
+		{
+			Name:  "history",
+			Usage: "Print every release recorded in the state file, oldest first",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "os",
+					Usage: "Only show releases for this OS type, e.g. macOS",
+				},
+				cli.StringFlag{
+					Name:  "line",
+					Usage: "Only show releases in this line, e.g. Sonoma or Sonoma-DeveloperSeed",
+				},
+				cli.StringFlag{
+					Name:  "channel",
+					Usage: "Only show releases from this channel, e.g. Release or PublicSeed",
+				},
+				cli.StringFlag{
+					Name:  "kind",
+					Usage: "Only show releases of this kind: update, security_update or full_installer",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "Only show releases on or after this date (YYYY-MM-DD or RFC 3339)",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Usage: "Only show releases before this date (YYYY-MM-DD or RFC 3339)",
+				},
+				formatFlag(),
+			},
+			Action: runHistory,
+		},
+		{
+			Name:  "report",
+			Usage: "Check a fleet inventory against the latest versions and report which hosts are behind",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "inventory",
+					Usage: "CSV or JSON file of hosts (hostname, os_type, version, build, model)",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Output format: table, json or csv",
+					Value: inventory.FormatTable,
+				},
+			},
+			Action: runReport,
+		},
+	}
+}
+
+/**
+ * Sets up the tracker for a one-shot command. Without a state file there is nothing
+ * to read versions from, so every source is scraped once first.
+ */
+func loadTracker(c *cli.Context) (*tracker.Tracker, *config.Config, error) {
+	versionTracker, cfg, err := setupTracker(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Storage.StateFile == "" {
+		ctx, cancel := signalContext()
+		defer cancel()
+
+		versionTracker.Scrape(ctx)
+	}
+
+	return versionTracker, cfg, nil
+}
+
+/**
+ * Returns a context that is cancelled on SIGINT/SIGTERM, so a one-shot scrape can be interrupted
+ */
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return ctx, cancel
+}
+
+func runCheck(c *cli.Context) error {
+	versionTracker, _, err := setupTracker(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	versionTracker.Scrape(ctx)
+
+	views := []osView{}
+	for _, osType := range versionTracker.OSTypes() {
+		views = append(views, makeOSView(versionTracker, osType, versionTracker.ReadVersions(osType)))
+	}
+
+	err = output.Write(c.App.Writer, c.String("format"), views, func(w io.Writer) error {
+		for i, view := range views {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			err := writeOSTable(w, view)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, status := range versionTracker.ReadScrapeStatus("") {
+		if status.LastError != nil {
+			failed = append(failed, status.Source)
+		}
+	}
+	if len(failed) > 0 {
+		return cli.NewExitError(fmt.Sprintf("Scrape failed for %s", strings.Join(failed, ", ")), 1)
+	}
+
+	return nil
+}
+
+func runList(c *cli.Context) error {
+	versionTracker, _, err := loadTracker(c)
+	if err != nil {
+		return err
+	}
+
+	lines := []lineView{}
+	for _, osType := range versionTracker.OSTypes() {
+		lines = append(lines, makeLineViews(osType, versionTracker.ReadVersions(osType))...)
+	}
+
+	return output.Write(c.App.Writer, c.String("format"), lines, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "OS\tLINE\tVERSION\tBUILD\tSECURITY UPDATE\tFULL INSTALLER")
+		for _, line := range lines {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", line.OSType, line.Line, orDash(line.Version), orDash(line.Build),
+				formatSecurityUpdate(line.SecurityUpdate), formatFullInstaller(line.FullInstaller))
+		}
+		return tw.Flush()
+	})
+}
+
+/**
+ * show <os> [line]. Past dates (--as-of) are answered from the release history in the state file.
+ */
+func runShow(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return cli.NewExitError("usage: show <os> [line]", 2)
+	}
+	osType := c.Args().Get(0)
+
+	versionTracker, _, err := loadTracker(c)
+	if err != nil {
+		return err
+	}
+
+	versionsInfo := versionTracker.ReadVersions(osType)
+	if versionsInfo == nil {
+		return fmt.Errorf("Unknown OS type %q", osType)
+	}
+
+	var asOf time.Time
+	if asOfFlag := c.String("as-of"); asOfFlag != "" {
+		asOf, err = tracker.ParseQueryTime(asOfFlag)
+		if err != nil {
+			return err
+		}
+		versionsInfo = versionTracker.VersionsAsOf(osType, asOf)
+	}
+
+	if c.NArg() == 1 {
+		view := makeOSView(versionTracker, osType, versionsInfo)
+		return output.Write(c.App.Writer, c.String("format"), view, func(w io.Writer) error {
+			return writeOSTable(w, view)
+		})
+	}
+
+	line := c.Args().Get(1)
+	_, hasVersion := versionsInfo.LatestVersions[line]
+	_, hasSecurityUpdate := versionsInfo.SecurityUpdates[line]
+	_, hasFullInstaller := versionsInfo.FullInstallers[line]
+	if !hasVersion && !hasSecurityUpdate && !hasFullInstaller {
+		return fmt.Errorf("Unknown release line %q", line)
+	}
+
+	query := tracker.HistoryQuery{OSType: osType, Line: line}
+	if !asOf.IsZero() {
+		query.Until = asOf.Add(time.Nanosecond)
+	}
+
+	view := lineDetailView{
+		lineView: makeLineView(osType, line, versionsInfo),
+		History:  versionTracker.History(query),
+	}
+
+	return output.Write(c.App.Writer, c.String("format"), view, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "OS:\t%s\nLine:\t%s\nVersion:\t%s\nBuild:\t%s\n", view.OSType, view.Line, orDash(view.Version), orDash(view.Build))
+		if view.SecurityUpdate != nil {
+			fmt.Fprintf(tw, "Security update:\t%s\n", formatSecurityUpdate(view.SecurityUpdate))
+		}
+		if view.FullInstaller != nil {
+			fmt.Fprintf(tw, "Full installer:\t%s\n", formatFullInstaller(view.FullInstaller))
+			fmt.Fprintf(tw, "Package:\t%s (%d bytes)\n", orDash(view.FullInstaller.Package.URL), view.FullInstaller.Package.Size)
+		}
+		if len(view.History) > 0 {
+			fmt.Fprintln(tw)
+			writeHistoryTable(tw, view.History)
+		}
+		return tw.Flush()
+	})
+}
+
+func runHistory(c *cli.Context) error {
+	versionTracker, cfg, err := setupTracker(c)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Storage.StateFile == "" {
+		return errors.New("history needs a state file (--state-file or storage.state_file) to read releases from")
+	}
+
+	query := tracker.HistoryQuery{
+		OSType:  c.String("os"),
+		Line:    c.String("line"),
+		Channel: c.String("channel"),
+		Kind:    c.String("kind"),
+	}
+	if since := c.String("since"); since != "" {
+		query.Since, err = tracker.ParseQueryTime(since)
+		if err != nil {
+			return err
+		}
+	}
+	if until := c.String("until"); until != "" {
+		query.Until, err = tracker.ParseQueryTime(until)
+		if err != nil {
+			return err
+		}
+	}
+
+	records := versionTracker.History(query)
+
+	return output.Write(c.App.Writer, c.String("format"), records, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		writeHistoryTable(tw, records)
+		return tw.Flush()
+	})
+}
+
+/**
+ * Checks a fleet inventory against the saved state, or a fresh scrape if there is no state file
+ */
+func runReport(c *cli.Context) error {
+	inventoryFile := c.String("inventory")
+	if inventoryFile == "" {
+		return errors.New("report needs --inventory")
+	}
+
+	hosts, err := inventory.LoadFile(inventoryFile)
+	if err != nil {
+		return err
+	}
+
+	versionTracker, cfg, err := loadTracker(c)
+	if err != nil {
+		return err
+	}
+
+	evaluator, err := makeEvaluator(cfg, versionTracker)
+	if err != nil {
+		return err
+	}
+
+	return inventory.MakeReport(evaluator, hosts).Write(c.App.Writer, c.String("format"))
+}
+
+/**
+ * Returns a view of every line with a latest version, security update or full installer, sorted by line
+ */
+func makeLineViews(osType string, versionsInfo *tracker.VersionsInfo) []lineView {
+	seen := make(map[string]bool)
+	for line := range versionsInfo.LatestVersions {
+		seen[line] = true
+	}
+	for line := range versionsInfo.SecurityUpdates {
+		seen[line] = true
+	}
+	for line := range versionsInfo.FullInstallers {
+		seen[line] = true
+	}
+
+	lines := make([]string, 0, len(seen))
+	for line := range seen {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+
+	views := make([]lineView, 0, len(lines))
+	for _, line := range lines {
+		views = append(views, makeLineView(osType, line, versionsInfo))
+	}
+	return views
+}
+
+func makeLineView(osType string, line string, versionsInfo *tracker.VersionsInfo) lineView {
+	view := lineView{
+		OSType:       osType,
+		Line:         line,
+		Build:        versionsInfo.LatestBuilds[line],
+		LastModified: versionsInfo.LastModified,
+	}
+	if ver, ok := versionsInfo.LatestVersions[line]; ok {
+		view.Version = ver.String()
+	}
+	if update, ok := versionsInfo.SecurityUpdates[line]; ok {
+		view.SecurityUpdate = &update
+	}
+	if installer, ok := versionsInfo.FullInstallers[line]; ok {
+		view.FullInstaller = &installer
+	}
+	return view
+}
+
+func makeOSView(versionTracker *tracker.Tracker, osType string, versionsInfo *tracker.VersionsInfo) osView {
+	view := osView{
+		OSType:       osType,
+		Lines:        makeLineViews(osType, versionsInfo),
+		LastModified: versionsInfo.LastModified,
+		ScrapeStatus: []scrapeStatusView{},
+	}
+
+	for _, status := range versionTracker.ReadScrapeStatus(osType) {
+		statusView := scrapeStatusView{
+			Source:      status.Source,
+			OSType:      status.OSType,
+			LastScrape:  status.LastScrape,
+			LastSuccess: status.LastSuccess,
+		}
+		if status.LastError != nil {
+			statusView.LastError = status.LastError.Error()
+		}
+		view.ScrapeStatus = append(view.ScrapeStatus, statusView)
+	}
+
+	return view
+}
+
+func writeOSTable(w io.Writer, view osView) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%s\n", view.OSType)
+	fmt.Fprintln(tw, "LINE\tVERSION\tBUILD\tSECURITY UPDATE\tFULL INSTALLER")
+	for _, line := range view.Lines {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", line.Line, orDash(line.Version), orDash(line.Build),
+			formatSecurityUpdate(line.SecurityUpdate), formatFullInstaller(line.FullInstaller))
+	}
+
+	if len(view.ScrapeStatus) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "SOURCE\tLAST SCRAPE\tLAST SUCCESS\tERROR")
+		for _, status := range view.ScrapeStatus {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status.Source, formatTime(status.LastScrape), formatTime(status.LastSuccess), status.LastError)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func writeHistoryTable(tw *tabwriter.Writer, records []tracker.ProductRecord) {
+	fmt.Fprintln(tw, "RELEASED\tOS\tLINE\tKIND\tVERSION\tBUILD\tCHANNEL\tPRODUCT\tFIRST SEEN")
+	for _, record := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.ReleasedAt().Format("2006-01-02"), record.OSType, record.Line, record.ReleaseKind(), record.Version,
+			record.Build, record.Channel, record.Key, formatTime(record.FirstSeen))
+	}
+}
+
+/**
+ * Formats a security update as "2020-001 (17G14033)", or "-" if there isn't one
+ */
+func formatSecurityUpdate(update *tracker.SecurityUpdate) string {
+	if update == nil {
+		return "-"
+	}
+	if update.Build == "" {
+		return update.Name
+	}
+	return fmt.Sprintf("%s (%s)", update.Name, update.Build)
+}
+
+/**
+ * Formats a full installer as "14.7.1 (23H222)", or "-" if there isn't one
+ */
+func formatFullInstaller(installer *tracker.FullInstaller) string {
+	if installer == nil {
+		return "-"
+	}
+	if installer.Build == "" {
+		return installer.Version
+	}
+	return fmt.Sprintf("%s (%s)", installer.Version, installer.Build)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+	"github.com/urfave/cli"
+)
+
+var lastModified = time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC)
+
+/**
+ * Writes a state file with Sonoma and a High Sierra security update, so commands have
+ * something to read without scraping
+ */
+func writeStateFile(t *testing.T) string {
+	versionsInfo := tracker.MakeVersionsInfo()
+	versionsInfo.Update("Sonoma", version.Must(version.NewVersion("14.7.1")), "23H222")
+	versionsInfo.Update("High Sierra", version.Must(version.NewVersion("10.13.6")), "17G65")
+	versionsInfo.UpdateSecurityUpdate("High Sierra", tracker.SecurityUpdate{Name: "2020-001", Build: "17G11023"})
+	versionsInfo.LastModified = lastModified
+
+	state := tracker.MakeState()
+	state.Sources[tracker.MacScraperName] = &tracker.SourceState{
+		Versions: versionsInfo,
+		Products: map[string]*tracker.ProductRecord{
+			"062-01234/14.7.1/23H222": {
+				Key:       "062-01234",
+				OSType:    tracker.OSTypeMac,
+				Line:      "Sonoma",
+				Version:   "14.7.1",
+				Build:     "23H222",
+				Channel:   tracker.ChannelRelease,
+				Catalogs:  []string{"14"},
+				PostDate:  lastModified,
+				FirstSeen: lastModified,
+				LastSeen:  lastModified,
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	err := tracker.NewFileStorage(path).Save(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+/**
+ * Runs the CLI with args and returns what it wrote. Exit errors are returned instead of exiting the test.
+ */
+func runApp(t *testing.T, args ...string) (string, error) {
+	var buf bytes.Buffer
+
+	exiter, errWriter := cli.OsExiter, cli.ErrWriter
+	cli.OsExiter = func(int) {}
+	cli.ErrWriter = ioutil.Discard
+	defer func() {
+		cli.OsExiter, cli.ErrWriter = exiter, errWriter
+	}()
+
+	app := newApp()
+	app.Writer = &buf
+	err := app.Run(append([]string{"latest-os-version-tracker"}, args...))
+
+	return buf.String(), err
+}
+
+func TestListYAML(t *testing.T) {
+	out, err := runApp(t, "--state-file", writeStateFile(t), "list", "--format", "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `- build: 17G65
+  last_modified: "2024-10-28T17:00:00Z"
+  line: High Sierra
+  os_type: macOS
+  security_update:
+    build: 17G11023
+    name: 2020-001
+    post_date: "0001-01-01T00:00:00Z"
+  version: 10.13.6
+- build: 23H222
+  last_modified: "2024-10-28T17:00:00Z"
+  line: Sonoma
+  os_type: macOS
+  version: 14.7.1
+`
+	if out != want {
+		t.Errorf("list --format yaml =\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestListTable(t *testing.T) {
+	out, err := runApp(t, "--state-file", writeStateFile(t), "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `OS     LINE         VERSION  BUILD   SECURITY UPDATE      FULL INSTALLER
+macOS  High Sierra  10.13.6  17G65   2020-001 (17G11023)  -
+macOS  Sonoma       14.7.1   23H222  -                    -
+`
+	if out != want {
+		t.Errorf("list =\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestShowLineJSON(t *testing.T) {
+	out, err := runApp(t, "--state-file", writeStateFile(t), "show", "--format", "json", tracker.OSTypeMac, "Sonoma")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var view struct {
+		Line    string                  `json:"line"`
+		Version string                  `json:"version"`
+		Build   string                  `json:"build"`
+		History []tracker.ProductRecord `json:"history"`
+	}
+	err = json.Unmarshal([]byte(out), &view)
+	if err != nil {
+		t.Fatalf("show output isn't JSON: %v\n%s", err, out)
+	}
+
+	if view.Line != "Sonoma" || view.Version != "14.7.1" || view.Build != "23H222" {
+		t.Errorf("show = %+v", view)
+	}
+	if len(view.History) != 1 || view.History[0].Key != "062-01234" {
+		t.Errorf("History = %+v, want the Sonoma release", view.History)
+	}
+}
+
+func TestShowErrors(t *testing.T) {
+	stateFile := writeStateFile(t)
+
+	tests := [][]string{
+		{"show"},
+		{"show", "Windows"},
+		{"show", tracker.OSTypeMac, "Tahoe"},
+		{"show", "--as-of", "last tuesday", tracker.OSTypeMac},
+		{"list", "--format", "xml"},
+	}
+
+	for _, args := range tests {
+		_, err := runApp(t, append([]string{"--state-file", stateFile}, args...)...)
+		if err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	server.SetProduct(trackertest.Product{
+		Key:      "062-01234",
+		PostDate: lastModified,
+		Title:    "macOS Sonoma 14.7.1",
+		Version:  "14.7.1",
+		Build:    "23H222",
+	})
+	server.SetCatalog("14", trackertest.FormatXML, "062-01234")
+
+	catalogs, err := json.Marshal([]tracker.Catalog{server.Catalog("14", tracker.ChannelRelease)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogsFile := filepath.Join(t.TempDir(), "catalogs.json")
+	err = ioutil.WriteFile(catalogsFile, catalogs, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runApp(t, "--catalogs", catalogsFile, "check", "--format", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var views []osView
+	err = json.Unmarshal([]byte(out), &views)
+	if err != nil {
+		t.Fatalf("check output isn't JSON: %v\n%s", err, out)
+	}
+	var macOS *osView
+	for i := range views {
+		if views[i].OSType == tracker.OSTypeMac {
+			macOS = &views[i]
+		}
+	}
+	if macOS == nil || len(macOS.Lines) != 1 || macOS.Lines[0].Version != "14.7.1" {
+		t.Errorf("check = %+v", views)
+	}
+
+	// A failed scrape still prints what we have, but exits non-zero
+	server.InjectFault(server.CatalogPath("14"), trackertest.Fault{StatusCode: 404})
+	_, err = runApp(t, "--catalogs", catalogsFile, "--http-max-retries", "0", "check", "--format", "json")
+	if err == nil {
+		t.Error("check succeeded even though the scrape failed")
+	}
+}
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+/**
+ * Environment variables named EnvPrefix plus the upper-cased JSON path of a setting override it,
+ * e.g. VERSION_TRACKER_HTTP_TIMEOUT=10s or VERSION_TRACKER_SCRAPERS_MACOS_ENABLED=false.
+ * Lists of strings are comma-separated.
+ */
+const EnvPrefix = "VERSION_TRACKER"
+
+/**
+ * Duration reads either a Go duration string ("90s", "5m") or a number of seconds
+ */
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return fmt.Errorf("expected a duration such as \"5m\" or a number of seconds, got %s", data)
+	}
+
+	return d.parse(value)
+}
+
+func (d *Duration) parse(value string) error {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	d.Duration = duration
+	return nil
+}
+
+type Config struct {
+	Interval   Duration                 `json:"interval"` // How often to scrape sources without an interval of their own
+	Debug      bool                     `json:"debug"`
+	Listen     string                   `json:"listen"`      // Where `serve` runs the API
+	AdminToken string                   `json:"admin_token"` // Bearer token for the admin API; disabled if empty
+	Scrapers   ScrapersConfig           `json:"scrapers"`
+	HTTP       HTTPConfig               `json:"http"`
+	Storage    StorageConfig            `json:"storage"`
+	Notifiers  NotifiersConfig          `json:"notifiers"`
+	Policy     tracker.CompliancePolicy `json:"policy"`
+}
+
+type ScrapersConfig struct {
+	MacOS MacOSConfig `json:"macos"`
+}
+
+type MacOSConfig struct {
+	Enabled      bool                  `json:"enabled"`
+	Interval     Duration              `json:"interval"` // Zero to use the global interval
+	Catalogs     []tracker.Catalog     `json:"catalogs"`
+	ReleaseLines []tracker.ReleaseLine `json:"release_lines"` // Merged over the built-in lines
+	Workers      int                   `json:"workers"`
+	Rate         float64               `json:"rate"`      // Requests per second across all hosts; 0 is unlimited
+	HostRate     float64               `json:"host_rate"` // Requests per second to any one host; 0 is unlimited
+	Burst        int                   `json:"burst"`
+}
+
+type HTTPConfig struct {
+	Timeout     Duration `json:"timeout"`
+	Proxy       string   `json:"proxy"`
+	CAFile      string   `json:"ca_file"`
+	UserAgent   string   `json:"user_agent"`
+	MaxRetries  int      `json:"max_retries"`
+	MinBackoff  Duration `json:"min_backoff"`
+	MaxBackoff  Duration `json:"max_backoff"`
+	MaxBodySize int64    `json:"max_body_size"`
+	ReplayDir   string   `json:"replay_dir"` // Serve every request from responses saved here instead of the network
+	RecordDir   string   `json:"record_dir"` // Save every response fetched here, in the layout replay_dir reads
+}
+
+type StorageConfig struct {
+	StateFile string `json:"state_file"` // Disabled if empty
+}
+
+type NotifiersConfig struct {
+	Webhook WebhookConfig `json:"webhook"`
+}
+
+type WebhookConfig struct {
+	URLs        []string `json:"urls"` // Disabled if empty
+	Secret      string   `json:"secret"`
+	Outbox      string   `json:"outbox"` // Defaults to <state_file>.outbox.json
+	MaxAttempts int      `json:"max_attempts"`
+	MinBackoff  Duration `json:"min_backoff"`
+	MaxBackoff  Duration `json:"max_backoff"`
+	Timeout     Duration `json:"timeout"`
+}
+
+/**
+ * Returns the configuration used when there is no config file
+ */
+func Default() *Config {
+	return &Config{
+		Interval: Duration{300 * time.Second},
+		Listen:   ":8080",
+		Scrapers: ScrapersConfig{
+			MacOS: MacOSConfig{
+				Enabled:  true,
+				Catalogs: append([]tracker.Catalog(nil), tracker.DefaultMacCatalogs...),
+				Workers:  tracker.DefaultFetchOptions.Workers,
+				Rate:     tracker.DefaultFetchOptions.GlobalRate,
+				HostRate: tracker.DefaultFetchOptions.HostRate,
+				Burst:    tracker.DefaultFetchOptions.Burst,
+			},
+		},
+		HTTP: HTTPConfig{
+			Timeout:     Duration{tracker.DefaultHTTPConfig.Timeout},
+			UserAgent:   tracker.DefaultHTTPConfig.UserAgent,
+			MaxRetries:  tracker.DefaultHTTPConfig.MaxRetries,
+			MinBackoff:  Duration{tracker.DefaultHTTPConfig.MinBackoff},
+			MaxBackoff:  Duration{tracker.DefaultHTTPConfig.MaxBackoff},
+			MaxBodySize: tracker.DefaultHTTPConfig.MaxBodySize,
+		},
+		Policy: tracker.DefaultCompliancePolicy,
+	}
+}
+
+/**
+ * Reads a JSON config file over the defaults; settings the file leaves out keep their default.
+ * An empty path returns the defaults.
+ */
+func Load(path string) (*Config, error) {
+	config := Default()
+	if path == "" {
+		return config, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	for i := range config.Scrapers.MacOS.Catalogs {
+		if config.Scrapers.MacOS.Catalogs[i].Channel == "" {
+			config.Scrapers.MacOS.Catalogs[i].Channel = tracker.ChannelRelease
+		}
+	}
+
+	return config, nil
+}
+
+/**
+ * Overrides settings from the environment. lookup is normally os.LookupEnv.
+ */
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup)
+}
+
+var durationType = reflect.TypeOf(Duration{})
+
+func applyEnv(v reflect.Value, name string, lookup func(string) (string, bool)) error {
+	if v.Kind() == reflect.Struct && v.Type() != durationType {
+		for i := 0; i < v.NumField(); i++ {
+			tag := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+
+			err := applyEnv(v.Field(i), name+"_"+strings.ToUpper(tag), lookup)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	value, ok := lookup(name)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	switch {
+	case v.Type() == durationType:
+		err = v.Addr().Interface().(*Duration).parse(value)
+
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+
+	case v.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		v.SetBool(b)
+
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(value, 10, 64)
+		v.SetInt(i)
+
+	case v.Kind() == reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, 64)
+		v.SetFloat(f)
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		values := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+
+	default:
+		return fmt.Errorf("%s: can't be set from the environment; use the config file", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q", name, value)
+	}
+	return nil
+}
+
+/**
+ * A ValidationError lists every problem found with a config, one per line
+ */
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+/**
+ * Returns where undelivered webhook events are kept: the configured outbox, or next to the state file
+ */
+func (c *Config) WebhookOutbox() string {
+	if c.Notifiers.Webhook.Outbox != "" {
+		return c.Notifiers.Webhook.Outbox
+	}
+	if c.Storage.StateFile != "" {
+		return c.Storage.StateFile + ".outbox.json"
+	}
+	return ""
+}
+
+/**
+ * Checks the whole config, reporting every problem rather than just the first
+ */
+func (c *Config) Validate() error {
+	errs := ValidationError{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Interval.Duration > 0, "interval: must be positive")
+
+	macOS := c.Scrapers.MacOS
+	if macOS.Enabled {
+		check(macOS.Interval.Duration >= 0, "scrapers.macos.interval: must not be negative")
+		check(len(macOS.Catalogs) > 0, "scrapers.macos.catalogs: at least one catalog is needed")
+		if err := tracker.ValidateCatalogs(macOS.Catalogs); err != nil {
+			errs = append(errs, "scrapers.macos.catalogs: "+err.Error())
+		}
+		if _, err := tracker.NewReleaseLineTable(tracker.MergeReleaseLines(tracker.DefaultMacReleaseLines, macOS.ReleaseLines)); err != nil {
+			errs = append(errs, "scrapers.macos.release_lines: "+err.Error())
+		}
+		check(macOS.Workers >= 1, "scrapers.macos.workers: must be at least 1")
+		check(macOS.Rate >= 0, "scrapers.macos.rate: must not be negative")
+		check(macOS.HostRate >= 0, "scrapers.macos.host_rate: must not be negative")
+		check(macOS.Burst >= 0, "scrapers.macos.burst: must not be negative")
+	}
+
+	check(c.HTTP.Timeout.Duration > 0, "http.timeout: must be positive")
+	check(c.HTTP.MaxRetries >= 0, "http.max_retries: must not be negative")
+	check(c.HTTP.MinBackoff.Duration > 0, "http.min_backoff: must be positive")
+	check(c.HTTP.MaxBackoff.Duration >= c.HTTP.MinBackoff.Duration, "http.max_backoff: must be at least http.min_backoff")
+	check(c.HTTP.MaxBodySize >= 0, "http.max_body_size: must not be negative")
+	if c.HTTP.Proxy != "" {
+		proxyURL, err := url.Parse(c.HTTP.Proxy)
+		check(err == nil && proxyURL.Host != "", "http.proxy: %q is not a valid URL", c.HTTP.Proxy)
+	}
+	if c.HTTP.CAFile != "" {
+		_, err := os.Stat(c.HTTP.CAFile)
+		check(err == nil, "http.ca_file: %v", err)
+	}
+	if c.HTTP.ReplayDir != "" {
+		info, err := os.Stat(c.HTTP.ReplayDir)
+		if err != nil {
+			check(false, "http.replay_dir: %v", err)
+		} else {
+			check(info.IsDir(), "http.replay_dir: %s is not a directory", c.HTTP.ReplayDir)
+		}
+		check(c.HTTP.RecordDir == "", "http.record_dir: can't record while replaying")
+	}
+
+	for i, webhookURL := range c.Notifiers.Webhook.URLs {
+		parsed, err := url.Parse(webhookURL)
+		check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+			"notifiers.webhook.urls[%d]: %q is not an http(s) URL", i, webhookURL)
+	}
+	check(len(c.Notifiers.Webhook.URLs) == 0 || c.WebhookOutbox() != "",
+		"notifiers.webhook.outbox: webhooks need an outbox or storage.state_file so undelivered events survive a restart")
+	check(c.Notifiers.Webhook.MaxAttempts >= 0, "notifiers.webhook.max_attempts: must not be negative")
+
+	if err := c.Policy.Validate(); err != nil {
+		errs = append(errs, "policy."+err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
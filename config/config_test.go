@@ -0,0 +1,307 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/config"
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := config.Default().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *config.Config)
+		errors []string // Substrings of the expected problems, one per problem
+	}{
+		{
+			name:   "zero interval",
+			modify: func(c *config.Config) { c.Interval = config.Duration{} },
+			errors: []string{"interval: must be positive"},
+		},
+		{
+			name:   "no catalogs",
+			modify: func(c *config.Config) { c.Scrapers.MacOS.Catalogs = nil },
+			errors: []string{"scrapers.macos.catalogs: at least one catalog"},
+		},
+		{
+			name:   "catalogs aren't checked when macOS is disabled",
+			modify: func(c *config.Config) { c.Scrapers.MacOS.Enabled = false; c.Scrapers.MacOS.Catalogs = nil },
+		},
+		{
+			name: "bad channel",
+			modify: func(c *config.Config) {
+				c.Scrapers.MacOS.Catalogs = []tracker.Catalog{{Name: "x", URL: "https://example.com/x.sucatalog", Channel: "release"}}
+			},
+			errors: []string{"scrapers.macos.catalogs"},
+		},
+		{
+			name:   "no workers",
+			modify: func(c *config.Config) { c.Scrapers.MacOS.Workers = 0 },
+			errors: []string{"scrapers.macos.workers"},
+		},
+		{
+			name: "backoff the wrong way round",
+			modify: func(c *config.Config) {
+				c.HTTP.MinBackoff = config.Duration{time.Minute}
+				c.HTTP.MaxBackoff = config.Duration{time.Second}
+			},
+			errors: []string{"http.max_backoff"},
+		},
+		{
+			name:   "bad proxy",
+			modify: func(c *config.Config) { c.HTTP.Proxy = "not a url" },
+			errors: []string{"http.proxy"},
+		},
+		{
+			name:   "missing replay dir",
+			modify: func(c *config.Config) { c.HTTP.ReplayDir = "/does/not/exist" },
+			errors: []string{"http.replay_dir"},
+		},
+		{
+			name: "webhook that isn't http",
+			modify: func(c *config.Config) {
+				c.Notifiers.Webhook.URLs = []string{"https://example.com/hook", "ftp://example.com"}
+				c.Notifiers.Webhook.Outbox = "/var/lib/version_tracker/outbox.json"
+			},
+			errors: []string{"notifiers.webhook.urls[1]"},
+		},
+		{
+			name:   "webhook without an outbox or state file",
+			modify: func(c *config.Config) { c.Notifiers.Webhook.URLs = []string{"https://example.com/hook"} },
+			errors: []string{"notifiers.webhook.outbox"},
+		},
+		{
+			name:   "bad policy",
+			modify: func(c *config.Config) { c.Policy.GracePeriodDays = -1 },
+			errors: []string{"policy.grace_period_days"},
+		},
+		{
+			name: "every problem is reported",
+			modify: func(c *config.Config) {
+				c.Interval = config.Duration{}
+				c.HTTP.Timeout = config.Duration{}
+				c.HTTP.MaxRetries = -1
+			},
+			errors: []string{"interval", "http.timeout", "http.max_retries"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := config.Default()
+			test.modify(c)
+
+			err := c.Validate()
+			if len(test.errors) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			validationErr, ok := err.(config.ValidationError)
+			if !ok {
+				t.Fatalf("Got error %v, want a ValidationError", err)
+			}
+			if len(validationErr) != len(test.errors) {
+				t.Fatalf("Got problems %q, want %d", validationErr, len(test.errors))
+			}
+			for i, want := range test.errors {
+				if !strings.Contains(validationErr[i], want) {
+					t.Errorf("Problem %d is %q, want it to mention %q", i, validationErr[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		check func(t *testing.T, c *config.Config)
+		err   string
+	}{
+		{
+			name: "settings left out keep their default",
+			data: `{"interval": "10m", "http": {"timeout": 5}}`,
+			check: func(t *testing.T, c *config.Config) {
+				if c.Interval.Duration != 10*time.Minute || c.HTTP.Timeout.Duration != 5*time.Second {
+					t.Errorf("Got interval %v and timeout %v, want 10m and 5s", c.Interval, c.HTTP.Timeout)
+				}
+				if c.Listen != config.Default().Listen || !c.Scrapers.MacOS.Enabled {
+					t.Errorf("Defaults were lost: %+v", c)
+				}
+			},
+		},
+		{
+			name: "catalogs default to the release channel",
+			data: `{"scrapers": {"macos": {"catalogs": [{"name": "x", "url": "https://example.com/x.sucatalog"}]}}}`,
+			check: func(t *testing.T, c *config.Config) {
+				if len(c.Scrapers.MacOS.Catalogs) != 1 || c.Scrapers.MacOS.Catalogs[0].Channel != tracker.ChannelRelease {
+					t.Errorf("Got catalogs %+v, want one Release catalog", c.Scrapers.MacOS.Catalogs)
+				}
+			},
+		},
+		{
+			name: "unknown settings",
+			data: `{"intervall": "10m"}`,
+			err:  "unknown field",
+		},
+		{
+			name: "bad duration",
+			data: `{"interval": "soon"}`,
+			err:  "invalid duration",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			err := ioutil.WriteFile(path, []byte(test.data), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := config.Load(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Got error %v, want one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, c)
+		})
+	}
+}
+
+func TestLoadWithoutAPathReturnsTheDefaults(t *testing.T) {
+	c, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, config.Default()) {
+		t.Errorf("Got %+v, want the defaults", c)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(c *config.Config) bool
+		err   string
+	}{
+		{
+			name:  "duration",
+			env:   map[string]string{"VERSION_TRACKER_HTTP_TIMEOUT": "10s"},
+			check: func(c *config.Config) bool { return c.HTTP.Timeout.Duration == 10*time.Second },
+		},
+		{
+			name:  "duration in seconds",
+			env:   map[string]string{"VERSION_TRACKER_INTERVAL": "90"},
+			check: func(c *config.Config) bool { return c.Interval.Duration == 90*time.Second },
+		},
+		{
+			name:  "nested bool",
+			env:   map[string]string{"VERSION_TRACKER_SCRAPERS_MACOS_ENABLED": "false"},
+			check: func(c *config.Config) bool { return !c.Scrapers.MacOS.Enabled },
+		},
+		{
+			name:  "string",
+			env:   map[string]string{"VERSION_TRACKER_STORAGE_STATE_FILE": "/var/lib/state.json"},
+			check: func(c *config.Config) bool { return c.Storage.StateFile == "/var/lib/state.json" },
+		},
+		{
+			name:  "int and float",
+			env:   map[string]string{"VERSION_TRACKER_SCRAPERS_MACOS_WORKERS": "8", "VERSION_TRACKER_SCRAPERS_MACOS_RATE": "2.5"},
+			check: func(c *config.Config) bool { return c.Scrapers.MacOS.Workers == 8 && c.Scrapers.MacOS.Rate == 2.5 },
+		},
+		{
+			name: "comma-separated list",
+			env:  map[string]string{"VERSION_TRACKER_NOTIFIERS_WEBHOOK_URLS": "https://a.example.com, ,https://b.example.com"},
+			check: func(c *config.Config) bool {
+				return reflect.DeepEqual(c.Notifiers.Webhook.URLs, []string{"https://a.example.com", "https://b.example.com"})
+			},
+		},
+		{
+			name:  "policy",
+			env:   map[string]string{"VERSION_TRACKER_POLICY_GRACE_PERIOD_DAYS": "30"},
+			check: func(c *config.Config) bool { return c.Policy.GracePeriodDays == 30 },
+		},
+		{
+			name: "bad bool",
+			env:  map[string]string{"VERSION_TRACKER_DEBUG": "sometimes"},
+			err:  "VERSION_TRACKER_DEBUG: invalid value",
+		},
+		{
+			name: "bad int",
+			env:  map[string]string{"VERSION_TRACKER_HTTP_MAX_RETRIES": "three"},
+			err:  "VERSION_TRACKER_HTTP_MAX_RETRIES",
+		},
+		{
+			name: "settings that can't come from the environment",
+			env:  map[string]string{"VERSION_TRACKER_SCRAPERS_MACOS_CATALOGS": "14"},
+			err:  "use the config file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := config.Default()
+			err := c.ApplyEnv(func(name string) (string, bool) {
+				value, ok := test.env[name]
+				return value, ok
+			})
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Got error %v, want one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(c) {
+				t.Errorf("Environment %v wasn't applied: %+v", test.env, c)
+			}
+		})
+	}
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	c := config.Default()
+	if got := c.WebhookOutbox(); got != "" {
+		t.Errorf("WebhookOutbox() = %q with no state file, want none", got)
+	}
+
+	c.Storage.StateFile = "/var/lib/version_tracker/state.json"
+	if got, want := c.WebhookOutbox(), "/var/lib/version_tracker/state.json.outbox.json"; got != want {
+		t.Errorf("WebhookOutbox() = %q, want %q next to the state file", got, want)
+	}
+
+	c.Notifiers.Webhook.URLs = []string{"https://example.com/hook"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Webhooks with a state file: %v", err)
+	}
+
+	c.Notifiers.Webhook.Outbox = "/var/spool/outbox.json"
+	if got := c.WebhookOutbox(); got != c.Notifiers.Webhook.Outbox {
+		t.Errorf("WebhookOutbox() = %q, want the configured %q", got, c.Notifiers.Webhook.Outbox)
+	}
+}
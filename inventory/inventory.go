@@ -0,0 +1,128 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+/**
+ * A Host is a single machine from the fleet inventory
+ */
+type Host struct {
+	Hostname string `json:"hostname"`
+	OSType   string `json:"os_type"`
+	Version  string `json:"version"`
+	Build    string `json:"build,omitempty"`
+	Model    string `json:"model,omitempty"`
+}
+
+/**
+ * Loads an inventory from a .csv or .json file
+ */
+func LoadFile(path string) ([]Host, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ParseCSV(f)
+	case ".json":
+		return ParseJSON(f)
+	default:
+		return nil, fmt.Errorf("Unknown inventory format %q; expected .csv or .json", filepath.Ext(path))
+	}
+}
+
+/**
+ * Parses a JSON list of hosts, e.g. [{"hostname": "a", "os_type": "macOS", "version": "14.7.1"}]
+ */
+func ParseJSON(r io.Reader) ([]Host, error) {
+	var hosts []Host
+	err := json.NewDecoder(r).Decode(&hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalize(hosts)
+}
+
+/**
+ * Parses a CSV inventory with a header row. Columns are matched by name
+ * (hostname, os_type, version, build, model) in any order; unknown columns are ignored.
+ */
+func ParseCSV(r io.Reader) ([]Host, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["hostname"]; !ok {
+		return nil, errors.New("Inventory has no hostname column")
+	}
+	if _, ok := columns["version"]; !ok {
+		return nil, errors.New("Inventory has no version column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	hosts := []Host{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		hosts = append(hosts, Host{
+			Hostname: field(record, "hostname"),
+			OSType:   field(record, "os_type"),
+			Version:  field(record, "version"),
+			Build:    field(record, "build"),
+			Model:    field(record, "model"),
+		})
+	}
+
+	return normalize(hosts)
+}
+
+/**
+ * Hosts without an OS type are assumed to be Macs
+ */
+func normalize(hosts []Host) ([]Host, error) {
+	for i := range hosts {
+		if hosts[i].Hostname == "" {
+			return nil, fmt.Errorf("Host %d has no hostname", i+1)
+		}
+		if hosts[i].OSType == "" {
+			hosts[i].OSType = tracker.OSTypeMac
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,159 @@
+package inventory_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/phoebesimon/version_tracker/inventory"
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		hosts []inventory.Host
+		err   string
+	}{
+		{
+			name:  "all columns",
+			input: "hostname,os_type,version,build,model\na,macOS,14.7.1,23H222,Mac14\n",
+			hosts: []inventory.Host{{Hostname: "a", OSType: "macOS", Version: "14.7.1", Build: "23H222", Model: "Mac14"}},
+		},
+		{
+			name:  "columns in any order, with unknown ones and odd spacing",
+			input: "Version, owner , HOSTNAME\n 15.1 ,someone, b \n",
+			hosts: []inventory.Host{{Hostname: "b", OSType: tracker.OSTypeMac, Version: "15.1"}},
+		},
+		{
+			name:  "short rows leave the missing columns empty",
+			input: "hostname,version,build\nc,13.7\n",
+			hosts: []inventory.Host{{Hostname: "c", OSType: tracker.OSTypeMac, Version: "13.7"}},
+		},
+		{
+			name:  "header only",
+			input: "hostname,version\n",
+			hosts: []inventory.Host{},
+		},
+		{
+			name:  "no hostname column",
+			input: "name,version\na,14.7\n",
+			err:   "no hostname column",
+		},
+		{
+			name:  "no version column",
+			input: "hostname,os\na,macOS\n",
+			err:   "no version column",
+		},
+		{
+			name:  "row without a hostname",
+			input: "hostname,version\na,14.7\n,14.6\n",
+			err:   "Host 2 has no hostname",
+		},
+		{
+			name:  "unterminated quote",
+			input: "hostname,version\n\"a,14.7\n",
+			err:   "quote",
+		},
+		{
+			name:  "empty",
+			input: "",
+			err:   "EOF",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := inventory.ParseCSV(strings.NewReader(test.input))
+			checkParse(t, hosts, err, test.hosts, test.err)
+		})
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		hosts []inventory.Host
+		err   string
+	}{
+		{
+			name:  "hosts",
+			input: `[{"hostname": "a", "os_type": "macOS", "version": "14.7.1", "build": "23H222"}, {"hostname": "b", "version": "15.1"}]`,
+			hosts: []inventory.Host{
+				{Hostname: "a", OSType: "macOS", Version: "14.7.1", Build: "23H222"},
+				{Hostname: "b", OSType: tracker.OSTypeMac, Version: "15.1"},
+			},
+		},
+		{
+			name:  "host without a hostname",
+			input: `[{"version": "15.1"}]`,
+			err:   "Host 1 has no hostname",
+		},
+		{
+			name:  "not a list",
+			input: `{"hostname": "a"}`,
+			err:   "cannot unmarshal",
+		},
+		{
+			name:  "truncated",
+			input: `[{"hostname": "a"`,
+			err:   "EOF",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := inventory.ParseJSON(strings.NewReader(test.input))
+			checkParse(t, hosts, err, test.hosts, test.err)
+		})
+	}
+}
+
+func checkParse(t *testing.T, hosts []inventory.Host, err error, wantHosts []inventory.Host, wantErr string) {
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("Got error %v, want one containing %q", err, wantErr)
+		}
+		return
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hosts, wantHosts) {
+		t.Errorf("Got hosts %+v, want %+v", hosts, wantHosts)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"hosts.csv":  "hostname,version\na,14.7\n",
+		"hosts.JSON": `[{"hostname": "a", "version": "14.7"}]`,
+		"hosts.txt":  "a 14.7\n",
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"hosts.csv", "hosts.JSON"} {
+		hosts, err := inventory.LoadFile(filepath.Join(dir, name))
+		if err != nil || len(hosts) != 1 || hosts[0].Hostname != "a" {
+			t.Errorf("LoadFile(%s) = %+v, %v; want host a", name, hosts, err)
+		}
+	}
+
+	if _, err := inventory.LoadFile(filepath.Join(dir, "hosts.txt")); err == nil {
+		t.Error("Expected an error for an unknown inventory format")
+	}
+	if _, err := inventory.LoadFile(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,204 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+/**
+ * A host along with how it measured up
+ */
+type HostResult struct {
+	Host
+	Result *tracker.ComplianceResult `json:"result"`
+}
+
+/**
+ * Hosts that share a status, release line and number of releases behind
+ */
+type Group struct {
+	Status        string   `json:"status"`
+	OSType        string   `json:"os_type"`
+	Line          string   `json:"line"`
+	PatchesBehind int      `json:"patches_behind"`
+	Count         int      `json:"count"`
+	Hosts         []string `json:"hosts"`
+}
+
+type Report struct {
+	GeneratedAt time.Time                `json:"generated_at"`
+	Policy      tracker.CompliancePolicy `json:"policy"`
+	Summary     map[string]int           `json:"summary"` // Status --> number of hosts
+	Groups      []Group                  `json:"groups"`
+	Hosts       []HostResult             `json:"hosts"`
+}
+
+/**
+ * Evaluates every host and groups the results. Hosts whose version can't be
+ * evaluated at all (e.g. it doesn't parse) are reported as failing with the reason.
+ */
+func MakeReport(evaluator *tracker.Evaluator, hosts []Host) *Report {
+	report := &Report{
+		GeneratedAt: time.Now(),
+		Policy:      evaluator.Policy(),
+		Summary: map[string]int{
+			tracker.ComplianceStatusPass:    0,
+			tracker.ComplianceStatusWarn:    0,
+			tracker.ComplianceStatusFail:    0,
+			tracker.ComplianceStatusUnknown: 0,
+		},
+		Groups: []Group{},
+		Hosts:  make([]HostResult, 0, len(hosts)),
+	}
+
+	groups := make(map[string]*Group)
+	for _, host := range hosts {
+		result, err := evaluator.Evaluate(host.OSType, host.Version, host.Build)
+		if err != nil {
+			result = &tracker.ComplianceResult{
+				OSType:  host.OSType,
+				Version: host.Version,
+				Build:   host.Build,
+				Status:  tracker.ComplianceStatusFail,
+				Reasons: []string{err.Error()},
+			}
+		}
+
+		report.Hosts = append(report.Hosts, HostResult{Host: host, Result: result})
+		report.Summary[result.Status]++
+
+		id := strings.Join([]string{result.Status, result.OSType, result.Line, strconv.Itoa(result.PatchesBehind)}, "/")
+		group, ok := groups[id]
+		if !ok {
+			group = &Group{
+				Status:        result.Status,
+				OSType:        result.OSType,
+				Line:          result.Line,
+				PatchesBehind: result.PatchesBehind,
+			}
+			groups[id] = group
+		}
+		group.Count++
+		group.Hosts = append(group.Hosts, host.Hostname)
+	}
+
+	for _, group := range groups {
+		sort.Strings(group.Hosts)
+		report.Groups = append(report.Groups, *group)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if a.Status != b.Status {
+			return statusRank(a.Status) < statusRank(b.Status)
+		}
+		if a.OSType != b.OSType {
+			return a.OSType < b.OSType
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.PatchesBehind > b.PatchesBehind
+	})
+
+	sort.SliceStable(report.Hosts, func(i, j int) bool {
+		return report.Hosts[i].Hostname < report.Hosts[j].Hostname
+	})
+
+	return report
+}
+
+/**
+ * Failing hosts come first, then the ones that need looking at
+ */
+func statusRank(status string) int {
+	switch status {
+	case tracker.ComplianceStatusFail:
+		return 0
+	case tracker.ComplianceStatusWarn:
+		return 1
+	case tracker.ComplianceStatusUnknown:
+		return 2
+	default:
+		return 3
+	}
+}
+
+/**
+ * Writes the report as a table (groups, then hosts), JSON (everything) or CSV (one row per host)
+ */
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatTable:
+		return r.writeTable(w)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case FormatCSV:
+		return r.writeCSV(w)
+	default:
+		return fmt.Errorf("Unknown format %q", format)
+	}
+}
+
+func (r *Report) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%d pass, %d warn, %d fail, %d unknown\n\n",
+		r.Summary[tracker.ComplianceStatusPass], r.Summary[tracker.ComplianceStatusWarn], r.Summary[tracker.ComplianceStatusFail], r.Summary[tracker.ComplianceStatusUnknown])
+
+	fmt.Fprintln(tw, "STATUS\tOS\tLINE\tBEHIND\tHOSTS")
+	for _, group := range r.Groups {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", group.Status, group.OSType, group.Line, group.PatchesBehind, group.Count)
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "HOSTNAME\tMODEL\tOS\tVERSION\tBUILD\tLINE\tLATEST\tBEHIND\tSTATUS\tREASON")
+	for _, host := range r.Hosts {
+		result := host.Result
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			host.Hostname, host.Model, host.OSType, host.Version, host.Build, result.Line,
+			result.LatestVersion, result.PatchesBehind, result.Status, strings.Join(result.Reasons, "; "))
+	}
+
+	return tw.Flush()
+}
+
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write([]string{"hostname", "model", "os_type", "version", "build", "line", "latest_version", "latest_build", "patches_behind", "newest_missing_version", "supported", "status", "reasons"})
+	if err != nil {
+		return err
+	}
+
+	for _, host := range r.Hosts {
+		result := host.Result
+		err = cw.Write([]string{
+			host.Hostname, host.Model, host.OSType, host.Version, host.Build, result.Line,
+			result.LatestVersion, result.LatestBuild, strconv.Itoa(result.PatchesBehind), result.NewestMissingVersion,
+			strconv.FormatBool(result.Supported), result.Status, strings.Join(result.Reasons, "; "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
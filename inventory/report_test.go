@@ -0,0 +1,111 @@
+package inventory_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/inventory"
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+)
+
+func makeReport(t *testing.T, hosts []inventory.Host) *inventory.Report {
+	versionTracker := tracker.MakeTracker(300)
+	err := versionTracker.Register(&trackertest.Scraper{Records: []tracker.ProductRecord{
+		trackertest.Release("a", "Sequoia", "15.1", "24B83", time.Now().Add(-30*24*time.Hour)),
+		trackertest.Release("b", "Sequoia", "15.2", "24C101", time.Now().Add(-24*time.Hour)),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionTracker.Scrape(context.Background())
+
+	evaluator, err := tracker.NewEvaluator(versionTracker, tracker.DefaultCompliancePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return inventory.MakeReport(evaluator, hosts)
+}
+
+func TestMakeReport(t *testing.T) {
+	report := makeReport(t, []inventory.Host{
+		{Hostname: "d", OSType: tracker.OSTypeMac, Version: "15.2"},
+		{Hostname: "c", OSType: tracker.OSTypeMac, Version: "15.1"},
+		{Hostname: "b", OSType: tracker.OSTypeMac, Version: "15.2", Build: "24C101"},
+		{Hostname: "a", OSType: tracker.OSTypeMac, Version: "garbage"},
+	})
+
+	wantSummary := map[string]int{
+		tracker.ComplianceStatusPass: 2,
+		tracker.ComplianceStatusWarn: 1,
+		tracker.ComplianceStatusFail: 1,
+	}
+	for status, count := range wantSummary {
+		if report.Summary[status] != count {
+			t.Errorf("Summary[%s] = %d, want %d", status, report.Summary[status], count)
+		}
+	}
+
+	// Failing groups first
+	if len(report.Groups) != 3 || report.Groups[0].Status != tracker.ComplianceStatusFail || report.Groups[2].Count != 2 {
+		t.Errorf("Got groups %+v, want fail, warn, then both passing hosts", report.Groups)
+	}
+
+	hostnames := []string{}
+	for _, host := range report.Hosts {
+		hostnames = append(hostnames, host.Hostname)
+	}
+	if strings.Join(hostnames, ",") != "a,b,c,d" {
+		t.Errorf("Hosts are in order %v, want sorted by hostname", hostnames)
+	}
+
+	// A version that doesn't parse fails with the reason rather than failing the report
+	garbage := report.Hosts[0].Result
+	if garbage.Status != tracker.ComplianceStatusFail || len(garbage.Reasons) != 1 || !strings.Contains(garbage.Reasons[0], "garbage") {
+		t.Errorf("Unparseable version got %+v, want a failure naming it", garbage)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	report := makeReport(t, []inventory.Host{
+		{Hostname: "a", OSType: tracker.OSTypeMac, Version: "15.1", Model: "Mac14,2"},
+	})
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{inventory.FormatTable, "0 pass, 1 warn, 0 fail"},
+		{inventory.FormatJSON, `"patches_behind": 1`},
+		{inventory.FormatCSV, "a,\"Mac14,2\",macOS,15.1,,Sequoia,15.2.0,24C101,1,15.2.0,true,warn,"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := report.Write(&buf, test.format)
+		if err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if !strings.Contains(buf.String(), test.want) {
+			t.Errorf("%s output doesn't contain %q:\n%s", test.format, test.want, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+
+	// Every CSV row has every column
+	buf.Reset()
+	report.Write(&buf, inventory.FormatCSV)
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 2 || len(rows[0]) != len(rows[1]) {
+		t.Errorf("Got CSV rows %v (%v), want a header and one host", rows, err)
+	}
+}
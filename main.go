@@ -5,7 +5,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/phoebesimon/version_tracker/api"
+	"github.com/phoebesimon/version_tracker/config"
+	"github.com/phoebesimon/version_tracker/metrics"
+	"github.com/phoebesimon/version_tracker/notify"
 	"github.com/phoebesimon/version_tracker/tracker"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -13,50 +18,495 @@ import (
 
 var Version = "0.0.0"
 
-func main() {
+/**
+ * Loads the --config file (if any), then applies environment overrides and finally any
+ * flags given on the command line, and validates the result
+ */
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	cfg, err := config.Load(c.GlobalString("config"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyFlags(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+/**
+ * Flags override the config file, but only where they were actually given
+ */
+func applyFlags(c *cli.Context, cfg *config.Config) error {
+	if c.GlobalIsSet("interval") {
+		cfg.Interval.Duration = time.Duration(c.GlobalInt("interval")) * time.Second
+	}
+	if c.GlobalIsSet("debug") {
+		cfg.Debug = true
+	}
+	if c.GlobalIsSet("state-file") {
+		cfg.Storage.StateFile = c.GlobalString("state-file")
+	}
+
+	macOS := &cfg.Scrapers.MacOS
+	if catalogsFile := c.GlobalString("catalogs"); catalogsFile != "" {
+		catalogs, err := tracker.LoadCatalogs(catalogsFile)
+		if err != nil {
+			return err
+		}
+		macOS.Catalogs = catalogs
+	}
+	if releaseLinesFile := c.GlobalString("release-lines"); releaseLinesFile != "" {
+		releaseLines, err := tracker.LoadReleaseLines(releaseLinesFile)
+		if err != nil {
+			return err
+		}
+		macOS.ReleaseLines = tracker.MergeReleaseLines(macOS.ReleaseLines, releaseLines)
+	}
+	if c.GlobalIsSet("fetch-workers") {
+		macOS.Workers = c.GlobalInt("fetch-workers")
+	}
+	if c.GlobalIsSet("fetch-rate") {
+		macOS.Rate = c.GlobalFloat64("fetch-rate")
+	}
+	if c.GlobalIsSet("fetch-host-rate") {
+		macOS.HostRate = c.GlobalFloat64("fetch-host-rate")
+	}
+	if c.GlobalIsSet("fetch-burst") {
+		macOS.Burst = c.GlobalInt("fetch-burst")
+	}
+
+	if c.GlobalIsSet("http-timeout") {
+		cfg.HTTP.Timeout.Duration = c.GlobalDuration("http-timeout")
+	}
+	if c.GlobalIsSet("http-proxy") {
+		cfg.HTTP.Proxy = c.GlobalString("http-proxy")
+	}
+	if c.GlobalIsSet("http-ca-file") {
+		cfg.HTTP.CAFile = c.GlobalString("http-ca-file")
+	}
+	if c.GlobalIsSet("http-max-retries") {
+		cfg.HTTP.MaxRetries = c.GlobalInt("http-max-retries")
+	}
+	if c.GlobalIsSet("http-max-body-size") {
+		cfg.HTTP.MaxBodySize = c.GlobalInt64("http-max-body-size")
+	}
+	if c.GlobalIsSet("replay-dir") {
+		cfg.HTTP.ReplayDir = c.GlobalString("replay-dir")
+	}
+	if c.GlobalIsSet("record-dir") {
+		cfg.HTTP.RecordDir = c.GlobalString("record-dir")
+	}
+
+	if urls := c.GlobalStringSlice("webhook-url"); len(urls) > 0 {
+		cfg.Notifiers.Webhook.URLs = urls
+	}
+	// Also picks up $VERSION_TRACKER_WEBHOOK_SECRET, which predates the config file
+	if secret := c.GlobalString("webhook-secret"); secret != "" {
+		cfg.Notifiers.Webhook.Secret = secret
+	}
+	if c.GlobalIsSet("webhook-outbox") {
+		cfg.Notifiers.Webhook.Outbox = c.GlobalString("webhook-outbox")
+	}
+
+	if policyFile := c.GlobalString("policy"); policyFile != "" {
+		policy, err := tracker.LoadCompliancePolicy(policyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Policy = policy
+	}
+
+	return nil
+}
+
+/**
+ * Builds a tracker from the config, with its scrapers registered and any saved state loaded
+ */
+func setupTracker(c *cli.Context) (*tracker.Tracker, *config.Config, error) {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	setLogLevel(cfg)
+
+	versionTracker := tracker.MakeTracker(int(cfg.Interval.Seconds()))
+
+	if macOS := cfg.Scrapers.MacOS; macOS.Enabled {
+		macScraper, err := makeMacScraper(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = versionTracker.Register(macScraper)
+		if err != nil {
+			return nil, nil, err
+		}
+		versionTracker.SetSourceInterval(macScraper.Name(), macOS.Interval.Duration)
+	}
+
+	if cfg.Storage.StateFile != "" {
+		err = versionTracker.LoadState(tracker.NewFileStorage(cfg.Storage.StateFile))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return versionTracker, cfg, nil
+}
+
+func setLogLevel(cfg *config.Config) {
+	if cfg.Debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+}
+
+func makeMacScraper(cfg *config.Config) (*tracker.MacScraper, error) {
+	settings, err := makeMacScraperSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	macScraper, err := tracker.NewMacScraper(settings.catalogs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = settings.apply(macScraper)
+	if err != nil {
+		return nil, err
+	}
+
+	return macScraper, nil
+}
+
+/**
+ * Everything in the config that applies to an existing macOS scraper, built and checked
+ * up front so that a bad config is rejected before any of it is applied
+ */
+type macScraperSettings struct {
+	catalogs     []tracker.Catalog
+	releaseLines *tracker.ReleaseLineTable
+	fetcher      tracker.Fetcher
+	fetchOptions tracker.FetchOptions
+}
+
+func makeMacScraperSettings(cfg *config.Config) (*macScraperSettings, error) {
+	macOS := cfg.Scrapers.MacOS
+
+	err := tracker.ValidateCatalogs(macOS.Catalogs)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseLines, err := tracker.NewReleaseLineTable(tracker.MergeReleaseLines(tracker.DefaultMacReleaseLines, macOS.ReleaseLines))
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, err := makeFetcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	fetchOptions := tracker.FetchOptions{
+		Workers:    macOS.Workers,
+		GlobalRate: macOS.Rate,
+		HostRate:   macOS.HostRate,
+		Burst:      macOS.Burst,
+
+		// Loaded state would otherwise keep cached URLs out of the recording
+		Unconditional: cfg.HTTP.RecordDir != "",
+	}
+	err = fetchOptions.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &macScraperSettings{
+		catalogs:     macOS.Catalogs,
+		releaseLines: releaseLines,
+		fetcher:      fetcher,
+		fetchOptions: fetchOptions,
+	}, nil
+}
+
+/**
+ * Applies the settings to the scraper. Everything was checked when the settings were made,
+ * so this only fails if that was skipped.
+ */
+func (s *macScraperSettings) apply(macScraper *tracker.MacScraper) error {
+	err := macScraper.SetCatalogs(s.catalogs)
+	if err != nil {
+		return err
+	}
+
+	err = macScraper.SetFetchOptions(s.fetchOptions)
+	if err != nil {
+		return err
+	}
+
+	macScraper.SetReleaseLines(s.releaseLines)
+	macScraper.SetFetcher(s.fetcher)
+	return nil
+}
+
+/**
+ * Returns the fetcher for the scrapers: the network, optionally recording what it fetches,
+ * or a directory of responses recorded earlier
+ */
+func makeFetcher(cfg *config.Config) (tracker.Fetcher, error) {
+	if cfg.HTTP.ReplayDir != "" {
+		return tracker.NewReplayFetcher(cfg.HTTP.ReplayDir)
+	}
+
+	fetcher, err := tracker.NewHTTPFetcher(tracker.HTTPConfig{
+		Timeout:     cfg.HTTP.Timeout.Duration,
+		ProxyURL:    cfg.HTTP.Proxy,
+		CAFile:      cfg.HTTP.CAFile,
+		UserAgent:   cfg.HTTP.UserAgent + "/" + Version,
+		MaxRetries:  cfg.HTTP.MaxRetries,
+		MinBackoff:  cfg.HTTP.MinBackoff.Duration,
+		MaxBackoff:  cfg.HTTP.MaxBackoff.Duration,
+		MaxBodySize: cfg.HTTP.MaxBodySize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.HTTP.RecordDir != "" {
+		return tracker.NewRecordingFetcher(fetcher, cfg.HTTP.RecordDir), nil
+	}
+	return fetcher, nil
+}
+
+func makeEvaluator(cfg *config.Config, versionTracker *tracker.Tracker) (*tracker.Evaluator, error) {
+	return tracker.NewEvaluator(versionTracker, cfg.Policy)
+}
+
+/**
+ * Runs the tracker until we get a SIGINT/SIGTERM.
+ * If serve is set the API is served as well.
+ */
+func runTracker(c *cli.Context, serve bool) error {
+	versionTracker, cfg, err := setupTracker(c)
+	if err != nil {
+		return err
+	}
+
+	metrics.MustRegister(versionTracker.Collectors()...)
+
+	var webhooks *notify.WebhookNotifier
+	if len(cfg.Notifiers.Webhook.URLs) > 0 {
+		webhooks, err = notify.NewWebhookNotifier(makeWebhookConfig(cfg))
+		if err != nil {
+			return err
+		}
+		versionTracker.AddNotifier(webhooks)
+	}
+
+	var server *api.Server
+	if serve {
+		listenAddr := cfg.Listen
+		if c.IsSet("listen") {
+			listenAddr = c.String("listen")
+		}
+
+		evaluator, err := makeEvaluator(cfg, versionTracker)
+		if err != nil {
+			return err
+		}
+
+		server = api.NewServer(versionTracker, evaluator, listenAddr)
+		server.SetAdminToken(cfg.AdminToken)
+		err = server.Start()
+		if err != nil {
+			return err
+		}
+	}
+
+	done := make(chan os.Signal, 1)
+	hup := make(chan os.Signal, 1)
+
+	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	reloader := &reloader{
+		c:        c,
+		ctx:      ctx,
+		tracker:  versionTracker,
+		server:   server,
+		webhooks: webhooks,
+		cfg:      cfg,
+	}
+	if server != nil {
+		server.SetReloadFunc(reloader.Reload)
+	}
+
+	go versionTracker.Start(ctx)
+	if webhooks != nil {
+		go webhooks.Start(ctx)
+	}
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+
+		case <-hup:
+			err := reloader.Reload()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"timestamp": time.Now().UnixNano(),
+					"err":       err,
+				}).Error("Error reloading config; keeping the old one")
+			}
+		}
+	}
+	cancel()
+
+	if server != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+
+		err = server.Close(shutdownCtx)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("error shutting down API")
+		}
+	}
+
+	versionTracker.Close()
+	reloader.Close()
+
+	return nil
+}
+
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "latest-os-version-tracker"
+	app.Version = Version
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "JSON config file; flags and $VERSION_TRACKER_* variables override it",
+			EnvVar: "VERSION_TRACKER_CONFIG",
+		},
 		cli.IntFlag{
 			Name:  "interval",
 			Usage: "How often (in seconds) to check if a new patch is out (defaults to 300)",
-			Value: 300,
+		},
+		cli.StringFlag{
+			Name:  "state-file",
+			Usage: "Where to persist known versions between restarts (disabled if empty)",
+		},
+		cli.StringFlag{
+			Name:  "catalogs",
+			Usage: "JSON file listing the software update catalogs to track (defaults to the built-in list)",
+		},
+		cli.StringFlag{
+			Name:  "release-lines",
+			Usage: "JSON file of extra macOS release lines, e.g. [{\"prefix\": \"16\", \"name\": \"NextOS\"}]",
+		},
+		cli.IntFlag{
+			Name:  "fetch-workers",
+			Usage: "How many catalog/distribution fetches to run at once (defaults to 8)",
+		},
+		cli.Float64Flag{
+			Name:  "fetch-rate",
+			Usage: "Maximum requests per second across all hosts, 0 for unlimited (defaults to 20)",
+		},
+		cli.Float64Flag{
+			Name:  "fetch-host-rate",
+			Usage: "Maximum requests per second to any one host, 0 for unlimited (defaults to 10)",
+		},
+		cli.IntFlag{
+			Name:  "fetch-burst",
+			Usage: "How many requests may be made back to back before the rate limits apply (defaults to 5)",
+		},
+		cli.StringFlag{
+			Name:  "policy",
+			Usage: "JSON compliance policy, e.g. {\"max_patches_behind\": 1, \"grace_period_days\": 14, \"supported_lines\": 3}",
+		},
+		cli.DurationFlag{
+			Name:  "http-timeout",
+			Usage: "Timeout for each HTTP request, including reading the body (defaults to 30s)",
+		},
+		cli.StringFlag{
+			Name:  "http-proxy",
+			Usage: "Proxy to send requests through (defaults to $HTTPS_PROXY/$HTTP_PROXY)",
+		},
+		cli.StringFlag{
+			Name:  "http-ca-file",
+			Usage: "PEM file of extra CA certificates to trust, e.g. for a TLS-intercepting proxy",
+		},
+		cli.IntFlag{
+			Name:  "http-max-retries",
+			Usage: "How many times to retry a request that fails with a network error, 5xx or 429 (defaults to 3)",
+		},
+		cli.Int64Flag{
+			Name:  "http-max-body-size",
+			Usage: "Largest response body to read, in bytes, 0 for unlimited (defaults to 64MiB)",
+		},
+		cli.StringFlag{
+			Name:  "replay-dir",
+			Usage: "Read catalogs and distributions from responses recorded in this directory instead of the network",
+		},
+		cli.StringFlag{
+			Name:  "record-dir",
+			Usage: "Save every response fetched into this directory, for --replay-dir to read later",
+		},
+		cli.StringSliceFlag{
+			Name:  "webhook-url",
+			Usage: "URL to POST new version events to (may be repeated)",
+		},
+		cli.StringFlag{
+			Name:   "webhook-secret",
+			Usage:  "Secret used to sign webhook bodies with HMAC-SHA256",
+			EnvVar: "VERSION_TRACKER_WEBHOOK_SECRET",
+		},
+		cli.StringFlag{
+			Name:  "webhook-outbox",
+			Usage: "Where to keep undelivered webhook events between restarts (default: next to the state file)",
 		},
 		cli.BoolFlag{
 			Name:  "debug",
 			Usage: "Enables debug-level logging",
 		},
 	}
+	app.Commands = commands()
 
 	app.Action = func(c *cli.Context) error {
-		interval := c.Int("interval")
-
-		if c.IsSet("debug") {
-			log.SetLevel(log.DebugLevel)
-		}
-
-		done := make(chan os.Signal, 1)
-
-		signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
-
-		ctx, cancel := context.WithCancel(context.Background())
-
-		versionTracker := tracker.MakeTracker(interval)
-		go versionTracker.Start(ctx)
-
-		<-done
-		cancel()
-
-		versionTracker.Close()
-
-		return nil
+		return runTracker(c, false)
 	}
 
-	err := app.Run(os.Args)
+	return app
+}
+
+func main() {
+	err := newApp().Run(os.Args)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
-		}).Error("error running burrow-exporter")
+		}).Error("error running latest-os-version-tracker")
 		os.Exit(1)
 	}
 
@@ -0,0 +1,349 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+/**
+ * A Collector writes one or more metric families in the Prometheus text format
+ */
+type Collector interface {
+	Write(w io.Writer) error
+}
+
+type Registry struct {
+	collectors []Collector
+	mtx        sync.RWMutex
+}
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) MustRegister(collectors ...Collector) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.collectors = append(r.collectors, collectors...)
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	for _, c := range r.collectors {
+		if err := c.Write(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Returns a handler that serves every collector in the registry
+ */
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+
+		buf := bufio.NewWriter(w)
+		err := r.Write(buf)
+		if err == nil {
+			err = buf.Flush()
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"timestamp": time.Now().UnixNano(),
+				"err":       err,
+			}).Error("Error writing metrics")
+		}
+	})
+}
+
+func MustRegister(collectors ...Collector) {
+	DefaultRegistry.MustRegister(collectors...)
+}
+
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+/**
+ * CounterVec is a set of counters partitioned by label values
+ */
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+	values     map[string]*counterValue
+	mtx        sync.Mutex
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+type Counter struct {
+	vec   *CounterVec
+	value *counterValue
+}
+
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]*counterValue),
+	}
+}
+
+func (c *CounterVec) WithLabelValues(labelValues ...string) Counter {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	value, ok := c.values[key]
+	if !ok {
+		value = &counterValue{labelValues: labelValues}
+		c.values[key] = value
+	}
+
+	return Counter{vec: c, value: value}
+}
+
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+func (c Counter) Add(v float64) {
+	c.vec.mtx.Lock()
+	defer c.vec.mtx.Unlock()
+
+	c.value.value += v
+}
+
+func (c *CounterVec) Write(w io.Writer) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	samples := make([]Sample, 0, len(c.values))
+	for _, value := range c.values {
+		samples = append(samples, Sample{LabelValues: value.labelValues, Value: value.value})
+	}
+
+	return writeFamily(w, c.name, c.help, "counter", c.labelNames, samples)
+}
+
+/**
+ * HistogramVec is a set of histograms partitioned by label values
+ */
+type HistogramVec struct {
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+	values     map[string]*histogramValue
+	mtx        sync.Mutex
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+type Histogram struct {
+	vec   *HistogramVec
+	value *histogramValue
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &HistogramVec{
+		name:       name,
+		help:       help,
+		buckets:    sorted,
+		labelNames: labelNames,
+		values:     make(map[string]*histogramValue),
+	}
+}
+
+func (h *HistogramVec) WithLabelValues(labelValues ...string) Histogram {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = value
+	}
+
+	return Histogram{vec: h, value: value}
+}
+
+func (h Histogram) Observe(v float64) {
+	h.vec.mtx.Lock()
+	defer h.vec.mtx.Unlock()
+
+	for i, upperBound := range h.vec.buckets {
+		if v <= upperBound {
+			h.value.counts[i]++
+		}
+	}
+	h.value.count++
+	h.value.sum += v
+}
+
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if err := writeHeader(w, h.name, h.help, "histogram"); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string(nil), h.labelNames...), "le")
+	for _, key := range keys {
+		value := h.values[key]
+		for i, upperBound := range h.buckets {
+			labelValues := append(append([]string(nil), value.labelValues...), formatFloat(upperBound))
+			if err := writeSample(w, h.name+"_bucket", bucketLabels, labelValues, float64(value.counts[i])); err != nil {
+				return err
+			}
+		}
+
+		labelValues := append(append([]string(nil), value.labelValues...), "+Inf")
+		if err := writeSample(w, h.name+"_bucket", bucketLabels, labelValues, float64(value.count)); err != nil {
+			return err
+		}
+		if err := writeSample(w, h.name+"_sum", h.labelNames, value.labelValues, value.sum); err != nil {
+			return err
+		}
+		if err := writeSample(w, h.name+"_count", h.labelNames, value.labelValues, float64(value.count)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+/**
+ * GaugeFunc is a gauge whose samples are computed every time it is collected
+ */
+type GaugeFunc struct {
+	name       string
+	help       string
+	labelNames []string
+	collect    func() []Sample
+}
+
+func NewGaugeFunc(name string, help string, labelNames []string, collect func() []Sample) *GaugeFunc {
+	return &GaugeFunc{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		collect:    collect,
+	}
+}
+
+func (g *GaugeFunc) Write(w io.Writer) error {
+	return writeFamily(w, g.name, g.help, "gauge", g.labelNames, g.collect())
+}
+
+func writeFamily(w io.Writer, name string, help string, metricType string, labelNames []string, samples []Sample) error {
+	if err := writeHeader(w, name, help, metricType); err != nil {
+		return err
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+
+	for _, sample := range samples {
+		if err := writeSample(w, name, labelNames, sample.LabelValues, sample.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, metricType)
+	return err
+}
+
+func writeSample(w io.Writer, name string, labelNames []string, labelValues []string, value float64) error {
+	if len(labelNames) == 0 {
+		_, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return err
+	}
+
+	pairs := make([]string, 0, len(labelNames))
+	for i, labelName := range labelNames {
+		labelValue := ""
+		if i < len(labelValues) {
+			labelValue = labelValues[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labelName, escapeLabelValue(labelValue)))
+	}
+
+	_, err := fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+	return err
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
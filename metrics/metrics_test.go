@@ -0,0 +1,127 @@
+package metrics_test
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/phoebesimon/version_tracker/metrics"
+)
+
+func render(t *testing.T, collectors ...metrics.Collector) string {
+	registry := metrics.NewRegistry()
+	registry.MustRegister(collectors...)
+
+	var buf bytes.Buffer
+	err := registry.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func checkOutput(t *testing.T, got string, want string) {
+	if got != want {
+		t.Errorf("Output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+	counter := metrics.NewCounterVec("catalog_fetches_total", "Catalog fetches by result.", "catalog", "result")
+	counter.WithLabelValues("15", "ok").Inc()
+	counter.WithLabelValues("15", "ok").Add(2)
+	counter.WithLabelValues("14", "not_modified").Inc()
+	counter.WithLabelValues("15", "error").Add(0.5)
+
+	checkOutput(t, render(t, counter), `# HELP catalog_fetches_total Catalog fetches by result.
+# TYPE catalog_fetches_total counter
+catalog_fetches_total{catalog="14",result="not_modified"} 1
+catalog_fetches_total{catalog="15",result="error"} 0.5
+catalog_fetches_total{catalog="15",result="ok"} 3
+`)
+}
+
+func TestCounterVecWithoutLabels(t *testing.T) {
+	counter := metrics.NewCounterVec("scrapes_total", "Scrapes run.")
+	counter.WithLabelValues().Add(1e6)
+
+	checkOutput(t, render(t, counter), `# HELP scrapes_total Scrapes run.
+# TYPE scrapes_total counter
+scrapes_total 1e+06
+`)
+}
+
+func TestGaugeFunc(t *testing.T) {
+	gauge := metrics.NewGaugeFunc("latest_version_info", "Latest version per release line.", []string{"os", "line", "version"}, func() []metrics.Sample {
+		return []metrics.Sample{
+			{LabelValues: []string{"macOS", "Sonoma", "14.7.1"}, Value: 1},
+			{LabelValues: []string{"macOS", "High Sierra", "10.13.6"}, Value: 1},
+		}
+	})
+	stale := metrics.NewGaugeFunc("scrape_age_seconds", "Seconds since the last scrape.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: math.Inf(1)}}
+	})
+
+	checkOutput(t, render(t, gauge, stale), `# HELP latest_version_info Latest version per release line.
+# TYPE latest_version_info gauge
+latest_version_info{os="macOS",line="High Sierra",version="10.13.6"} 1
+latest_version_info{os="macOS",line="Sonoma",version="14.7.1"} 1
+# HELP scrape_age_seconds Seconds since the last scrape.
+# TYPE scrape_age_seconds gauge
+scrape_age_seconds +Inf
+`)
+}
+
+func TestEscaping(t *testing.T) {
+	gauge := metrics.NewGaugeFunc("titles", "Help with a \\ backslash\nand a newline.", []string{"title"}, func() []metrics.Sample {
+		return []metrics.Sample{
+			{LabelValues: []string{`macOS "Sonoma" 14.7.1`}, Value: 1},
+			{LabelValues: []string{`C:\Updates`}, Value: 2},
+			{LabelValues: []string{"two\nlines"}, Value: 3},
+		}
+	})
+
+	checkOutput(t, render(t, gauge), `# HELP titles Help with a \\ backslash\nand a newline.
+# TYPE titles gauge
+titles{title="C:\\Updates"} 2
+titles{title="macOS \"Sonoma\" 14.7.1"} 1
+titles{title="two\nlines"} 3
+`)
+}
+
+func TestHistogramVec(t *testing.T) {
+	histogram := metrics.NewHistogramVec("scrape_duration_seconds", "Scrape duration.", []float64{1, 0.5}, "source")
+	histogram.WithLabelValues("macos").Observe(0.25)
+	histogram.WithLabelValues("macos").Observe(0.75)
+	histogram.WithLabelValues("macos").Observe(3)
+
+	checkOutput(t, render(t, histogram), `# HELP scrape_duration_seconds Scrape duration.
+# TYPE scrape_duration_seconds histogram
+scrape_duration_seconds_bucket{source="macos",le="0.5"} 1
+scrape_duration_seconds_bucket{source="macos",le="1"} 2
+scrape_duration_seconds_bucket{source="macos",le="+Inf"} 3
+scrape_duration_seconds_sum{source="macos"} 4
+scrape_duration_seconds_count{source="macos"} 3
+`)
+}
+
+func TestHandler(t *testing.T) {
+	counter := metrics.NewCounterVec("requests_total", "Requests.", "code")
+	counter.WithLabelValues("200").Inc()
+
+	registry := metrics.NewRegistry()
+	registry.MustRegister(counter)
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	checkOutput(t, rec.Body.String(), `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{code="200"} 1
+`)
+}
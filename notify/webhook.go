@@ -0,0 +1,413 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SignatureHeader = "X-Version-Tracker-Signature"
+	EventHeader     = "X-Version-Tracker-Event"
+	DeliveryHeader  = "X-Version-Tracker-Delivery"
+
+	eventTypeVersionChanged = "version.changed"
+
+	defaultMaxAttempts = 10
+	defaultMinBackoff  = time.Second
+	defaultMaxBackoff  = 5 * time.Minute
+	defaultTimeout     = 10 * time.Second
+	pollInterval       = time.Second
+)
+
+/**
+ * A single event waiting to be POSTed to a single URL
+ */
+type Delivery struct {
+	ID          string              `json:"id"`
+	URL         string              `json:"url"`
+	Event       tracker.ChangeEvent `json:"event"`
+	Attempts    int                 `json:"attempts"`
+	NextAttempt time.Time           `json:"next_attempt"`
+	LastError   string              `json:"last_error,omitempty"`
+}
+
+type WebhookConfig struct {
+	URLs        []string
+	Secret      string
+	OutboxPath  string // Where undelivered events are kept between restarts (in-memory only if empty)
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+}
+
+/**
+ * WebhookNotifier POSTs change events as JSON to a set of URLs.
+ * Notify only queues the event; a background loop delivers it, retrying with
+ * exponential backoff, and the queue is persisted so nothing is lost on restart.
+ */
+type WebhookNotifier struct {
+	config WebhookConfig
+	client *http.Client
+	outbox []*Delivery
+	mtx    sync.Mutex
+	wg     sync.WaitGroup
+}
+
+func NewWebhookNotifier(config WebhookConfig) (*WebhookNotifier, error) {
+	config = withDefaults(config)
+
+	w := &WebhookNotifier{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		outbox: []*Delivery{},
+	}
+
+	err := w.loadOutbox()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+/**
+ * Swaps in new URLs, secret, retry and timeout settings without dropping queued deliveries.
+ * The outbox stays where it is; deliveries already queued for a URL that was removed still go out.
+ */
+func (w *WebhookNotifier) SetConfig(config WebhookConfig) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	config = withDefaults(config)
+	config.OutboxPath = w.config.OutboxPath
+
+	w.config = config
+	w.client = &http.Client{Timeout: config.Timeout}
+}
+
+func withDefaults(config WebhookConfig) WebhookConfig {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	return config
+}
+
+/**
+ * Queues the event for every configured URL
+ */
+func (w *WebhookNotifier) Notify(event tracker.ChangeEvent) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, url := range w.config.URLs {
+		id, err := newDeliveryID()
+		if err != nil {
+			return err
+		}
+
+		w.outbox = append(w.outbox, &Delivery{
+			ID:          id,
+			URL:         url,
+			Event:       event,
+			NextAttempt: time.Now(),
+		})
+	}
+
+	return w.saveOutbox()
+}
+
+/**
+ * Delivers queued events until the context is cancelled
+ */
+func (w *WebhookNotifier) Start(ctx context.Context) {
+	w.wg.Add(1)
+	defer w.wg.Done()
+
+	w.deliverDue(ctx)
+
+	timer := time.NewTicker(pollInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-timer.C:
+			w.deliverDue(ctx)
+		}
+	}
+}
+
+func (w *WebhookNotifier) Close() {
+	w.wg.Wait()
+}
+
+/**
+ * Returns the number of deliveries still waiting to go out
+ */
+func (w *WebhookNotifier) Pending() int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return len(w.outbox)
+}
+
+/**
+ * Sends whatever is due. A URL's deliveries go out in the order they were queued, so once one of
+ * them is waiting on a retry, nothing queued after it goes to that URL until it's through.
+ */
+func (w *WebhookNotifier) deliverDue(ctx context.Context) {
+	now := time.Now()
+
+	w.mtx.Lock()
+	due := []*Delivery{}
+	blocked := make(map[string]bool)
+	for _, delivery := range w.outbox {
+		if blocked[delivery.URL] {
+			continue
+		}
+		if delivery.NextAttempt.After(now) {
+			blocked[delivery.URL] = true
+			continue
+		}
+		due = append(due, delivery)
+	}
+	w.mtx.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	// Each URL gets its own goroutine so a slow or hung subscriber only holds up its own
+	// deliveries; within a URL, the first failure holds back the rest until its retry
+	byURL := make(map[string][]*Delivery)
+	for _, delivery := range due {
+		byURL[delivery.URL] = append(byURL[delivery.URL], delivery)
+	}
+
+	done := make(map[string]bool)
+	var wg sync.WaitGroup
+	for _, deliveries := range byURL {
+		wg.Add(1)
+		go func(deliveries []*Delivery) {
+			defer wg.Done()
+
+			for _, delivery := range deliveries {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if !w.attempt(ctx, delivery, done) {
+					return
+				}
+			}
+		}(deliveries)
+	}
+	wg.Wait()
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	remaining := make([]*Delivery, 0, len(w.outbox))
+	for _, delivery := range w.outbox {
+		if !done[delivery.ID] {
+			remaining = append(remaining, delivery)
+		}
+	}
+	w.outbox = remaining
+
+	err := w.saveOutbox()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"err":       err,
+		}).Error("Error saving webhook outbox")
+	}
+}
+
+/**
+ * Makes one delivery attempt and records the outcome, marking the delivery in done once it
+ * succeeds or runs out of attempts. Returns whether it did.
+ */
+func (w *WebhookNotifier) attempt(ctx context.Context, delivery *Delivery, done map[string]bool) bool {
+	err := w.deliver(ctx, delivery)
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		// Cancelled by shutdown rather than refused by the subscriber, so it doesn't count
+		return false
+	}
+
+	delivery.Attempts++
+	if err == nil {
+		done[delivery.ID] = true
+	} else if delivery.Attempts >= w.config.MaxAttempts {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"url":       delivery.URL,
+			"delivery":  delivery.ID,
+			"attempts":  delivery.Attempts,
+			"err":       err,
+		}).Error("Giving up on webhook delivery")
+		done[delivery.ID] = true
+	} else {
+		delivery.LastError = err.Error()
+		delivery.NextAttempt = time.Now().Add(w.backoff(delivery.Attempts))
+		log.WithFields(log.Fields{
+			"timestamp":    time.Now().UnixNano(),
+			"url":          delivery.URL,
+			"delivery":     delivery.ID,
+			"attempts":     delivery.Attempts,
+			"next_attempt": delivery.NextAttempt,
+			"err":          err,
+		}).Warn("Webhook delivery failed; will retry")
+	}
+
+	return done[delivery.ID]
+}
+
+func (w *WebhookNotifier) deliver(ctx context.Context, delivery *Delivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventTypeVersionChanged)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	w.mtx.Lock()
+	secret, client := w.config.Secret, w.client
+	w.mtx.Unlock()
+
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook returned status %d", resp.StatusCode)
+	}
+
+	log.WithFields(log.Fields{
+		"timestamp": time.Now().UnixNano(),
+		"url":       delivery.URL,
+		"delivery":  delivery.ID,
+	}).Debug("Delivered webhook")
+
+	return nil
+}
+
+/**
+ * Doubles the wait after every failed attempt, capped at MaxBackoff, then picks a random point
+ * in the upper half of that so subscribers that failed together don't all retry together.
+ * Must be called with w.mtx held
+ */
+func (w *WebhookNotifier) backoff(attempts int) time.Duration {
+	backoff := w.config.MinBackoff
+	for i := 1; i < attempts && backoff < w.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > w.config.MaxBackoff {
+		backoff = w.config.MaxBackoff
+	}
+
+	half := backoff / 2
+	if half <= 0 {
+		return backoff
+	}
+
+	return backoff - half + time.Duration(mathrand.Int63n(int64(half)+1))
+}
+
+func (w *WebhookNotifier) loadOutbox() error {
+	if w.config.OutboxPath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(w.config.OutboxPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &w.outbox)
+}
+
+/**
+ * Must be called with w.mtx held
+ */
+func (w *WebhookNotifier) saveOutbox() error {
+	if w.config.OutboxPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(w.outbox, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return tracker.WriteFileAtomic(w.config.OutboxPath, data)
+}
+
+/**
+ * Returns the signature header value for a body: "sha256=" followed by the hex HMAC-SHA256 of the body
+ */
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newDeliveryID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,383 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+/**
+ * A subscriber that answers each request with the next status in statuses (the last one repeats)
+ * and keeps what it was sent
+ */
+type subscriber struct {
+	*httptest.Server
+	statuses []int
+
+	mtx      sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newSubscriber(t *testing.T, statuses ...int) *subscriber {
+	s := &subscriber{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mtx.Lock()
+		status := s.statuses[len(s.statuses)-1]
+		if len(s.requests) < len(s.statuses) {
+			status = s.statuses[len(s.requests)]
+		}
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		s.mtx.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *subscriber) count() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return len(s.requests)
+}
+
+func testEvent() tracker.ChangeEvent {
+	return tracker.ChangeEvent{
+		OSType:     tracker.OSTypeMac,
+		Source:     "macos-catalog",
+		Line:       "Sequoia",
+		Kind:       tracker.ChangeKindVersion,
+		NewVersion: "15.1",
+		NewBuild:   "24B83",
+	}
+}
+
+/**
+ * Makes every queued delivery due again, so a test doesn't have to sleep through the backoff
+ */
+func (w *WebhookNotifier) makeDue() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, delivery := range w.outbox {
+		delivery.NextAttempt = time.Time{}
+	}
+}
+
+func TestWebhookSignsBody(t *testing.T) {
+	sub := newSubscriber(t, http.StatusOK)
+
+	w, err := NewWebhookNotifier(WebhookConfig{URLs: []string{sub.URL}, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.deliverDue(context.Background())
+
+	if sub.count() != 1 {
+		t.Fatalf("Subscriber got %d requests, want 1", sub.count())
+	}
+
+	req, body := sub.requests[0], sub.bodies[0]
+	if got, want := req.Header.Get(SignatureHeader), Sign("s3cret", body); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+	if got := req.Header.Get(EventHeader); got != eventTypeVersionChanged {
+		t.Errorf("%s = %q, want %q", EventHeader, got, eventTypeVersionChanged)
+	}
+	if req.Header.Get(DeliveryHeader) == "" {
+		t.Errorf("%s is empty", DeliveryHeader)
+	}
+	if w.Pending() != 0 {
+		t.Errorf("Pending() = %d after a successful delivery, want 0", w.Pending())
+	}
+}
+
+func TestSign(t *testing.T) {
+	// RFC 4231 test case 2
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	got := Sign("Jefe", []byte("what do ya want for nothing?"))
+	if got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	sub := newSubscriber(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+
+	w, err := NewWebhookNotifier(WebhookConfig{URLs: []string{sub.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w.deliverDue(context.Background())
+		if w.Pending() != 1 {
+			t.Fatalf("Pending() = %d after failed attempt %d, want 1", w.Pending(), i+1)
+		}
+		if w.outbox[0].Attempts != i+1 || w.outbox[0].LastError == "" {
+			t.Fatalf("After attempt %d: %+v", i+1, w.outbox[0])
+		}
+		if !w.outbox[0].NextAttempt.After(time.Now()) {
+			t.Fatalf("NextAttempt %v is not in the future after a failure", w.outbox[0].NextAttempt)
+		}
+
+		// Not due yet, so nothing is sent
+		w.deliverDue(context.Background())
+		if sub.count() != i+1 {
+			t.Fatalf("Subscriber got %d requests before the backoff ran out, want %d", sub.count(), i+1)
+		}
+		w.makeDue()
+	}
+
+	w.deliverDue(context.Background())
+	if sub.count() != 3 {
+		t.Errorf("Subscriber got %d requests, want 3", sub.count())
+	}
+	if w.Pending() != 0 {
+		t.Errorf("Pending() = %d after the retry succeeded, want 0", w.Pending())
+	}
+}
+
+func TestWebhookGivesUpAtMaxAttempts(t *testing.T) {
+	sub := newSubscriber(t, http.StatusServiceUnavailable)
+
+	w, err := NewWebhookNotifier(WebhookConfig{URLs: []string{sub.URL}, MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		w.deliverDue(context.Background())
+		w.makeDue()
+	}
+
+	if sub.count() != 3 {
+		t.Errorf("Subscriber got %d requests, want 3", sub.count())
+	}
+	if w.Pending() != 0 {
+		t.Errorf("Pending() = %d after giving up, want 0", w.Pending())
+	}
+}
+
+func TestWebhookKeepsOrderAfterAFailure(t *testing.T) {
+	sub := newSubscriber(t, http.StatusInternalServerError, http.StatusOK)
+
+	w, err := NewWebhookNotifier(WebhookConfig{URLs: []string{sub.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, newVersion := range []string{"10.15.7", "10.15.8"} {
+		event := testEvent()
+		event.NewVersion = newVersion
+		err = w.Notify(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.deliverDue(context.Background())
+	if sub.count() != 1 {
+		t.Fatalf("Subscriber got %d requests after the first delivery failed, want 1", sub.count())
+	}
+
+	// The failed delivery isn't due yet, and nothing may overtake it
+	w.deliverDue(context.Background())
+	if sub.count() != 1 {
+		t.Fatalf("Subscriber got %d requests while the first delivery was backing off, want 1", sub.count())
+	}
+
+	w.makeDue()
+	w.deliverDue(context.Background())
+	if w.Pending() != 0 {
+		t.Fatalf("Pending() = %d, want 0", w.Pending())
+	}
+
+	got := []string{}
+	for _, body := range sub.bodies {
+		var event tracker.ChangeEvent
+		err := json.Unmarshal(body, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, event.NewVersion)
+	}
+	if want := []string{"10.15.7", "10.15.7", "10.15.8"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscriber got %v, want %v", got, want)
+	}
+}
+
+func TestWebhookCancelledAttemptIsNotCounted(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	w, err := NewWebhookNotifier(WebhookConfig{URLs: []string{hung.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w.deliverDue(ctx)
+
+	if w.Pending() != 1 {
+		t.Fatalf("Pending() = %d, want 1", w.Pending())
+	}
+	if w.outbox[0].Attempts != 0 {
+		t.Errorf("Attempts = %d after shutdown cancelled the request, want 0", w.outbox[0].Attempts)
+	}
+}
+
+func TestWebhookSlowURLDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	fast := newSubscriber(t, http.StatusOK)
+
+	w, err := NewWebhookNotifier(WebhookConfig{URLs: []string{slow.URL, fast.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		w.deliverDue(context.Background())
+		close(finished)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fast.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fast.count() != 1 {
+		t.Errorf("Fast subscriber got %d requests while the slow one hung, want 1", fast.count())
+	}
+
+	close(release)
+	<-finished
+}
+
+func TestWebhookOutboxSurvivesRestart(t *testing.T) {
+	sub := newSubscriber(t, http.StatusInternalServerError, http.StatusOK)
+	config := WebhookConfig{
+		URLs:       []string{sub.URL},
+		OutboxPath: filepath.Join(t.TempDir(), "outbox.json"),
+	}
+
+	w, err := NewWebhookNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Notify(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.deliverDue(context.Background())
+
+	restarted, err := NewWebhookNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Pending() != 1 {
+		t.Fatalf("Pending() = %d after restart, want 1", restarted.Pending())
+	}
+
+	delivery := restarted.outbox[0]
+	if delivery.Attempts != 1 || delivery.Event.NewVersion != "15.1" {
+		t.Errorf("Restored delivery = %+v", delivery)
+	}
+
+	restarted.makeDue()
+	restarted.deliverDue(context.Background())
+	if restarted.Pending() != 0 {
+		t.Errorf("Pending() = %d after delivering the restored event, want 0", restarted.Pending())
+	}
+
+	reloaded, err := NewWebhookNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Pending() != 0 {
+		t.Errorf("Outbox still has %d deliveries on disk after they went out", reloaded.Pending())
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	w := &WebhookNotifier{config: withDefaults(WebhookConfig{
+		MinBackoff: time.Second,
+		MaxBackoff: 10 * time.Second,
+	})}
+
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 50; i++ {
+			got := w.backoff(test.attempts)
+			if got < test.max/2 || got > test.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", test.attempts, got, test.max/2, test.max)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("backoff(%d) returned the same value every time; want jitter", test.attempts)
+		}
+	}
+}
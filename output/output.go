@@ -0,0 +1,31 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+/**
+ * Writes v in the given format. Tables are up to the caller since only it knows which columns matter.
+ */
+func Write(w io.Writer, format string, v interface{}, table func(io.Writer) error) error {
+	switch format {
+	case FormatTable:
+		return table(w)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case FormatYAML:
+		return WriteYAML(w, v)
+	default:
+		return fmt.Errorf("Unknown format %q; expected %s, %s or %s", format, FormatTable, FormatJSON, FormatYAML)
+	}
+}
@@ -0,0 +1,181 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Writes v as YAML. We don't vendor a YAML library, so v goes through encoding/json first
+ * (which means json tags are respected) and the result is emitted as block-style YAML.
+ * Map keys come out sorted.
+ */
+func WriteYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	err = decoder.Decode(&value)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if isYAMLBlock(value) {
+		writeYAMLBlock(buf, value, 0)
+	} else {
+		buf.WriteString(yamlScalar(value))
+		buf.WriteString("\n")
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+/**
+ * Writes a map or list whose first line starts at the current position, indented by indent
+ */
+func writeYAMLBlock(buf *bytes.Buffer, value interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteString(pad)
+			}
+			buf.WriteString(yamlScalar(key))
+			buf.WriteString(":")
+			writeYAMLValue(buf, v[key], indent)
+		}
+
+	case []interface{}:
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteString(pad)
+			}
+			buf.WriteString("-")
+			if isYAMLBlock(item) {
+				buf.WriteString(" ")
+				writeYAMLBlock(buf, item, indent+2)
+			} else {
+				writeYAMLValue(buf, item, indent)
+			}
+		}
+	}
+}
+
+/**
+ * Writes whatever follows "key:" or "-": a scalar on the same line, or a block on the lines after
+ */
+func writeYAMLValue(buf *bytes.Buffer, value interface{}, indent int) {
+	if !isYAMLBlock(value) {
+		buf.WriteString(" ")
+		buf.WriteString(yamlScalar(value))
+		buf.WriteString("\n")
+		return
+	}
+
+	buf.WriteString("\n")
+	buf.WriteString(strings.Repeat(" ", indent+2))
+	writeYAMLBlock(buf, value, indent+2)
+}
+
+/**
+ * Empty maps and lists are written inline as {} and [] instead
+ */
+func isYAMLBlock(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
+
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if needsYAMLQuotes(v) {
+			return strconv.Quote(v)
+		}
+		return v
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return ""
+}
+
+/**
+ * Matches plain scalars that YAML 1.1 parsers resolve to something other than a string but that
+ * strconv.ParseFloat doesn't catch: hex, octal, binary and sexagesimal numbers, numbers with
+ * underscores, .inf/.nan, and dates and timestamps
+ */
+var yamlNonStringRegex = regexp.MustCompile(`^(` +
+	`[-+]?0x[0-9a-fA-F_]+|` +
+	`[-+]?0o?[0-7_]+|` +
+	`[-+]?0b[01_]+|` +
+	`[-+]?[0-9][0-9_]*(:[0-5]?[0-9])+(\.[0-9_]*)?|` +
+	`[-+]?(\.[0-9_]+|[0-9][0-9_]*(\.[0-9_]*)?)([eE][-+]?[0-9]+)?|` +
+	`[-+]?\.(inf|Inf|INF)|\.(nan|NaN|NAN)|` +
+	`[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}([Tt ].*)?` +
+	`)$`)
+
+/**
+ * Plain strings are only safe if a YAML parser would read them back as the same string
+ */
+func needsYAMLQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+
+	switch strings.ToLower(s) {
+	case "null", "~", "<<", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return true
+	}
+
+	if _, err := strconv.ParseFloat(s, 64); err == nil || yamlNonStringRegex.MatchString(s) {
+		return true
+	}
+
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return true
+	}
+
+	for _, r := range s {
+		if r < ' ' || r == 0x7f {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,130 @@
+package output_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/phoebesimon/version_tracker/output"
+)
+
+func yaml(t *testing.T, v interface{}) string {
+	var buf bytes.Buffer
+	err := output.WriteYAML(&buf, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteYAMLQuotesAmbiguousStrings(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", `""`},
+		{"Sonoma", `Sonoma`},
+		{"macOS Sonoma 14.7.1", `macOS Sonoma 14.7.1`},
+		{"14.7.1", `14.7.1`},
+		{"10.13", `"10.13"`},
+		{"15", `"15"`},
+		{"1e3", `"1e3"`},
+		{"0x1F", `"0x1F"`},
+		{"0o17", `"0o17"`},
+		{"1_000", `"1_000"`},
+		{"12:30", `"12:30"`},
+		{".inf", `".inf"`},
+		{"NaN", `"NaN"`},
+		{"2024-10-28", `"2024-10-28"`},
+		{"2024-10-28T17:00:00Z", `"2024-10-28T17:00:00Z"`},
+		{"yes", `"yes"`},
+		{"No", `"No"`},
+		{"on", `"on"`},
+		{"true", `"true"`},
+		{"null", `"null"`},
+		{"~", `"~"`},
+		{"<<", `"<<"`},
+		{"key: value", `"key: value"`},
+		{"trailing:", `"trailing:"`},
+		{"https://swscan.apple.com/a.dist", `https://swscan.apple.com/a.dist`},
+		{"a:b", `a:b`},
+		{": leading", `": leading"`},
+		{"- dash", `"- dash"`},
+		{"#comment", `"#comment"`},
+		{"not # a comment", `"not # a comment"`},
+		{" padded", `" padded"`},
+		{"two\nlines", `"two\nlines"`},
+		{`say "hi"`, `say "hi"`},
+		{"'quoted'", `"'quoted'"`},
+	}
+
+	for _, test := range tests {
+		if got := yaml(t, test.value); got != test.want+"\n" {
+			t.Errorf("WriteYAML(%q) = %q, want %q", test.value, got, test.want+"\n")
+		}
+	}
+}
+
+func TestWriteYAMLScalars(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{42, "42"},
+		{1.5, "1.5"},
+		{[]string{}, "[]"},
+		{map[string]string{}, "{}"},
+	}
+
+	for _, test := range tests {
+		if got := yaml(t, test.value); got != test.want+"\n" {
+			t.Errorf("WriteYAML(%#v) = %q, want %q", test.value, got, test.want+"\n")
+		}
+	}
+}
+
+func TestWriteYAMLBlocks(t *testing.T) {
+	type build struct {
+		Version string   `json:"version"`
+		Build   string   `json:"build,omitempty"`
+		Tags    []string `json:"tags"`
+	}
+
+	value := map[string]interface{}{
+		"lines": []build{
+			{Version: "10.13", Tags: []string{"yes", "legacy"}},
+			{Version: "14.7.1", Build: "23H222", Tags: []string{}},
+		},
+		"count":  2,
+		"10.15":  map[string]int{},
+		"nested": map[string]interface{}{"inner": map[string]string{"a": "b"}},
+	}
+
+	// build is omitempty, so the first entry shouldn't have one at all
+	want := `"10.15": {}
+count: 2
+lines:
+  - tags:
+      - "yes"
+      - legacy
+    version: "10.13"
+  - build: 23H222
+    tags: []
+    version: 14.7.1
+nested:
+  inner:
+    a: b
+`
+
+	if got := yaml(t, value); got != want {
+		t.Errorf("WriteYAML() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	err := output.Write(&bytes.Buffer{}, "xml", nil, nil)
+	if err == nil {
+		t.Error("Write() accepted an unknown format")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/api"
+	"github.com/phoebesimon/version_tracker/config"
+	"github.com/phoebesimon/version_tracker/notify"
+	"github.com/phoebesimon/version_tracker/tracker"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+/**
+ * reloader re-reads the config of a running tracker (on SIGHUP or POST /v1/admin/reload)
+ * and applies it in place: sources are added, removed or re-scheduled, but the versions
+ * found so far, the request cache and the webhook outbox are kept.
+ */
+type reloader struct {
+	c        *cli.Context
+	ctx      context.Context
+	tracker  *tracker.Tracker
+	server   *api.Server // nil unless serving
+	webhooks *notify.WebhookNotifier
+	cfg      *config.Config
+	mtx      sync.Mutex
+}
+
+func (r *reloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	cfg, err := loadConfig(r.c)
+	if err != nil {
+		return err
+	}
+
+	return r.apply(cfg)
+}
+
+/**
+ * Switches the running tracker over to cfg. Every new component is built and checked before
+ * anything is swapped in, so if any of them fails the old config stays in place as a whole.
+ * Must be called with r.mtx held.
+ */
+func (r *reloader) apply(cfg *config.Config) error {
+	evaluator, err := makeEvaluator(cfg, r.tracker)
+	if err != nil {
+		return err
+	}
+
+	macOS := cfg.Scrapers.MacOS
+	existing, registered := r.tracker.Registry().Get(tracker.MacScraperName)
+
+	var newMacScraper *tracker.MacScraper
+	var macSettings *macScraperSettings
+	if macOS.Enabled && !registered {
+		newMacScraper, err = makeMacScraper(cfg)
+	} else if macOS.Enabled {
+		macSettings, err = makeMacScraperSettings(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Once started the webhook notifier stays registered; with no URLs it just delivers what is already queued
+	var newWebhooks *notify.WebhookNotifier
+	if r.webhooks == nil && len(cfg.Notifiers.Webhook.URLs) > 0 {
+		newWebhooks, err = notify.NewWebhookNotifier(makeWebhookConfig(cfg))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Registering is the only step left that can fail, so it goes first
+	switch {
+	case newMacScraper != nil:
+		err = r.tracker.Register(newMacScraper)
+	case macSettings != nil:
+		err = macSettings.apply(existing.(*tracker.MacScraper))
+	case registered:
+		r.tracker.Unregister(tracker.MacScraperName)
+	}
+	if err != nil {
+		return err
+	}
+	if macOS.Enabled {
+		r.tracker.SetSourceInterval(tracker.MacScraperName, macOS.Interval.Duration)
+	}
+
+	if newWebhooks != nil {
+		r.tracker.AddNotifier(newWebhooks)
+		go newWebhooks.Start(r.ctx)
+		r.webhooks = newWebhooks
+	} else if r.webhooks != nil {
+		r.webhooks.SetConfig(makeWebhookConfig(cfg))
+	}
+
+	setLogLevel(cfg)
+	r.tracker.SetInterval(int(cfg.Interval.Seconds()))
+
+	if r.server != nil {
+		r.server.SetEvaluator(evaluator)
+		r.server.SetAdminToken(cfg.AdminToken)
+	}
+
+	if cfg.Listen != r.cfg.Listen || cfg.Storage.StateFile != r.cfg.Storage.StateFile || cfg.WebhookOutbox() != r.cfg.WebhookOutbox() {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+		}).Warn("listen, storage.state_file and notifiers.webhook.outbox only take effect on restart")
+	}
+
+	r.cfg = cfg
+	r.tracker.Reschedule()
+
+	log.WithFields(log.Fields{
+		"timestamp": time.Now().UnixNano(),
+	}).Info("Reloaded config")
+
+	return nil
+}
+
+/**
+ * Waits for the webhook notifier, including one started by a reload, to stop
+ */
+func (r *reloader) Close() {
+	r.mtx.Lock()
+	webhooks := r.webhooks
+	r.mtx.Unlock()
+
+	if webhooks != nil {
+		webhooks.Close()
+	}
+}
+
+func makeWebhookConfig(cfg *config.Config) notify.WebhookConfig {
+	webhook := cfg.Notifiers.Webhook
+
+	return notify.WebhookConfig{
+		URLs:        webhook.URLs,
+		Secret:      webhook.Secret,
+		OutboxPath:  cfg.WebhookOutbox(),
+		MaxAttempts: webhook.MaxAttempts,
+		MinBackoff:  webhook.MinBackoff.Duration,
+		MaxBackoff:  webhook.MaxBackoff.Duration,
+		Timeout:     webhook.Timeout.Duration,
+	}
+}
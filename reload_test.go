@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/phoebesimon/version_tracker/config"
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+/**
+ * Returns a reloader over a tracker built from the default config, and the macOS scraper in it
+ */
+func newReloader(t *testing.T) (*reloader, *tracker.MacScraper) {
+	cfg := config.Default()
+
+	macScraper, err := makeMacScraper(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versionTracker := tracker.MakeTracker(int(cfg.Interval.Seconds()))
+	err = versionTracker.Register(macScraper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &reloader{
+		ctx:     ctx,
+		tracker: versionTracker,
+		cfg:     cfg,
+	}
+	t.Cleanup(func() {
+		cancel()
+		r.Close()
+	})
+
+	return r, macScraper
+}
+
+/**
+ * Returns a config that adds a release line for macOS 16, so a test can tell whether it was applied
+ */
+func nextConfig(t *testing.T) *config.Config {
+	cfg := config.Default()
+	cfg.Interval.Duration = time.Minute
+	cfg.Scrapers.MacOS.ReleaseLines = []tracker.ReleaseLine{{Prefix: "16", Name: "NextOS"}}
+	cfg.Notifiers.Webhook.URLs = []string{"http://127.0.0.1:1/hook"}
+	cfg.Notifiers.Webhook.Outbox = filepath.Join(t.TempDir(), "outbox.json")
+
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func hasNextOS(macScraper *tracker.MacScraper) bool {
+	line, _ := macScraper.ReleaseLine(version.Must(version.NewVersion("16.0")))
+	return line == "NextOS"
+}
+
+func TestReloadApplies(t *testing.T) {
+	r, macScraper := newReloader(t)
+	cfg := nextConfig(t)
+
+	err := r.apply(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasNextOS(macScraper) {
+		t.Error("New release line wasn't applied")
+	}
+	if r.webhooks == nil {
+		t.Error("Webhook notifier wasn't started")
+	}
+	if r.cfg != cfg {
+		t.Error("Reloader didn't keep the new config")
+	}
+}
+
+func TestFailedReloadKeepsOldConfig(t *testing.T) {
+	r, macScraper := newReloader(t)
+	oldCfg := r.cfg
+
+	// The catalogs and release lines are fine, but the webhook outbox can't be read
+	cfg := nextConfig(t)
+	err := ioutil.WriteFile(cfg.Notifiers.Webhook.Outbox, []byte("not json"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.apply(cfg)
+	if err == nil {
+		t.Fatal("Reload with a corrupt webhook outbox succeeded")
+	}
+
+	if hasNextOS(macScraper) {
+		t.Error("Release lines from the rejected config were applied")
+	}
+	if r.webhooks != nil {
+		t.Error("Webhook notifier from the rejected config was started")
+	}
+	if r.cfg != oldCfg {
+		t.Error("Reloader switched to the rejected config")
+	}
+	if _, ok := r.tracker.Registry().Get(tracker.MacScraperName); !ok {
+		t.Error("macOS scraper was unregistered")
+	}
+}
+
+func TestReloadDisablesMacScraper(t *testing.T) {
+	r, _ := newReloader(t)
+
+	cfg := config.Default()
+	cfg.Scrapers.MacOS.Enabled = false
+
+	err := r.apply(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.tracker.Registry().Get(tracker.MacScraperName); ok {
+		t.Error("macOS scraper is still registered after being disabled")
+	}
+
+	err = r.apply(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.tracker.Registry().Get(tracker.MacScraperName); !ok {
+		t.Error("macOS scraper wasn't registered again after being re-enabled")
+	}
+}
@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+var BuildRegex = regexp.MustCompile(`^(\d+)([A-Z])(\d+)([a-z]*)$`)
+
+/**
+ * Compares two Apple build numbers (e.g. 17G65 < 17G2208 < 18A391).
+ * Builds are ordered by major number, train letter, build number and then suffix;
+ * anything that doesn't look like an Apple build falls back to a string compare.
+ */
+func CompareBuilds(a string, b string) int {
+	aMatch := BuildRegex.FindStringSubmatch(a)
+	bMatch := BuildRegex.FindStringSubmatch(b)
+	if aMatch == nil || bMatch == nil {
+		return strings.Compare(a, b)
+	}
+
+	aMajor, _ := strconv.Atoi(aMatch[1])
+	bMajor, _ := strconv.Atoi(bMatch[1])
+	if aMajor != bMajor {
+		return compareInts(aMajor, bMajor)
+	}
+
+	if aMatch[2] != bMatch[2] {
+		return strings.Compare(aMatch[2], bMatch[2])
+	}
+
+	aNumber, _ := strconv.Atoi(aMatch[3])
+	bNumber, _ := strconv.Atoi(bMatch[3])
+	if aNumber != bNumber {
+		return compareInts(aNumber, bNumber)
+	}
+
+	return strings.Compare(aMatch[4], bMatch[4])
+}
+
+/**
+ * Reports whether (ver, build) is a newer release than (latest, latestBuild).
+ * A higher version always wins; the same version only counts as newer if both
+ * builds are known and the build went up.
+ *
+ * Apple sometimes forks a build of the same version for specific hardware
+ * (10.13.6 shipped as 17G65 generally and as 17G2112 for the 2018 MacBook Pros).
+ * Nothing in the catalog says whether a higher build supersedes the old one or
+ * sits beside it, so a forked build still counts as newer: it raises a build
+ * change event and the compliance evaluator treats hosts on the general build as behind.
+ */
+func IsNewerRelease(ver *version.Version, build string, latest *version.Version, latestBuild string) bool {
+	if latest == nil {
+		return true
+	}
+
+	if ver.GreaterThan(latest) {
+		return true
+	}
+
+	return ver.Equal(latest) && build != "" && latestBuild != "" && CompareBuilds(build, latestBuild) > 0
+}
+
+func compareInts(a int, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
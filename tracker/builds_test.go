@@ -0,0 +1,71 @@
+package tracker_test
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+func TestCompareBuilds(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"17G65", "17G65", 0},
+		{"17G65", "17G2208", -1},
+		{"17G2208", "18A391", -1},
+		{"18A391", "17G2208", 1},
+		{"19H15", "19H2", 1},
+		{"20A5384c", "20A5384b", 1},
+		{"20A5384", "20A5384b", -1},
+		{"abc", "abd", -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+"_"+test.b, func(t *testing.T) {
+			got := tracker.CompareBuilds(test.a, test.b)
+			if got != test.want {
+				t.Errorf("CompareBuilds(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsNewerRelease(t *testing.T) {
+	tests := []struct {
+		name        string
+		ver         string
+		build       string
+		latest      string
+		latestBuild string
+		want        bool
+	}{
+		{"no latest", "10.13.6", "17G65", "", "", true},
+		{"higher version", "10.14", "18A391", "10.13.6", "17G65", true},
+		{"lower version", "10.13.5", "17F77", "10.13.6", "17G65", false},
+		{"same build", "10.13.6", "17G65", "10.13.6", "17G65", false},
+		{"re-released with a higher build", "10.13.6", "17G66", "10.13.6", "17G65", true},
+		// Forked hardware builds can't be told apart from re-releases, so they count as newer
+		{"forked hardware build", "10.13.6", "17G2112", "10.13.6", "17G65", true},
+		{"general build after a fork", "10.13.6", "17G65", "10.13.6", "17G2112", false},
+		{"unknown build", "10.13.6", "", "10.13.6", "17G65", false},
+		{"unknown latest build", "10.13.6", "17G2112", "10.13.6", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ver := version.Must(version.NewVersion(test.ver))
+			var latest *version.Version
+			if test.latest != "" {
+				latest = version.Must(version.NewVersion(test.latest))
+			}
+
+			got := tracker.IsNewerRelease(ver, test.build, latest, test.latestBuild)
+			if got != test.want {
+				t.Errorf("IsNewerRelease(%s %s, %s %s) = %v, want %v", test.ver, test.build, test.latest, test.latestBuild, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package tracker
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+/**
+ * CacheEntry is what we remember about the last successful fetch of a URL:
+ * the server's own validators plus whatever we parsed out of the body
+ */
+type CacheEntry struct {
+	Validator
+	Distribution *Distribution `json:"distribution,omitempty"`
+
+	catalog *SUCatalog // Too big to persist; only kept in memory
+}
+
+/**
+ * RequestCache holds a CacheEntry per URL so that conditional requests can be
+ * made with the right validators, and a 304 can be answered from the cached parse
+ */
+type RequestCache struct {
+	entries map[string]*CacheEntry
+	mtx     sync.RWMutex
+}
+
+func MakeRequestCache() *RequestCache {
+	return &RequestCache{
+		entries: make(map[string]*CacheEntry),
+	}
+}
+
+func (c *RequestCache) Get(url string) (*CacheEntry, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	entry, ok := c.entries[url]
+	return entry, ok
+}
+
+func (c *RequestCache) Put(url string, entry *CacheEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries[url] = entry
+}
+
+/**
+ * Replaces the parsed distribution cached for a URL, keeping its validators.
+ * Does nothing if the URL isn't cached.
+ */
+func (c *RequestCache) SetDistribution(url string, dist *Distribution) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.entries[url]
+	if !ok {
+		return
+	}
+
+	entryCopy := *entry
+	entryCopy.Distribution = dist
+	c.entries[url] = &entryCopy
+}
+
+/**
+ * Drops every entry whose URL isn't in keep, so products Apple has withdrawn don't stay
+ * in memory and in the state file for good. Returns how many entries were dropped.
+ */
+func (c *RequestCache) Prune(keep map[string]bool) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	pruned := 0
+	for url := range c.entries {
+		if !keep[url] {
+			delete(c.entries, url)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+/**
+ * Returns a copy of every entry, for persisting
+ */
+func (c *RequestCache) Entries() map[string]*CacheEntry {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	entries := make(map[string]*CacheEntry, len(c.entries))
+	for url, entry := range c.entries {
+		entryCopy := *entry
+		entries[url] = &entryCopy
+	}
+	return entries
+}
+
+func (c *RequestCache) Load(entries map[string]*CacheEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries = make(map[string]*CacheEntry, len(entries))
+	for url, entry := range entries {
+		if entry != nil {
+			c.entries[url] = entry
+		}
+	}
+}
+
+/**
+ * Builds a cache entry from the headers of a 200 response
+ */
+func newCacheEntry(header http.Header) *CacheEntry {
+	return &CacheEntry{
+		Validator: Validator{
+			LastModified: header.Get("Last-Modified"),
+			ETag:         header.Get("ETag"),
+			FetchedAt:    time.Now(),
+		},
+	}
+}
+
+/**
+ * Sets If-None-Match/If-Modified-Since from the validators the server gave us.
+ * Dates are echoed back exactly as the server sent them.
+ */
+func (v Validator) apply(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
@@ -0,0 +1,65 @@
+package tracker
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRequestCacheGetPut(t *testing.T) {
+	cache := MakeRequestCache()
+
+	if _, ok := cache.Get("https://example.com/a.dist"); ok {
+		t.Fatal("Empty cache returned an entry")
+	}
+
+	entry := &CacheEntry{
+		Validator:    Validator{ETag: `"abc"`, LastModified: "Mon, 28 Oct 2024 17:00:00 GMT"},
+		Distribution: &Distribution{Title: "macOS Sonoma 14.7.1", Version: "14.7.1"},
+	}
+	cache.Put("https://example.com/a.dist", entry)
+
+	got, ok := cache.Get("https://example.com/a.dist")
+	if !ok || got != entry {
+		t.Fatalf("Get() = %+v, %v; want the entry that was put", got, ok)
+	}
+
+	// SetDistribution swaps in a new entry rather than touching the one readers may hold
+	withBuild := *entry.Distribution
+	withBuild.Build = "23H222"
+	cache.SetDistribution("https://example.com/a.dist", &withBuild)
+
+	got, _ = cache.Get("https://example.com/a.dist")
+	if got.Distribution.Build != "23H222" || got.ETag != `"abc"` {
+		t.Errorf("After SetDistribution: %+v", got)
+	}
+	if entry.Distribution.Build != "" {
+		t.Error("SetDistribution modified the entry it replaced")
+	}
+
+	cache.SetDistribution("https://example.com/missing.dist", &withBuild)
+	if _, ok := cache.Get("https://example.com/missing.dist"); ok {
+		t.Error("SetDistribution added an entry for an uncached URL")
+	}
+}
+
+func TestRequestCachePrune(t *testing.T) {
+	cache := MakeRequestCache()
+	for _, url := range []string{"catalog", "a.dist", "b.dist", "withdrawn.dist"} {
+		cache.Put(url, &CacheEntry{})
+	}
+
+	pruned := cache.Prune(map[string]bool{"catalog": true, "a.dist": true, "b.dist": true, "never-cached.dist": true})
+	if pruned != 1 {
+		t.Errorf("Prune() = %d, want 1", pruned)
+	}
+
+	urls := []string{}
+	for url := range cache.Entries() {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	if want := []string{"a.dist", "b.dist", "catalog"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("Cached URLs after prune = %v, want %v", urls, want)
+	}
+}
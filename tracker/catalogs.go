@@ -0,0 +1,173 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ChannelRelease       = "Release"
+	ChannelDeveloperSeed = "DeveloperSeed"
+	ChannelPublicSeed    = "PublicSeed"
+	ChannelCustomerSeed  = "CustomerSeed"
+
+	legacyCatalogChain = "10.16-10.15-10.14-10.13-10.12-10.11-10.10-10.9-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog"
+)
+
+/**
+ * A Catalog is a single Apple software update catalog, tagged with the channel it serves
+ */
+type Catalog struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Channel string `json:"channel"`
+}
+
+var DefaultMacCatalogs = []Catalog{
+	{Name: "10.13", URL: CatalogURL + "index-10.13-10.12-10.11-10.10-10.9-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog", Channel: ChannelRelease},
+	{Name: "14", URL: CatalogURL + "index-14-13-12-" + legacyCatalogChain, Channel: ChannelRelease},
+	{Name: "14seed", URL: CatalogURL + "index-14seed-14-13-12-" + legacyCatalogChain, Channel: ChannelDeveloperSeed},
+	{Name: "14beta", URL: CatalogURL + "index-14beta-14-13-12-" + legacyCatalogChain, Channel: ChannelPublicSeed},
+	{Name: "14customerseed", URL: CatalogURL + "index-14customerseed-14-13-12-" + legacyCatalogChain, Channel: ChannelCustomerSeed},
+	{Name: "15", URL: CatalogURL + "index-15-14-13-12-" + legacyCatalogChain, Channel: ChannelRelease},
+	{Name: "15seed", URL: CatalogURL + "index-15seed-15-14-13-12-" + legacyCatalogChain, Channel: ChannelDeveloperSeed},
+	{Name: "15beta", URL: CatalogURL + "index-15beta-15-14-13-12-" + legacyCatalogChain, Channel: ChannelPublicSeed},
+	{Name: "15customerseed", URL: CatalogURL + "index-15customerseed-15-14-13-12-" + legacyCatalogChain, Channel: ChannelCustomerSeed},
+	{Name: "26", URL: CatalogURL + "index-26-15-14-13-12-" + legacyCatalogChain, Channel: ChannelRelease},
+	{Name: "26seed", URL: CatalogURL + "index-26seed-26-15-14-13-12-" + legacyCatalogChain, Channel: ChannelDeveloperSeed},
+	{Name: "26beta", URL: CatalogURL + "index-26beta-26-15-14-13-12-" + legacyCatalogChain, Channel: ChannelPublicSeed},
+	{Name: "26customerseed", URL: CatalogURL + "index-26customerseed-26-15-14-13-12-" + legacyCatalogChain, Channel: ChannelCustomerSeed},
+}
+
+/**
+ * CatalogSnapshot records the PostDate of every product in a catalog as of the last time we processed it
+ */
+type CatalogSnapshot map[string]time.Time
+
+func MakeCatalogSnapshot(suCatalog *SUCatalog) CatalogSnapshot {
+	snapshot := make(CatalogSnapshot, len(suCatalog.Products))
+	for key, product := range suCatalog.Products {
+		if product != nil {
+			snapshot[key] = product.PostDate
+		}
+	}
+	return snapshot
+}
+
+/**
+ * Sorts the catalog's product keys into those that are new or have been re-posted since the
+ * snapshot was taken, and those that are unchanged. Also returns how many products were dropped.
+ */
+func (c CatalogSnapshot) Diff(suCatalog *SUCatalog) (changed []string, unchanged []string, removed int) {
+	for key, product := range suCatalog.Products {
+		if product == nil {
+			continue
+		}
+
+		postDate, ok := c[key]
+		if ok && postDate.Equal(product.PostDate) {
+			unchanged = append(unchanged, key)
+		} else {
+			changed = append(changed, key)
+		}
+	}
+
+	for key := range c {
+		if _, ok := suCatalog.Products[key]; !ok {
+			removed++
+		}
+	}
+
+	sort.Strings(changed)
+	sort.Strings(unchanged)
+
+	return changed, unchanged, removed
+}
+
+/**
+ * Returns the key a version is filed under in LatestVersions.
+ * Release catalogs use the bare line name; seed catalogs get the channel appended
+ * so betas show up alongside, rather than on top of, GA releases.
+ */
+func channelLine(line string, channel string) string {
+	if channel == "" || channel == ChannelRelease {
+		return line
+	}
+
+	return line + "-" + channel
+}
+
+/**
+ * Undoes channelLine: splits a LatestVersions key into its release line and the channel it
+ * was filed under. Only the known seed channels are split off, so a release line whose own
+ * name has a dash in it stays in the Release channel.
+ */
+func LineChannel(line string) (string, string) {
+	for _, channel := range []string{ChannelDeveloperSeed, ChannelPublicSeed, ChannelCustomerSeed} {
+		if releaseLine := strings.TrimSuffix(line, "-"+channel); releaseLine != line && releaseLine != "" {
+			return releaseLine, channel
+		}
+	}
+
+	return line, ChannelRelease
+}
+
+/**
+ * Checks that every catalog has a unique name, a URL and a known channel
+ */
+func ValidateCatalogs(catalogs []Catalog) error {
+	names := make(map[string]bool, len(catalogs))
+	for _, catalog := range catalogs {
+		if catalog.Name == "" {
+			return fmt.Errorf("Catalog %q has no name", catalog.URL)
+		}
+		if catalog.URL == "" {
+			return fmt.Errorf("Catalog %q has no URL", catalog.Name)
+		}
+		if names[catalog.Name] {
+			return fmt.Errorf("Catalog %q is listed more than once", catalog.Name)
+		}
+		names[catalog.Name] = true
+
+		switch catalog.Channel {
+		case "", ChannelRelease, ChannelDeveloperSeed, ChannelPublicSeed, ChannelCustomerSeed:
+		default:
+			return fmt.Errorf("Catalog %q has unknown channel %q", catalog.Name, catalog.Channel)
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Reads a JSON list of catalogs, e.g. [{"name": "14", "url": "https://...", "channel": "Release"}]
+ */
+func LoadCatalogs(path string) ([]Catalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalogs []Catalog
+	err = json.Unmarshal(data, &catalogs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range catalogs {
+		if catalogs[i].Channel == "" {
+			catalogs[i].Channel = ChannelRelease
+		}
+	}
+
+	err = ValidateCatalogs(catalogs)
+	if err != nil {
+		return nil, err
+	}
+
+	return catalogs, nil
+}
@@ -0,0 +1,150 @@
+package tracker_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+/**
+ * Every line in the default table should be listed by at least one default release catalog
+ */
+func TestDefaultMacCatalogsCoverReleaseLines(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, line := range tracker.DefaultMacReleaseLines {
+		for _, catalog := range tracker.DefaultMacCatalogs {
+			if catalog.Channel != tracker.ChannelRelease {
+				continue
+			}
+
+			name := catalog.URL[strings.LastIndex(catalog.URL, "/")+1:]
+			for _, listed := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, "index-"), ".merged-1.sucatalog"), "-") {
+				if listed == line.Prefix {
+					covered[line.Name] = true
+				}
+			}
+		}
+	}
+
+	for _, line := range tracker.DefaultMacReleaseLines {
+		if !covered[line.Name] {
+			t.Errorf("No default release catalog lists %s (%s)", line.Name, line.Prefix)
+		}
+	}
+}
+
+func TestDefaultMacCatalogsAreValid(t *testing.T) {
+	if _, err := tracker.NewMacScraper(tracker.DefaultMacCatalogs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLineChannel(t *testing.T) {
+	tests := []struct {
+		key     string
+		line    string
+		channel string
+	}{
+		{"Sonoma", "Sonoma", tracker.ChannelRelease},
+		{"High Sierra", "High Sierra", tracker.ChannelRelease},
+		{"Sonoma-DeveloperSeed", "Sonoma", tracker.ChannelDeveloperSeed},
+		{"Sonoma-PublicSeed", "Sonoma", tracker.ChannelPublicSeed},
+		{"Sonoma-CustomerSeed", "Sonoma", tracker.ChannelCustomerSeed},
+		{"Mac-Next", "Mac-Next", tracker.ChannelRelease},
+		{"Mac-Next-PublicSeed", "Mac-Next", tracker.ChannelPublicSeed},
+		{"Sonoma-Release", "Sonoma-Release", tracker.ChannelRelease},
+		{"-PublicSeed", "-PublicSeed", tracker.ChannelRelease},
+	}
+
+	for _, test := range tests {
+		line, channel := tracker.LineChannel(test.key)
+		if line != test.line || channel != test.channel {
+			t.Errorf("LineChannel(%q) = %q, %q; want %q, %q", test.key, line, channel, test.line, test.channel)
+		}
+	}
+}
+
+func TestCatalogSnapshotDiff(t *testing.T) {
+	posted := time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC)
+	reposted := posted.Add(24 * time.Hour)
+
+	previous := &tracker.SUCatalog{Products: map[string]*tracker.Product{
+		"unchanged": {PostDate: posted},
+		"reposted":  {PostDate: posted},
+		"removed":   {PostDate: posted},
+		"zone":      {PostDate: posted},
+	}}
+	snapshot := tracker.MakeCatalogSnapshot(previous)
+
+	tests := []struct {
+		name          string
+		products      map[string]*tracker.Product
+		wantChanged   []string
+		wantUnchanged []string
+		wantRemoved   int
+	}{
+		{
+			name: "added, removed and re-posted",
+			products: map[string]*tracker.Product{
+				"unchanged": {PostDate: posted},
+				"reposted":  {PostDate: reposted},
+				"zone":      {PostDate: posted.In(time.FixedZone("PDT", -7*60*60))}, // The same instant
+				"added":     {PostDate: posted},
+			},
+			wantChanged:   []string{"added", "reposted"},
+			wantUnchanged: []string{"unchanged", "zone"},
+			wantRemoved:   1,
+		},
+		{
+			name:          "nothing changed",
+			products:      previous.Products,
+			wantUnchanged: []string{"removed", "reposted", "unchanged", "zone"},
+		},
+		{
+			name:        "everything removed",
+			products:    map[string]*tracker.Product{},
+			wantRemoved: 4,
+		},
+		{
+			name: "nil products are skipped",
+			products: map[string]*tracker.Product{
+				"unchanged": {PostDate: posted},
+				"reposted":  nil,
+				"removed":   {PostDate: posted},
+				"zone":      {PostDate: posted},
+			},
+			wantUnchanged: []string{"removed", "unchanged", "zone"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed, unchanged, removed := snapshot.Diff(&tracker.SUCatalog{Products: test.products})
+
+			if !reflect.DeepEqual(changed, test.wantChanged) {
+				t.Errorf("changed = %v, want %v", changed, test.wantChanged)
+			}
+			if !reflect.DeepEqual(unchanged, test.wantUnchanged) {
+				t.Errorf("unchanged = %v, want %v", unchanged, test.wantUnchanged)
+			}
+			if removed != test.wantRemoved {
+				t.Errorf("removed = %d, want %d", removed, test.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestEmptyCatalogSnapshotDiff(t *testing.T) {
+	suCatalog := &tracker.SUCatalog{Products: map[string]*tracker.Product{
+		"b": {PostDate: time.Now()},
+		"a": {PostDate: time.Now()},
+	}}
+
+	changed, unchanged, removed := tracker.CatalogSnapshot{}.Diff(suCatalog)
+	if !reflect.DeepEqual(changed, []string{"a", "b"}) || len(unchanged) != 0 || removed != 0 {
+		t.Errorf("Diff() against no snapshot = %v, %v, %d; want every product changed", changed, unchanged, removed)
+	}
+}
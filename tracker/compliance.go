@@ -0,0 +1,296 @@
+package tracker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	ComplianceStatusPass = "pass"
+	ComplianceStatusWarn = "warn"
+	ComplianceStatusFail = "fail"
+
+	// Behind the latest release, but without the release history to say how far
+	ComplianceStatusUnknown = "unknown"
+)
+
+/**
+ * A LineClassifier is a scraper that can say which release line a version belongs to
+ */
+type LineClassifier interface {
+	Scraper
+	ReleaseLine(ver *version.Version) (string, bool)
+}
+
+/**
+ * CompliancePolicy decides how far behind a host may be.
+ * The default, "N-1 patch within 14 days", passes hosts on the latest release,
+ * warns for hosts one release behind while that release is under 14 days old, and fails everything else.
+ */
+type CompliancePolicy struct {
+	MaxPatchesBehind int `json:"max_patches_behind"` // Releases a host may be behind and still only warn
+	GracePeriodDays  int `json:"grace_period_days"`  // How long a missing release may be out before failing
+	SupportedLines   int `json:"supported_lines"`    // How many of the newest release lines are supported; 0 for all
+}
+
+var DefaultCompliancePolicy = CompliancePolicy{
+	MaxPatchesBehind: 1,
+	GracePeriodDays:  14,
+	SupportedLines:   3,
+}
+
+func (p CompliancePolicy) Validate() error {
+	if p.MaxPatchesBehind < 0 {
+		return errors.New("max_patches_behind: must not be negative")
+	}
+	if p.GracePeriodDays < 0 {
+		return errors.New("grace_period_days: must not be negative")
+	}
+	if p.SupportedLines < 0 {
+		return errors.New("supported_lines: must not be negative")
+	}
+	return nil
+}
+
+/**
+ * Reads a JSON policy, e.g. {"max_patches_behind": 1, "grace_period_days": 14, "supported_lines": 3}.
+ * Fields left out keep their default.
+ */
+func LoadCompliancePolicy(path string) (CompliancePolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return CompliancePolicy{}, err
+	}
+
+	policy := DefaultCompliancePolicy
+	err = json.Unmarshal(data, &policy)
+	if err != nil {
+		return CompliancePolicy{}, err
+	}
+
+	return policy, policy.Validate()
+}
+
+/**
+ * How a single version measures up against the tracker's data
+ */
+type ComplianceResult struct {
+	OSType                  string        `json:"os_type"`
+	Version                 string        `json:"version"`
+	Build                   string        `json:"build,omitempty"`
+	Line                    string        `json:"line,omitempty"`
+	LatestVersion           string        `json:"latest_version,omitempty"`
+	LatestBuild             string        `json:"latest_build,omitempty"`
+	LatestSecurityUpdate    string        `json:"latest_security_update,omitempty"` // For lines that get dated security updates
+	PatchesBehind           int           `json:"patches_behind"`                   // 0 if the status is unknown
+	NewestMissingVersion    string        `json:"newest_missing_version,omitempty"`
+	NewestMissingReleasedAt *time.Time    `json:"newest_missing_released_at,omitempty"`
+	NewestMissingAge        time.Duration `json:"-"`
+	Supported               bool          `json:"supported"`
+	Status                  string        `json:"status"`
+	Reasons                 []string      `json:"reasons,omitempty"`
+}
+
+/**
+ * Evaluator checks versions against the tracker's latest versions and release history
+ */
+type Evaluator struct {
+	tracker *Tracker
+	policy  CompliancePolicy
+	now     func() time.Time
+}
+
+func NewEvaluator(t *Tracker, policy CompliancePolicy) (*Evaluator, error) {
+	err := policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Evaluator{
+		tracker: t,
+		policy:  policy,
+		now:     time.Now,
+	}, nil
+}
+
+func (e *Evaluator) Policy() CompliancePolicy {
+	return e.policy
+}
+
+/**
+ * Evaluates (ver, build) for an OS type. The build is optional; without it a host on the
+ * latest version passes even if Apple has since re-released that version with a newer build.
+ */
+func (e *Evaluator) Evaluate(osType string, ver string, build string) (*ComplianceResult, error) {
+	versionsInfo := e.tracker.ReadVersions(osType)
+	if versionsInfo == nil {
+		return nil, fmt.Errorf("Unknown OS type %q", osType)
+	}
+
+	v, err := version.NewVersion(strings.TrimSpace(ver))
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse version %q: %v", ver, err)
+	}
+	build = strings.TrimSpace(build)
+
+	result := &ComplianceResult{
+		OSType:  osType,
+		Version: v.String(),
+		Build:   build,
+	}
+
+	line, ok := e.releaseLine(osType, v)
+	if !ok {
+		result.Status = ComplianceStatusFail
+		result.Reasons = append(result.Reasons, "version is not in a tracked release line")
+		return result, nil
+	}
+	result.Line = line
+	if update, ok := versionsInfo.SecurityUpdates[line]; ok {
+		result.LatestSecurityUpdate = update.Name
+	}
+
+	latest, ok := versionsInfo.LatestVersions[line]
+	if !ok {
+		result.Status = ComplianceStatusFail
+		result.Reasons = append(result.Reasons, fmt.Sprintf("no releases tracked for %s", line))
+		return result, nil
+	}
+	result.LatestVersion = latest.String()
+	result.LatestBuild = versionsInfo.LatestBuilds[line]
+
+	result.Supported = e.isSupported(line, versionsInfo)
+	counted := e.countMissing(result, v, build, latest, versionsInfo.LatestBuilds[line])
+
+	grace := time.Duration(e.policy.GracePeriodDays) * 24 * time.Hour
+	switch {
+	case !result.Supported:
+		result.Status = ComplianceStatusFail
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%s is no longer supported", line))
+
+	case !counted:
+		result.Status = ComplianceStatusUnknown
+		result.Reasons = append(result.Reasons, fmt.Sprintf("behind %s, but there is no release history for %s to count how far", result.LatestVersion, line))
+
+	case result.PatchesBehind == 0:
+		result.Status = ComplianceStatusPass
+
+	case result.PatchesBehind > e.policy.MaxPatchesBehind:
+		result.Status = ComplianceStatusFail
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%d releases behind; at most %d allowed", result.PatchesBehind, e.policy.MaxPatchesBehind))
+
+	case result.NewestMissingAge > grace:
+		result.Status = ComplianceStatusFail
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%s has been out for %d days; at most %d allowed", result.NewestMissingVersion, int(result.NewestMissingAge.Hours()/24), e.policy.GracePeriodDays))
+
+	default:
+		result.Status = ComplianceStatusWarn
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%d releases behind, within the %d day grace period", result.PatchesBehind, e.policy.GracePeriodDays))
+	}
+
+	return result, nil
+}
+
+/**
+ * Asks the OS's scrapers which line a version is in, falling back to the release history
+ */
+func (e *Evaluator) releaseLine(osType string, v *version.Version) (string, bool) {
+	for _, s := range e.tracker.Registry().ScrapersFor(osType) {
+		if classifier, ok := s.(LineClassifier); ok {
+			if line, ok := classifier.ReleaseLine(v); ok {
+				return line, true
+			}
+		}
+	}
+
+	for _, record := range e.tracker.History(HistoryQuery{OSType: osType, Kind: ReleaseKindUpdate, Channel: ChannelRelease}) {
+		recordVersion, err := version.NewVersion(record.Version)
+		if err == nil && recordVersion.Equal(v) {
+			return record.Line, true
+		}
+	}
+
+	return "", false
+}
+
+/**
+ * A line is supported if it is one of the policy's newest N release (not seed) lines, by latest version
+ */
+func (e *Evaluator) isSupported(line string, versionsInfo *VersionsInfo) bool {
+	if e.policy.SupportedLines == 0 {
+		return true
+	}
+
+	lines := []string{}
+	for l := range versionsInfo.LatestVersions {
+		if _, channel := LineChannel(l); channel == ChannelRelease {
+			lines = append(lines, l)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return versionsInfo.LatestVersions[lines[i]].GreaterThan(versionsInfo.LatestVersions[lines[j]])
+	})
+
+	for i, l := range lines {
+		if l == line {
+			return i < e.policy.SupportedLines
+		}
+	}
+	return false
+}
+
+/**
+ * Counts the GA releases in the line that are newer than (v, build) and finds the newest of them.
+ * Apple often ships one version as several products (e.g. hardware-specific builds), so releases
+ * are counted by version; builds only matter when a missing record has the host's own version.
+ * Returns false if the host is behind the latest version but the history has no newer release to
+ * count, e.g. on a fresh state file; PatchesBehind is left at 0 rather than guessed.
+ */
+func (e *Evaluator) countMissing(result *ComplianceResult, v *version.Version, build string, latest *version.Version, latestBuild string) bool {
+	var newest *version.Version
+	firstReleased := make(map[string]time.Time) // Version --> when its first product came out
+	for _, record := range e.tracker.History(HistoryQuery{OSType: result.OSType, Kind: ReleaseKindUpdate, Line: result.Line, Channel: ChannelRelease}) {
+		recordVersion, err := version.NewVersion(record.Version)
+		if err != nil || !IsNewerRelease(recordVersion, record.Build, v, build) {
+			continue
+		}
+
+		id := recordVersion.String()
+		releasedAt := record.ReleasedAt()
+		if first, ok := firstReleased[id]; ok {
+			if releasedAt.Before(first) {
+				firstReleased[id] = releasedAt
+			}
+			continue
+		}
+		firstReleased[id] = releasedAt
+
+		result.PatchesBehind++
+		if newest == nil || recordVersion.GreaterThan(newest) {
+			newest = recordVersion
+		}
+	}
+
+	if newest != nil {
+		releasedAt := firstReleased[newest.String()]
+		result.NewestMissingVersion = newest.String()
+		result.NewestMissingReleasedAt = &releasedAt
+	}
+
+	if result.PatchesBehind == 0 && IsNewerRelease(latest, latestBuild, v, build) {
+		result.NewestMissingVersion = latest.String()
+		return false
+	}
+
+	if result.NewestMissingReleasedAt != nil {
+		result.NewestMissingAge = e.now().Sub(*result.NewestMissingReleasedAt)
+	}
+	return true
+}
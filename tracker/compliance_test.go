@@ -0,0 +1,274 @@
+package tracker_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+)
+
+/**
+ * The lines the fake source files GA versions under
+ */
+var fakeReleaseLines = map[int]string{
+	16: "Mac-Next",
+	15: "Sequoia",
+	14: "Sonoma",
+	13: "Ventura",
+}
+
+func daysAgo(days int) time.Time {
+	return time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+/**
+ * Returns an evaluator over a tracker whose only source reports the given history.
+ * The latest version per line is worked out from the history.
+ */
+func newEvaluator(t *testing.T, policy tracker.CompliancePolicy, history ...tracker.ProductRecord) *tracker.Evaluator {
+	versionTracker := tracker.MakeTracker(300)
+	err := versionTracker.Register(&trackertest.Scraper{Records: history, Lines: fakeReleaseLines})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionTracker.Scrape(context.Background())
+
+	evaluator, err := tracker.NewEvaluator(versionTracker, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return evaluator
+}
+
+func TestEvaluate(t *testing.T) {
+	sequoia := []tracker.ProductRecord{
+		trackertest.Release("p1", "Sequoia", "15.0", "24A335", daysAgo(60)),
+		trackertest.Release("p2", "Sequoia", "15.1", "24B83", daysAgo(30)),
+		trackertest.Release("p3", "Sequoia", "15.2", "24C101", daysAgo(3)),
+	}
+	sonoma := trackertest.Release("p4", "Sonoma", "14.7", "23H124", daysAgo(40))
+	ventura := trackertest.Release("p5", "Ventura", "13.7", "22H123", daysAgo(40))
+	dashed := trackertest.Release("p9", "Mac-Next", "16.0", "25A100", daysAgo(10))
+	seed := trackertest.Release("p10", "Mac-Next-DeveloperSeed", "16.1", "25B5042a", daysAgo(5))
+	seed.Channel = tracker.ChannelDeveloperSeed
+
+	tests := []struct {
+		name          string
+		policy        tracker.CompliancePolicy
+		history       []tracker.ProductRecord
+		version       string
+		build         string
+		status        string
+		patchesBehind int
+		newestMissing string
+	}{
+		{
+			name:    "latest passes",
+			policy:  tracker.DefaultCompliancePolicy,
+			history: sequoia,
+			version: "15.2",
+			build:   "24C101",
+			status:  tracker.ComplianceStatusPass,
+		},
+		{
+			name:    "latest without a build passes",
+			policy:  tracker.DefaultCompliancePolicy,
+			history: sequoia,
+			version: "15.2",
+			status:  tracker.ComplianceStatusPass,
+		},
+		{
+			name:          "one behind within the grace period warns",
+			policy:        tracker.DefaultCompliancePolicy,
+			history:       sequoia,
+			version:       "15.1",
+			status:        tracker.ComplianceStatusWarn,
+			patchesBehind: 1,
+			newestMissing: "15.2.0",
+		},
+		{
+			name:          "two behind fails",
+			policy:        tracker.DefaultCompliancePolicy,
+			history:       sequoia,
+			version:       "15.0",
+			status:        tracker.ComplianceStatusFail,
+			patchesBehind: 2,
+			newestMissing: "15.2.0",
+		},
+		{
+			name:          "two behind warns if the policy allows it",
+			policy:        tracker.CompliancePolicy{MaxPatchesBehind: 2, GracePeriodDays: 14},
+			history:       sequoia,
+			version:       "15.0",
+			status:        tracker.ComplianceStatusWarn,
+			patchesBehind: 2,
+			newestMissing: "15.2.0",
+		},
+		{
+			name:          "one behind past the grace period fails",
+			policy:        tracker.DefaultCompliancePolicy,
+			history:       sequoia[:2],
+			version:       "15.0",
+			status:        tracker.ComplianceStatusFail,
+			patchesBehind: 1,
+			newestMissing: "15.1.0",
+		},
+		{
+			name:   "duplicate products for one version count once",
+			policy: tracker.DefaultCompliancePolicy,
+			history: append(sequoia[:2:2],
+				trackertest.Release("p6", "Sequoia", "15.2", "24C101", daysAgo(3)),
+				trackertest.Release("p7", "Sequoia", "15.2", "24C2101", daysAgo(2)),
+				trackertest.Release("p8", "Sequoia", "15.2", "", daysAgo(2)),
+			),
+			version:       "15.1",
+			build:         "24B83",
+			status:        tracker.ComplianceStatusWarn,
+			patchesBehind: 1,
+			newestMissing: "15.2.0",
+		},
+		{
+			name:   "re-released build of the host's version counts",
+			policy: tracker.DefaultCompliancePolicy,
+			history: []tracker.ProductRecord{
+				trackertest.Release("p1", "Sequoia", "15.2", "24C101", daysAgo(5)),
+				trackertest.Release("p2", "Sequoia", "15.2", "24C2101", daysAgo(2)),
+			},
+			version:       "15.2",
+			build:         "24C101",
+			status:        tracker.ComplianceStatusWarn,
+			patchesBehind: 1,
+			newestMissing: "15.2.0",
+		},
+		{
+			name:    "unsupported line fails",
+			policy:  tracker.CompliancePolicy{MaxPatchesBehind: 1, GracePeriodDays: 14, SupportedLines: 2},
+			history: append(sequoia[:3:3], sonoma, ventura),
+			version: "13.7",
+			status:  tracker.ComplianceStatusFail,
+		},
+		{
+			name:    "supported older line passes",
+			policy:  tracker.CompliancePolicy{MaxPatchesBehind: 1, GracePeriodDays: 14, SupportedLines: 2},
+			history: append(sequoia[:3:3], sonoma, ventura),
+			version: "14.7",
+			status:  tracker.ComplianceStatusPass,
+		},
+		{
+			name:    "release line with a dash in its name counts towards supported lines",
+			policy:  tracker.CompliancePolicy{MaxPatchesBehind: 1, GracePeriodDays: 14, SupportedLines: 2},
+			history: append(sequoia[:3:3], sonoma, dashed),
+			version: "14.7",
+			status:  tracker.ComplianceStatusFail,
+		},
+		{
+			name:    "seed lines don't count towards supported lines",
+			policy:  tracker.CompliancePolicy{MaxPatchesBehind: 1, GracePeriodDays: 14, SupportedLines: 2},
+			history: append(sequoia[:3:3], sonoma, ventura, seed),
+			version: "14.7",
+			status:  tracker.ComplianceStatusPass,
+		},
+		{
+			name:    "untracked line fails",
+			policy:  tracker.DefaultCompliancePolicy,
+			history: sequoia,
+			version: "10.15.7",
+			status:  tracker.ComplianceStatusFail,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evaluator := newEvaluator(t, test.policy, test.history...)
+
+			result, err := evaluator.Evaluate(tracker.OSTypeMac, test.version, test.build)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Status != test.status {
+				t.Errorf("Status = %s, want %s (reasons: %s)", result.Status, test.status, strings.Join(result.Reasons, "; "))
+			}
+			if result.PatchesBehind != test.patchesBehind {
+				t.Errorf("PatchesBehind = %d, want %d", result.PatchesBehind, test.patchesBehind)
+			}
+			if result.NewestMissingVersion != test.newestMissing {
+				t.Errorf("NewestMissingVersion = %q, want %q", result.NewestMissingVersion, test.newestMissing)
+			}
+		})
+	}
+}
+
+func TestEvaluateWithoutHistory(t *testing.T) {
+	// Only the live versions are known, e.g. straight after starting on a fresh state file
+	versionsInfo := tracker.MakeVersionsInfo()
+	versionsInfo.Update("Sequoia", version.Must(version.NewVersion("15.2")), "24C101")
+
+	versionTracker := tracker.MakeTracker(300)
+	err := versionTracker.Register(&trackertest.Scraper{Versions: versionsInfo, Lines: fakeReleaseLines})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionTracker.Scrape(context.Background())
+
+	evaluator, err := tracker.NewEvaluator(versionTracker, tracker.DefaultCompliancePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := evaluator.Evaluate(tracker.OSTypeMac, "15.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != tracker.ComplianceStatusUnknown || len(result.Reasons) != 1 {
+		t.Errorf("Status = %s (reasons: %v), want %s with a reason", result.Status, result.Reasons, tracker.ComplianceStatusUnknown)
+	}
+	if result.PatchesBehind != 0 || result.NewestMissingVersion != "15.2.0" || result.NewestMissingReleasedAt != nil {
+		t.Errorf("Got %d behind, newest missing %q released %v; want no count, just the latest version",
+			result.PatchesBehind, result.NewestMissingVersion, result.NewestMissingReleasedAt)
+	}
+
+	result, err = evaluator.Evaluate(tracker.OSTypeMac, "15.2", "24C101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != tracker.ComplianceStatusPass {
+		t.Errorf("Latest version without history: status = %s, want %s", result.Status, tracker.ComplianceStatusPass)
+	}
+}
+
+func TestEvaluateRejectsBadInput(t *testing.T) {
+	evaluator := newEvaluator(t, tracker.DefaultCompliancePolicy, trackertest.Release("p1", "Sequoia", "15.2", "24C101", daysAgo(3)))
+
+	if _, err := evaluator.Evaluate(tracker.OSTypeMac, "not a version", ""); err == nil {
+		t.Error("Expected an error for an unparseable version")
+	}
+	if _, err := evaluator.Evaluate("plan9", "15.2", ""); err == nil {
+		t.Error("Expected an error for an unknown OS type")
+	}
+}
+
+func TestCompliancePolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy tracker.CompliancePolicy
+		valid  bool
+	}{
+		{"default", tracker.DefaultCompliancePolicy, true},
+		{"zero", tracker.CompliancePolicy{}, true},
+		{"negative patches", tracker.CompliancePolicy{MaxPatchesBehind: -1}, false},
+		{"negative grace period", tracker.CompliancePolicy{GracePeriodDays: -1}, false},
+		{"negative supported lines", tracker.CompliancePolicy{SupportedLines: -1}, false},
+	}
+
+	for _, test := range tests {
+		err := test.policy.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
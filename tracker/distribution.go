@@ -0,0 +1,276 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	ProductTypeMacOSUpdate    = "macOSUpdate"
+	ProductTypeSecurityUpdate = "SecurityUpdate"
+	ProductTypeFullInstaller  = "FullInstaller"
+	ProductTypeOther          = "Other"
+)
+
+var ErrNotDistribution = errors.New("Not an installer-gui-script distribution")
+
+var stringsEntryRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"\s*=\s*"((?:[^"\\]|\\.)*)"\s*;`)
+var macOSTitleRegex = regexp.MustCompile(`^\s*(macOS|OS X)\s+(.*)$`)
+var macOSInstallerTitleRegex = regexp.MustCompile(`^\s*(Install\s+)?(macOS|OS X)\s`)
+
+/**
+ * Distribution is what we pull out of a product's .dist file
+ */
+type Distribution struct {
+	Title       string               `json:"title,omitempty"`
+	Version     string               `json:"version"`
+	Build       string               `json:"build,omitempty"`
+	ProductType string               `json:"product_type"`
+	Strings     map[string]string    `json:"strings,omitempty"` // Localization strings, e.g. SU_TITLE --> "macOS High Sierra 10.13.6 Update"
+	AuxInfo     map[string]string    `json:"auxinfo,omitempty"`
+	Choices     []DistributionChoice `json:"choices,omitempty"`
+	PkgRefs     []DistributionPkgRef `json:"pkg_refs,omitempty"`
+}
+
+type DistributionChoice struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	PkgRefs     []string `json:"pkg_refs,omitempty"`
+}
+
+type DistributionPkgRef struct {
+	ID                string `json:"id"`
+	Version           string `json:"version"`
+	PackageIdentifier string `json:"package_identifier,omitempty"`
+	InstallKBytes     string `json:"install_kbytes,omitempty"`
+	URL               string `json:"url,omitempty"`
+}
+
+type distributionXML struct {
+	XMLName      xml.Name                 `xml:"installer-gui-script"`
+	Title        string                   `xml:"title"`
+	AuxInfo      distributionDictXML      `xml:"auxinfo>dict"`
+	Choices      []distributionChoiceXML  `xml:"choice"`
+	PkgRefs      []distributionPkgRefXML  `xml:"pkg-ref"`
+	Localization []distributionStringsXML `xml:"localization>strings"`
+}
+
+type distributionDictXML struct {
+	Items []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+type distributionChoiceXML struct {
+	ID          string                  `xml:"id,attr"`
+	Title       string                  `xml:"title,attr"`
+	Description string                  `xml:"description,attr"`
+	PkgRefs     []distributionPkgRefXML `xml:"pkg-ref"`
+}
+
+type distributionPkgRefXML struct {
+	ID                string `xml:"id,attr"`
+	Version           string `xml:"version,attr"`
+	PackageIdentifier string `xml:"packageIdentifier,attr"`
+	InstallKBytes     string `xml:"installKBytes,attr"`
+	URL               string `xml:",chardata"`
+}
+
+type distributionStringsXML struct {
+	Language string `xml:"language,attr"`
+	Text     string `xml:",chardata"`
+}
+
+/**
+ * Parses an installer-gui-script distribution, resolving the title through its
+ * localization strings and classifying what kind of product it describes
+ */
+func ParseDistribution(data []byte) (*Distribution, error) {
+	var raw distributionXML
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Distributions claim utf-8 (or nothing); accept any label rather than failing the parse
+		return input, nil
+	}
+
+	err := decoder.Decode(&raw)
+	if err != nil {
+		if _, ok := err.(xml.UnmarshalError); ok {
+			return nil, ErrNotDistribution
+		}
+		return nil, err
+	}
+
+	dist := &Distribution{
+		Strings: parseLocalizationStrings(pickLocalization(raw.Localization)),
+		AuxInfo: raw.AuxInfo.toMap(),
+	}
+
+	dist.Title = dist.localize(raw.Title)
+	dist.Version = firstNonEmpty(dist.Strings["SU_VERS"], dist.Strings["SU_VERSION"], dist.AuxInfo["VERSION"], dist.AuxInfo["macOSProductVersion"])
+	dist.Build = firstNonEmpty(dist.AuxInfo["BUILD"], dist.AuxInfo["macOSProductBuildVersion"], dist.Strings["SU_BUILD"])
+
+	for _, pkgRef := range raw.PkgRefs {
+		dist.PkgRefs = append(dist.PkgRefs, DistributionPkgRef{
+			ID:                pkgRef.ID,
+			Version:           pkgRef.Version,
+			PackageIdentifier: pkgRef.PackageIdentifier,
+			InstallKBytes:     pkgRef.InstallKBytes,
+			URL:               strings.TrimSpace(pkgRef.URL),
+		})
+	}
+
+	for _, choice := range raw.Choices {
+		distChoice := DistributionChoice{
+			ID:          choice.ID,
+			Title:       dist.localize(choice.Title),
+			Description: dist.localize(choice.Description),
+		}
+		for _, pkgRef := range choice.PkgRefs {
+			distChoice.PkgRefs = append(distChoice.PkgRefs, pkgRef.ID)
+		}
+		dist.Choices = append(dist.Choices, distChoice)
+	}
+
+	dist.ProductType = dist.classify()
+
+	return dist, nil
+}
+
+/**
+ * Resolves a localization key (e.g. SU_TITLE); anything that isn't a key is returned as-is
+ */
+func (d *Distribution) localize(s string) string {
+	s = strings.TrimSpace(s)
+	if localized, ok := d.Strings[s]; ok {
+		return strings.TrimSpace(localized)
+	}
+	return s
+}
+
+func (d *Distribution) classify() string {
+	if strings.Contains(d.Title, "Security Update") {
+		return ProductTypeSecurityUpdate
+	}
+
+	// Only a macOS installer if it says so and ships InstallAssistant; anything else called
+	// "Install ..." or "... Installer ..." (notifications, other apps) is nothing we track
+	installerTitle := strings.HasPrefix(d.Title, "Install ") || strings.Contains(d.Title, "Installer")
+	if installerTitle || d.hasInstallAssistant() {
+		if d.hasInstallAssistant() && macOSInstallerTitleRegex.MatchString(d.Title) {
+			return ProductTypeFullInstaller
+		}
+		if installerTitle {
+			return ProductTypeOther
+		}
+	}
+
+	titleMatch := macOSTitleRegex.FindStringSubmatch(d.Title)
+	if len(titleMatch) == 3 && len(DiscardRegex.FindStringSubmatch(titleMatch[2])) == 0 {
+		return ProductTypeMacOSUpdate
+	}
+
+	return ProductTypeOther
+}
+
+func (d *Distribution) hasInstallAssistant() bool {
+	for _, pkgRef := range d.PkgRefs {
+		if strings.Contains(pkgRef.ID, "InstallAssistant") || strings.Contains(pkgRef.PackageIdentifier, "InstallAssistant") {
+			return true
+		}
+	}
+	return false
+}
+
+func (d distributionDictXML) toMap() map[string]string {
+	values := make(map[string]string)
+
+	var key string
+	for _, item := range d.Items {
+		if item.XMLName.Local == "key" {
+			key = strings.TrimSpace(item.Value)
+			continue
+		}
+
+		if key != "" {
+			values[key] = strings.TrimSpace(item.Value)
+			key = ""
+		}
+	}
+
+	return values
+}
+
+/**
+ * Prefers the English strings, falling back to whatever language comes first
+ */
+func pickLocalization(localizations []distributionStringsXML) string {
+	for _, language := range []string{"English", "en", "en_US"} {
+		for _, localization := range localizations {
+			if localization.Language == language {
+				return localization.Text
+			}
+		}
+	}
+
+	if len(localizations) > 0 {
+		return localizations[0].Text
+	}
+	return ""
+}
+
+/**
+ * Parses an Apple .strings body: "KEY" = "VALUE"; pairs, with backslash escapes
+ */
+func parseLocalizationStrings(text string) map[string]string {
+	values := make(map[string]string)
+
+	for _, match := range stringsEntryRegex.FindAllStringSubmatch(text, -1) {
+		values[unescapeString(match[1])] = unescapeString(match[2])
+	}
+
+	return values
+}
+
+func unescapeString(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package tracker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+)
+
+func TestParseDistribution(t *testing.T) {
+	tests := []struct {
+		file        string
+		title       string
+		version     string
+		build       string
+		productType string
+	}{
+		// SU_DESCRIPTION is HTML in single quotes and SU_SERVERCOMMENT has escaped quotes,
+		// all inside CDATA; neither should throw the other strings off
+		{"041-91758.English.dist", "macOS High Sierra 10.13.6 Update", "10.13.6", "17G65", tracker.ProductTypeMacOSUpdate},
+		// English is preferred even when another language comes first
+		{"041-88800.French.dist", "macOS High Sierra 10.13.5 Update", "10.13.5", "17F77", tracker.ProductTypeMacOSUpdate},
+		// No English at all, and no auxinfo, so the build comes from SU_BUILD
+		{"031-31014.Japanese.dist", "macOS Sierra 10.12.6 アップデート", "10.12.6", "16G29", tracker.ProductTypeMacOSUpdate},
+		{"041-25678.English.dist", "Security Update 2018-004 Sierra", "2018-004", "16G1510", tracker.ProductTypeSecurityUpdate},
+		// The version only lives in auxinfo
+		{"072-08207.English.dist", "macOS Sonoma", "14.7.1", "23H222", tracker.ProductTypeFullInstaller},
+		{"001-76479.English.dist", "Safari", "18.1", "", tracker.ProductTypeOther},
+		{"041-12345.English.dist", "macOS Installer Notification", "1.0", "", tracker.ProductTypeOther},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "distributions", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dist, err := tracker.ParseDistribution(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dist.Title != test.title {
+				t.Errorf("Title = %q, want %q", dist.Title, test.title)
+			}
+			if dist.Version != test.version {
+				t.Errorf("Version = %q, want %q", dist.Version, test.version)
+			}
+			if dist.Build != test.build {
+				t.Errorf("Build = %q, want %q", dist.Build, test.build)
+			}
+			if dist.ProductType != test.productType {
+				t.Errorf("ProductType = %q, want %q", dist.ProductType, test.productType)
+			}
+			if len(dist.Choices) != 1 || dist.Choices[0].Title != test.title || len(dist.Choices[0].PkgRefs) != 1 {
+				t.Errorf("Choices = %+v, want one localized choice with one package", dist.Choices)
+			}
+			if len(dist.PkgRefs) != 1 || dist.PkgRefs[0].ID != dist.Choices[0].PkgRefs[0] {
+				t.Errorf("PkgRefs = %+v, want the choice's package", dist.PkgRefs)
+			}
+		})
+	}
+}
+
+func TestParseDistributionStrings(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "distributions", "041-91758.English.dist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dist, err := tracker.ParseDistribution(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := dist.Strings["SU_SERVERCOMMENT"], `Combo update for "10.13" through 10.13.5`; got != want {
+		t.Errorf("SU_SERVERCOMMENT = %q, want %q", got, want)
+	}
+	if got, want := dist.PkgRefs[0].URL, "#macOSUpdCombo10.13.6.pkg"; got != want {
+		t.Errorf("Package URL = %q, want %q", got, want)
+	}
+	if got, want := dist.AuxInfo["macOSProductVersion"], "10.13.6"; got != want {
+		t.Errorf("auxinfo macOSProductVersion = %q, want %q", got, want)
+	}
+}
+
+func TestParseDistributionClassifies(t *testing.T) {
+	tests := []struct {
+		name        string
+		title       string
+		productType string
+	}{
+		{"point update", "macOS Sonoma 14.7.1", tracker.ProductTypeMacOSUpdate},
+		{"OS X update", "OS X El Capitan Update 10.11.6", tracker.ProductTypeMacOSUpdate},
+		{"security update", "Security Update 2020-001 Mojave", tracker.ProductTypeSecurityUpdate},
+		{"security update that names macOS", "macOS Catalina Security Update 2021-005", tracker.ProductTypeSecurityUpdate},
+		{"recovery", "macOS Recovery Update", tracker.ProductTypeOther},
+		{"installer without InstallAssistant", "Install macOS Sonoma", tracker.ProductTypeOther},
+		{"not macOS", "Safari 18.1", tracker.ProductTypeOther},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dist, err := tracker.ParseDistribution([]byte(trackertest.MakeDistribution(test.title, "1.0", "")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dist.ProductType != test.productType {
+				t.Errorf("%q is %q, want %q", test.title, dist.ProductType, test.productType)
+			}
+		})
+	}
+}
+
+func TestParseDistributionRejectsOtherDocuments(t *testing.T) {
+	plist := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>CFBundleShortVersionString</key><string>14.7.1</string></dict></plist>`
+
+	_, err := tracker.ParseDistribution([]byte(plist))
+	if err != tracker.ErrNotDistribution {
+		t.Errorf("ParseDistribution(plist) error = %v, want %v", err, tracker.ErrNotDistribution)
+	}
+
+	_, err = tracker.ParseDistribution([]byte(`"SU_TITLE" = "OS X El Capitan 10.11.6";`))
+	if err == nil {
+		t.Error("ParseDistribution() of a bare strings file didn't fail")
+	}
+}
@@ -0,0 +1,156 @@
+package tracker
+
+import (
+	"time"
+
+	"github.com/hashicorp/go-version"
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+ * The kinds of ChangeEvent
+ */
+const (
+	ChangeKindVersion        = "version"         // A higher version
+	ChangeKindBuild          = "build"           // The same version re-released with a higher build
+	ChangeKindSupplemental   = "supplemental"    // A supplemental update to the same version
+	ChangeKindSecurityUpdate = "security_update" // A newer security update for the line; versions are update names
+	ChangeKindFullInstaller  = "full_installer"  // A newer full installer for the line
+)
+
+/**
+ * A ChangeEvent is raised whenever a newer version shows up for an OS release line
+ */
+type ChangeEvent struct {
+	Kind       string    `json:"kind"`
+	OSType     string    `json:"os_type"`
+	Line       string    `json:"line"`
+	OldVersion string    `json:"old_version,omitempty"`
+	OldBuild   string    `json:"old_build,omitempty"`
+	NewVersion string    `json:"new_version"`
+	NewBuild   string    `json:"new_build,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	ProductKey string    `json:"product_key,omitempty"`
+	Source     string    `json:"source"`
+	Catalog    string    `json:"catalog,omitempty"`
+	Channel    string    `json:"channel,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+/**
+ * A ChangeReporter is a scraper that can say which products caused its versions to change.
+ * Changes returns everything recorded since it was last called.
+ */
+type ChangeReporter interface {
+	Scraper
+	Changes() []ChangeEvent
+}
+
+type Notifier interface {
+	Notify(event ChangeEvent) error
+}
+
+/**
+ * Adds a notifier that is told about every change event
+ */
+func (t *Tracker) AddNotifier(n Notifier) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.notifiers = append(t.notifiers, n)
+}
+
+/**
+ * Drops any events that don't move the OS-wide latest version forward
+ * (another source may already have reported something newer) and hands the rest to the notifiers
+ */
+func (t *Tracker) publishChanges(previous *VersionsInfo, events []ChangeEvent) {
+	t.mtx.RLock()
+	notifiers := t.notifiers
+	t.mtx.RUnlock()
+
+	for _, event := range events {
+		if !supersedes(&event, previous) {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"timestamp":   time.Now().UnixNano(),
+			"kind":        event.Kind,
+			"os_type":     event.OSType,
+			"line":        event.Line,
+			"old_version": event.OldVersion,
+			"old_build":   event.OldBuild,
+			"new_version": event.NewVersion,
+			"new_build":   event.NewBuild,
+			"product_key": event.ProductKey,
+		}).Info("New version detected")
+
+		for _, n := range notifiers {
+			err := n.Notify(event)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"timestamp": time.Now().UnixNano(),
+					"err":       err,
+				}).Error("Error notifying")
+			}
+		}
+	}
+}
+
+/**
+ * Reports whether the event is newer than what previous already had for its line, and if so
+ * fills in the old version and build from it
+ */
+func supersedes(event *ChangeEvent, previous *VersionsInfo) bool {
+	if event.Kind == ChangeKindSecurityUpdate {
+		if previous == nil {
+			return true
+		}
+
+		latest, ok := previous.SecurityUpdates[event.Line]
+		if !ok {
+			return true
+		}
+		if !IsNewerSecurityUpdate(SecurityUpdate{Name: event.NewVersion, Build: event.NewBuild}, latest) {
+			return false
+		}
+		event.OldVersion = latest.Name
+		event.OldBuild = latest.Build
+		return true
+	}
+
+	newVersion, err := version.NewVersion(event.NewVersion)
+	if err != nil {
+		return false
+	}
+	if previous == nil {
+		return true
+	}
+
+	if event.Kind == ChangeKindFullInstaller {
+		latest, ok := previous.FullInstallers[event.Line]
+		if !ok {
+			return true
+		}
+		latestVersion, err := version.NewVersion(latest.Version)
+		if err == nil && !IsNewerRelease(newVersion, event.NewBuild, latestVersion, latest.Build) {
+			return false
+		}
+		event.OldVersion = latest.Version
+		event.OldBuild = latest.Build
+		return true
+	}
+
+	latest, ok := previous.LatestVersions[event.Line]
+	if !ok {
+		return true
+	}
+	latestBuild := previous.LatestBuilds[event.Line]
+	if !IsNewerRelease(newVersion, event.NewBuild, latest, latestBuild) {
+		return false
+	}
+	event.OldVersion = latest.String()
+	event.OldBuild = latestBuild
+	return true
+}
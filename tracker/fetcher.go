@@ -0,0 +1,274 @@
+package tracker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrBodyTooLarge = errors.New("Response body exceeds the maximum size")
+
+/**
+ * A FetchResponse is a response whose body has already been read and closed
+ */
+type FetchResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+/**
+ * A Fetcher makes GET requests for the scrapers, conditional on the given validators if there are any.
+ * Tests and offline runs can swap in their own. Fetchers that go to the network should wait on
+ * RateLimiterFrom(ctx) before every attempt they make.
+ */
+type Fetcher interface {
+	Fetch(ctx context.Context, url string, validator *Validator) (*FetchResponse, error)
+}
+
+type HTTPConfig struct {
+	Timeout     time.Duration // Per attempt, including reading the body
+	ProxyURL    string        // Falls back to HTTP_PROXY/HTTPS_PROXY if empty
+	CAFile      string        // PEM bundle trusted in addition to the system roots
+	UserAgent   string
+	MaxRetries  int           // Retries after the first attempt on network errors, 5xx and 429
+	MinBackoff  time.Duration // Backoff before the first retry; doubles on each one after
+	MaxBackoff  time.Duration
+	MaxBodySize int64 // In bytes; <= 0 is unlimited
+}
+
+var DefaultHTTPConfig = HTTPConfig{
+	Timeout:     30 * time.Second,
+	UserAgent:   "latest-os-version-tracker",
+	MaxRetries:  3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  30 * time.Second,
+	MaxBodySize: 64 << 20,
+}
+
+/**
+ * HTTPFetcher is the Fetcher used against the real catalogs
+ */
+type HTTPFetcher struct {
+	client *http.Client
+	config HTTPConfig
+}
+
+func NewHTTPFetcher(config HTTPConfig) (*HTTPFetcher, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost:   8,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy URL %q: %v", config.ProxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %q", config.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultHTTPConfig.MinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = config.MinBackoff
+	}
+
+	return &HTTPFetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   config.Timeout,
+		},
+		config: config,
+	}, nil
+}
+
+/**
+ * Makes the request, retrying with jittered exponential backoff on network errors, 5xx and 429.
+ * A Retry-After from the server is honoured if it asks us to wait longer than we would have; if it
+ * asks for more than MaxBackoff or the context's deadline allows, we give up and return the response
+ * rather than retry early. Every attempt waits on the context's rate limiter first.
+ */
+func (f *HTTPFetcher) Fetch(ctx context.Context, rawURL string, validator *Validator) (*FetchResponse, error) {
+	limiter := RateLimiterFrom(ctx)
+
+	for attempt := 0; ; attempt++ {
+		err := limiter.Wait(ctx, rawURL)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := f.fetchOnce(ctx, rawURL, validator)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		retryable := err != nil && err != ErrBodyTooLarge
+		if resp != nil {
+			retryable = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		}
+
+		delay := f.backoff(attempt)
+		tooLong := false
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok && retryAfter > delay {
+				delay = retryAfter
+				tooLong = retryAfter > f.config.MaxBackoff
+			}
+		}
+
+		// No point waiting for a retry the deadline won't let us make
+		deadline, hasDeadline := ctx.Deadline()
+		tooLate := hasDeadline && time.Now().Add(delay).After(deadline)
+
+		if retryable && (tooLong || tooLate) {
+			log.WithFields(log.Fields{
+				"timestamp":   time.Now().UnixNano(),
+				"url":         rawURL,
+				"delay":       delay,
+				"max_backoff": f.config.MaxBackoff,
+				"deadline":    deadline,
+			}).Warn("Not retrying request; the server asked us to wait longer than we can")
+		}
+
+		if !retryable || attempt >= f.config.MaxRetries || tooLong || tooLate {
+			if err != nil {
+				log.WithFields(log.Fields{
+					"timestamp": time.Now().UnixNano(),
+					"url":       rawURL,
+					"err":       err,
+				}).Error("Error making request")
+			}
+			return resp, err
+		}
+
+		reason := "error"
+		if resp != nil {
+			reason = strconv.Itoa(resp.StatusCode)
+		}
+		httpRetries.WithLabelValues(reason).Inc()
+
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"url":       rawURL,
+			"attempt":   attempt + 1,
+			"reason":    reason,
+			"delay":     delay,
+			"err":       err,
+		}).Warn("Retrying request")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (f *HTTPFetcher) fetchOnce(ctx context.Context, rawURL string, validator *Validator) (*FetchResponse, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if f.config.UserAgent != "" {
+		req.Header.Set("User-Agent", f.config.UserAgent)
+	}
+	if validator != nil {
+		validator.apply(req)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if f.config.MaxBodySize > 0 {
+		body = io.LimitReader(resp.Body, f.config.MaxBodySize+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if f.config.MaxBodySize > 0 && int64(len(data)) > f.config.MaxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+
+	return &FetchResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       data,
+	}, nil
+}
+
+/**
+ * Returns a delay somewhere between half and all of MinBackoff * 2^attempt, capped at MaxBackoff
+ */
+func (f *HTTPFetcher) backoff(attempt int) time.Duration {
+	delay := f.config.MinBackoff << uint(attempt)
+	if delay <= 0 || delay > f.config.MaxBackoff {
+		delay = f.config.MaxBackoff
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+/**
+ * Retry-After is either a number of seconds or an HTTP date
+ */
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
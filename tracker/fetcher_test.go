@@ -0,0 +1,308 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+ * A server that answers with the next status in statuses (the last one repeats), setting
+ * Retry-After on every response if retryAfter isn't empty
+ */
+type flakyServer struct {
+	*httptest.Server
+	statuses   []int
+	retryAfter string
+
+	mtx      sync.Mutex
+	requests int
+}
+
+func newFlakyServer(t *testing.T, retryAfter string, statuses ...int) *flakyServer {
+	s := &flakyServer{statuses: statuses, retryAfter: retryAfter}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mtx.Lock()
+		status := s.statuses[len(s.statuses)-1]
+		if s.requests < len(s.statuses) {
+			status = s.statuses[s.requests]
+		}
+		s.requests++
+		s.mtx.Unlock()
+
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("body"))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *flakyServer) count() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.requests
+}
+
+func newTestFetcher(t *testing.T, config HTTPConfig) *HTTPFetcher {
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	if config.MinBackoff == 0 {
+		config.MinBackoff = time.Millisecond
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 10 * time.Millisecond
+	}
+
+	fetcher, err := NewHTTPFetcher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetcher
+}
+
+func TestHTTPFetcherRetryCounts(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxRetries   int
+		wantStatus   int
+		wantRequests int
+	}{
+		{"success", []int{200}, 3, 200, 1},
+		{"5xx then success", []int{500, 502, 200}, 3, 200, 3},
+		{"429 then success", []int{429, 200}, 3, 200, 2},
+		{"gives up after retries", []int{503}, 2, 503, 3},
+		{"no retries", []int{503}, 0, 503, 1},
+		{"4xx is not retried", []int{404}, 3, 404, 1},
+		{"304 is not retried", []int{304}, 3, 304, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFlakyServer(t, "", test.statuses...)
+			fetcher := newTestFetcher(t, HTTPConfig{MaxRetries: test.maxRetries})
+
+			resp, err := fetcher.Fetch(context.Background(), server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("Status = %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			if server.count() != test.wantRequests {
+				t.Errorf("Server got %d requests, want %d", server.count(), test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherGivesUpOnLongRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+	}{
+		{"seconds", http.StatusServiceUnavailable, "120"},
+		{"date", http.StatusServiceUnavailable, time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat)},
+		{"429", http.StatusTooManyRequests, "120"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFlakyServer(t, test.retryAfter, test.status, http.StatusOK)
+			fetcher := newTestFetcher(t, HTTPConfig{MaxRetries: 3, MaxBackoff: 30 * time.Second})
+
+			start := time.Now()
+			resp, err := fetcher.Fetch(context.Background(), server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Errorf("Status = %d, want %d", resp.StatusCode, test.status)
+			}
+			if server.count() != 1 {
+				t.Errorf("Server got %d requests, want 1; retrying before Retry-After is up ignores it", server.count())
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Fetch took %v waiting for a retry it shouldn't make", elapsed)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherHonoursRetryAfter(t *testing.T) {
+	server := newFlakyServer(t, "1", http.StatusServiceUnavailable, http.StatusOK)
+	fetcher := newTestFetcher(t, HTTPConfig{MaxRetries: 1, MaxBackoff: 2 * time.Second})
+
+	start := time.Now()
+	resp, err := fetcher.Fetch(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Status = %d, want 200", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Retried after %v, before the server's Retry-After of 1s", elapsed)
+	}
+}
+
+func TestHTTPFetcherRetryAfterPastDeadline(t *testing.T) {
+	server := newFlakyServer(t, "1", http.StatusServiceUnavailable, http.StatusOK)
+	fetcher := newTestFetcher(t, HTTPConfig{MaxRetries: 1, MaxBackoff: 2 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	resp, err := fetcher.Fetch(ctx, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || server.count() != 1 {
+		t.Errorf("Got %d after %d requests, want 503 after 1", resp.StatusCode, server.count())
+	}
+}
+
+func TestHTTPFetcherDoesNotWaitPastDeadline(t *testing.T) {
+	server := newFlakyServer(t, "", http.StatusServiceUnavailable)
+	fetcher := newTestFetcher(t, HTTPConfig{MaxRetries: 3, MinBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := fetcher.Fetch(ctx, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Status = %d, want 503", resp.StatusCode)
+	}
+	if server.count() != 1 {
+		t.Errorf("Server got %d requests, want 1", server.count())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Fetch took %v waiting for a retry past the deadline", elapsed)
+	}
+}
+
+func TestHTTPFetcherRetriesWaitOnRateLimiter(t *testing.T) {
+	server := newFlakyServer(t, "", http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+	fetcher := newTestFetcher(t, HTTPConfig{MaxRetries: 3})
+
+	// One request up front, then one every 50ms
+	limiter := NewRateLimiter(0, 20, 1)
+	ctx := WithRateLimiter(context.Background(), limiter)
+
+	start := time.Now()
+	_, err := fetcher.Fetch(ctx, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.count() != 3 {
+		t.Fatalf("Server got %d requests, want 3", server.count())
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Three attempts took %v; retries should wait on the rate limiter", elapsed)
+	}
+}
+
+func TestHTTPFetcherBodyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr error
+	}{
+		{"under", 200, nil},
+		{"exact", 100, nil},
+		{"over", 99, ErrBodyTooLarge},
+		{"unlimited", 0, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := newTestFetcher(t, HTTPConfig{MaxRetries: 3, MaxBodySize: test.limit})
+
+			resp, err := fetcher.Fetch(context.Background(), server.URL, nil)
+			if err != test.wantErr {
+				t.Fatalf("Fetch() error = %v, want %v", err, test.wantErr)
+			}
+			if err == nil && len(resp.Body) != 100 {
+				t.Errorf("Body is %d bytes, want 100", len(resp.Body))
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherBackoff(t *testing.T) {
+	fetcher := newTestFetcher(t, HTTPConfig{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{100, time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			got := fetcher.backoff(test.attempt)
+			if got < test.max/2 || got > test.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", test.attempt, got, test.max/2, test.max)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"empty", "", 0, false},
+		{"seconds", "120", 2 * time.Minute, true},
+		{"zero", "0", 0, true},
+		{"negative", "-5", 0, false},
+		{"garbage", "soon", 0, false},
+		{"future date", now.Add(time.Hour).UTC().Format(http.TimeFormat), time.Hour, true},
+		{"past date", now.Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+		{"RFC 850 date", now.Add(time.Hour).UTC().Format("Monday, 02-Jan-06 15:04:05 GMT"), time.Hour, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(test.value)
+			if ok != test.wantOK {
+				t.Fatalf("parseRetryAfter(%q) ok = %v, want %v", test.value, ok, test.wantOK)
+			}
+
+			// HTTP dates only have second precision
+			if diff := got - test.want; diff < -2*time.Second || diff > 2*time.Second {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package tracker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	ReleaseKindUpdate         = "update"          // A point release, e.g. 10.13.6
+	ReleaseKindSecurityUpdate = "security_update" // A dated security update, e.g. 2018-002
+	ReleaseKindFullInstaller  = "full_installer"  // A full "Install macOS ..." app
+)
+
+/**
+ * A HistoryReporter is a scraper that remembers every release it has seen, not just the latest per line
+ */
+type HistoryReporter interface {
+	Scraper
+	History() []ProductRecord
+}
+
+/**
+ * Filters for Tracker.History. Empty fields match everything.
+ */
+type HistoryQuery struct {
+	OSType  string
+	Kind    string
+	Line    string
+	Channel string
+	Since   time.Time // Inclusive, compared against ReleasedAt
+	Until   time.Time // Exclusive, compared against ReleasedAt
+}
+
+func (q HistoryQuery) matches(record ProductRecord) bool {
+	if q.OSType != "" && record.OSType != q.OSType {
+		return false
+	}
+	if q.Kind != "" && record.ReleaseKind() != q.Kind {
+		return false
+	}
+	if q.Line != "" && record.Line != q.Line {
+		return false
+	}
+	if q.Channel != "" && record.Channel != q.Channel {
+		return false
+	}
+
+	releasedAt := record.ReleasedAt()
+	if !q.Since.IsZero() && releasedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !releasedAt.Before(q.Until) {
+		return false
+	}
+
+	return true
+}
+
+/**
+ * Returns what kind of release this is; records from before kinds were recorded are all updates
+ */
+func (r ProductRecord) ReleaseKind() string {
+	if r.Kind == "" {
+		return ReleaseKindUpdate
+	}
+	return r.Kind
+}
+
+/**
+ * When the release came out: Apple's PostDate if the catalog had one, otherwise when we first saw it
+ */
+func (r ProductRecord) ReleasedAt() time.Time {
+	if !r.PostDate.IsZero() {
+		return r.PostDate
+	}
+	return r.FirstSeen
+}
+
+/**
+ * Returns every recorded release matching the query, oldest first
+ */
+func (t *Tracker) History(query HistoryQuery) []ProductRecord {
+	records := []ProductRecord{}
+	for _, s := range t.registry.ScrapersFor(query.OSType) {
+		reporter, ok := s.(HistoryReporter)
+		if !ok {
+			continue
+		}
+
+		for _, record := range reporter.History() {
+			if query.matches(record) {
+				records = append(records, record)
+			}
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i].ReleasedAt(), records[j].ReleasedAt()
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		if records[i].Key != records[j].Key {
+			return records[i].Key < records[j].Key
+		}
+		return CompareBuilds(records[i].Build, records[j].Build) < 0
+	})
+
+	return records
+}
+
+/**
+ * Rebuilds the latest versions for an OS type as they stood at the given moment, from the
+ * release history rather than the live map. LastModified is when the newest of those came out.
+ * Releases we only saw after the fact still count from their PostDate.
+ */
+func (t *Tracker) VersionsAsOf(osType string, at time.Time) *VersionsInfo {
+	versionsInfo := MakeVersionsInfo()
+
+	for _, record := range t.History(HistoryQuery{OSType: osType, Until: at.Add(time.Nanosecond)}) {
+		updated := false
+
+		switch record.ReleaseKind() {
+		case ReleaseKindUpdate:
+			ver, err := version.NewVersion(record.Version)
+			if err != nil {
+				continue
+			}
+			updated = versionsInfo.Update(record.Line, ver, record.Build)
+
+		case ReleaseKindSecurityUpdate:
+			updated = versionsInfo.UpdateSecurityUpdate(record.Line, SecurityUpdate{
+				Name:       record.Version,
+				Build:      record.Build,
+				Title:      record.Title,
+				ProductKey: record.Key,
+				PostDate:   record.PostDate,
+			})
+
+		case ReleaseKindFullInstaller:
+			installer := FullInstaller{
+				Version:    record.Version,
+				Build:      record.Build,
+				Title:      record.Title,
+				ProductKey: record.Key,
+				PostDate:   record.PostDate,
+			}
+			if record.Package != nil {
+				installer.Package = *record.Package
+			}
+			updated = versionsInfo.UpdateFullInstaller(record.Line, installer)
+		}
+
+		if updated {
+			versionsInfo.LastModified = record.ReleasedAt()
+		}
+	}
+
+	return versionsInfo
+}
+
+/**
+ * Parses a time given on the command line or in a query string, either RFC 3339 or just a date (UTC)
+ */
+func ParseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %q; expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+/**
+ * Identifies a release in a scraper's history. A product key is normally a single release,
+ * but Apple has been known to re-post a key with a new build, and we keep both.
+ */
+func productRecordID(key string, version string, build string) string {
+	return key + "/" + version + "/" + build
+}
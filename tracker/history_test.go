@@ -0,0 +1,217 @@
+package tracker_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+)
+
+var (
+	posted15_1 = time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC)
+	posted15_2 = time.Date(2024, time.December, 11, 18, 0, 0, 0, time.UTC)
+)
+
+/**
+ * Returns a tracker whose only source reports the given history
+ */
+func newHistoryTracker(t *testing.T, history ...tracker.ProductRecord) *tracker.Tracker {
+	versionTracker := tracker.MakeTracker(300)
+	err := versionTracker.Register(&trackertest.Scraper{Records: history})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return versionTracker
+}
+
+func historyKeys(records []tracker.ProductRecord) string {
+	keys := []string{}
+	for _, record := range records {
+		keys = append(keys, record.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestHistoryQuery(t *testing.T) {
+	seed := trackertest.Release("seed", "Sequoia-DeveloperSeed", "15.3", "24D5034f", posted15_2)
+	seed.Channel = tracker.ChannelDeveloperSeed
+
+	// No PostDate, so it counts from when we first saw it
+	unposted := trackertest.Release("unposted", "Sonoma", "14.7.2", "23H311", time.Time{})
+	unposted.FirstSeen = posted15_2.Add(time.Hour)
+
+	securityUpdate := trackertest.Release("secupd", "Sonoma", "2024-001", "23H2020", posted15_1)
+	securityUpdate.Kind = tracker.ReleaseKindSecurityUpdate
+
+	versionTracker := newHistoryTracker(t,
+		trackertest.Release("15.2", "Sequoia", "15.2", "24C101", posted15_2),
+		unposted,
+		seed,
+		trackertest.Release("15.1", "Sequoia", "15.1", "24B83", posted15_1),
+		securityUpdate,
+	)
+
+	tests := []struct {
+		name  string
+		query tracker.HistoryQuery
+		want  string
+	}{
+		{"everything, oldest first", tracker.HistoryQuery{}, "15.1,secupd,15.2,seed,unposted"},
+		{"line", tracker.HistoryQuery{Line: "Sequoia"}, "15.1,15.2"},
+		{"channel", tracker.HistoryQuery{Channel: tracker.ChannelDeveloperSeed}, "seed"},
+		{"kind", tracker.HistoryQuery{Kind: tracker.ReleaseKindUpdate}, "15.1,15.2,seed,unposted"},
+		{"other OS", tracker.HistoryQuery{OSType: tracker.OSTypeWindows}, ""},
+		{"since is inclusive", tracker.HistoryQuery{Since: posted15_2}, "15.2,seed,unposted"},
+		{"until is exclusive", tracker.HistoryQuery{Until: posted15_2}, "15.1,secupd"},
+		{"since and until", tracker.HistoryQuery{Since: posted15_1.Add(time.Second), Until: posted15_2.Add(2 * time.Hour)}, "15.2,seed,unposted"},
+		{"first seen", tracker.HistoryQuery{Since: posted15_2.Add(time.Minute)}, "unposted"},
+		{"before any history", tracker.HistoryQuery{Until: posted15_1}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := historyKeys(versionTracker.History(test.query)); got != test.want {
+				t.Errorf("History(%+v) = %s, want %s", test.query, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2024-10-28T17:00:00Z", want: posted15_1},
+		{value: "2024-10-28T10:00:00-07:00", want: posted15_1},
+		{value: "2024-10-28T17:00:00.5Z", want: posted15_1.Add(500 * time.Millisecond)},
+		{value: "2024-10-28", want: time.Date(2024, time.October, 28, 0, 0, 0, 0, time.UTC)},
+		{value: "", wantErr: true},
+		{value: "yesterday", wantErr: true},
+		{value: "10/28/2024", wantErr: true},
+		{value: "2024-13-01", wantErr: true},
+		{value: "2024-10-28 17:00:00", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := tracker.ParseQueryTime(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseQueryTime(%q) = %v, want an error", test.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("ParseQueryTime(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+/**
+ * Returns line --> "version (build)" for every line with a latest version
+ */
+func versionsOf(versionsInfo *tracker.VersionsInfo) map[string]string {
+	versions := make(map[string]string)
+	for line, ver := range versionsInfo.LatestVersions {
+		versions[line] = ver.String() + " (" + versionsInfo.LatestBuilds[line] + ")"
+	}
+	return versions
+}
+
+func TestVersionsAsOf(t *testing.T) {
+	securityUpdate := trackertest.Release("secupd", "Sonoma", "2024-001", "23H2020", posted15_1)
+	securityUpdate.Kind = tracker.ReleaseKindSecurityUpdate
+
+	installer := trackertest.Release("installer", "Sequoia", "15.2", "24C101", posted15_2.Add(time.Hour))
+	installer.Kind = tracker.ReleaseKindFullInstaller
+
+	seenLater := trackertest.Release("seen-later", "Ventura", "13.7.2", "22H313", time.Time{})
+	seenLater.FirstSeen = posted15_2
+
+	versionTracker := newHistoryTracker(t,
+		trackertest.Release("a-15.1", "Sequoia", "15.1", "24B83", posted15_1),
+		// Posted at the same moment; the older one sorts last but mustn't win
+		trackertest.Release("b-15.2", "Sequoia", "15.2", "24C101", posted15_2),
+		trackertest.Release("c-15.1.1", "Sequoia", "15.1.1", "24B91", posted15_2),
+		trackertest.Release("d-14.7.2", "Sonoma", "14.7.2", "23H311", posted15_2),
+		securityUpdate,
+		installer,
+		seenLater,
+	)
+
+	tests := []struct {
+		name           string
+		at             time.Time
+		versions       map[string]string
+		securityUpdate string
+		installer      string
+		lastModified   time.Time
+	}{
+		{
+			name:     "before any history",
+			at:       posted15_1.Add(-time.Nanosecond),
+			versions: map[string]string{},
+		},
+		{
+			name:           "exactly when the first release came out",
+			at:             posted15_1,
+			versions:       map[string]string{"Sequoia": "15.1.0 (24B83)"},
+			securityUpdate: "2024-001",
+			lastModified:   posted15_1,
+		},
+		{
+			name:           "just before the next releases",
+			at:             posted15_2.Add(-time.Nanosecond),
+			versions:       map[string]string{"Sequoia": "15.1.0 (24B83)"},
+			securityUpdate: "2024-001",
+			lastModified:   posted15_1,
+		},
+		{
+			name:           "exactly when several releases came out",
+			at:             posted15_2,
+			versions:       map[string]string{"Sequoia": "15.2.0 (24C101)", "Sonoma": "14.7.2 (23H311)", "Ventura": "13.7.2 (22H313)"},
+			securityUpdate: "2024-001",
+			lastModified:   posted15_2,
+		},
+		{
+			name:           "after everything",
+			at:             posted15_2.Add(24 * time.Hour),
+			versions:       map[string]string{"Sequoia": "15.2.0 (24C101)", "Sonoma": "14.7.2 (23H311)", "Ventura": "13.7.2 (22H313)"},
+			securityUpdate: "2024-001",
+			installer:      "15.2",
+			lastModified:   posted15_2.Add(time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			versionsInfo := versionTracker.VersionsAsOf(tracker.OSTypeMac, test.at)
+
+			if got := versionsOf(versionsInfo); !reflect.DeepEqual(got, test.versions) {
+				t.Errorf("Versions = %v, want %v", got, test.versions)
+			}
+			if got := versionsInfo.SecurityUpdates["Sonoma"].Name; got != test.securityUpdate {
+				t.Errorf("Sonoma security update = %q, want %q", got, test.securityUpdate)
+			}
+			if got := versionsInfo.FullInstallers["Sequoia"].Version; got != test.installer {
+				t.Errorf("Sequoia full installer = %q, want %q", got, test.installer)
+			}
+			if !versionsInfo.LastModified.Equal(test.lastModified) {
+				t.Errorf("LastModified = %v, want %v", versionsInfo.LastModified, test.lastModified)
+			}
+		})
+	}
+
+	if versions := versionsOf(versionTracker.VersionsAsOf(tracker.OSTypeWindows, posted15_2)); len(versions) != 0 {
+		t.Errorf("Windows versions = %v, want none", versions)
+	}
+}
@@ -0,0 +1,172 @@
+package tracker
+
+import (
+	"path"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+ * The package that carries the whole installer in InstallAssistant products.
+ * Older ones (before Big Sur) ship InstallAssistantAuto.pkg plus an InstallESD instead.
+ */
+var installAssistantPackageNames = []string{"InstallAssistant.pkg", "InstallAssistantAuto.pkg"}
+
+/**
+ * An InstallerPackage is the downloadable package of a full installer, as listed in the catalog
+ */
+type InstallerPackage struct {
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	Digest string `json:"digest,omitempty"` // As given by the catalog; SHA-1 for most packages
+}
+
+/**
+ * A FullInstaller is a complete "Install macOS ..." app (an InstallAssistant product), as used
+ * for imaging and major upgrades, rather than an incremental update
+ */
+type FullInstaller struct {
+	Version    string           `json:"version"`
+	Build      string           `json:"build,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	ProductKey string           `json:"product_key,omitempty"`
+	PostDate   time.Time        `json:"post_date,omitempty"`
+	Package    InstallerPackage `json:"package"`
+}
+
+/**
+ * Reports whether a catalog product is a full installer. The catalog says so in
+ * ExtendedMetaInfo for anything from the last few years; older ones are recognised by their package.
+ */
+func IsFullInstallerProduct(product *Product) bool {
+	if len(product.ExtendedMetaInfo.InstallAssistantPackageIdentifiers) > 0 {
+		return true
+	}
+
+	_, ok := installAssistantPackage(product)
+	return ok
+}
+
+/**
+ * Returns the InstallAssistant package of a full installer product
+ */
+func installAssistantPackage(product *Product) (*Package, bool) {
+	for _, name := range installAssistantPackageNames {
+		for _, pkg := range product.Packages {
+			if pkg != nil && path.Base(pkg.URL) == name {
+				return pkg, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+/**
+ * Records installer as the latest full installer for a line if it is newer than what we have.
+ * Returns true if it was.
+ */
+func (v *VersionsInfo) UpdateFullInstaller(line string, installer FullInstaller) bool {
+	if v.FullInstallers == nil {
+		v.FullInstallers = map[string]FullInstaller{}
+	}
+
+	ver, err := version.NewVersion(installer.Version)
+	if err != nil {
+		return false
+	}
+
+	latest, ok := v.FullInstallers[line]
+	if ok {
+		latestVersion, err := version.NewVersion(latest.Version)
+		if err == nil && !IsNewerRelease(ver, installer.Build, latestVersion, latest.Build) {
+			return false
+		}
+	}
+
+	v.FullInstallers[line] = installer
+	return true
+}
+
+/**
+ * Applies a full installer product to the versions info.
+ * Returns true if it is the newest for its line. Must be called with s.mtx held.
+ */
+func (s *MacScraper) applyFullInstaller(productFetch *productFetch, catalog Catalog) bool {
+	key := productFetch.key
+	dist := productFetch.dist
+
+	pkg, ok := installAssistantPackage(productFetch.product)
+	if !ok {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"key":       key,
+			"title":     dist.Title,
+		}).Debug("Full installer has no InstallAssistant package")
+		return false
+	}
+
+	ver := firstNonEmpty(dist.Version, productFetch.product.ExtendedMetaInfo.ProductVersion)
+	v1, err := version.NewVersion(ver)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":                    err,
+			"englishDistributionURL": productFetch.distributionURL,
+			"key":                    key,
+			"version":                ver,
+		}).Error("Could not parse full installer version")
+		parseFailures.WithLabelValues(parseKindVersion).Inc()
+		return false
+	}
+
+	releaseLine, ok := s.releaseLines.Line(v1)
+	if !ok {
+		log.WithFields(log.Fields{
+			"englishDistributionURL": productFetch.distributionURL,
+			"key":                    key,
+			"version":                ver,
+		}).Debug("Not tracked version")
+		return false
+	}
+
+	line := channelLine(releaseLine, catalog.Channel)
+	installer := FullInstaller{
+		Version:    v1.String(),
+		Build:      dist.Build,
+		Title:      dist.Title,
+		ProductKey: key,
+		PostDate:   productFetch.product.PostDate,
+		Package: InstallerPackage{
+			URL:    pkg.URL,
+			Size:   pkg.Size,
+			Digest: pkg.Digest,
+		},
+	}
+
+	previous, hadPrevious := s.versionsInfo.FullInstallers[line]
+	changed := s.versionsInfo.UpdateFullInstaller(line, installer)
+	if changed {
+		event := ChangeEvent{
+			Kind:       ChangeKindFullInstaller,
+			Line:       line,
+			NewVersion: installer.Version,
+			NewBuild:   installer.Build,
+			Title:      dist.Title,
+			ProductKey: key,
+		}
+		if hadPrevious {
+			event.OldVersion = previous.Version
+			event.OldBuild = previous.Build
+		}
+		s.recordChange(event, catalog)
+
+		s.versionsInfo.LastModified = time.Now()
+	}
+
+	record := s.recordProduct(key, ReleaseKindFullInstaller, line, installer.Version, dist, productFetch.distributionURL, productFetch.product.PostDate, catalog)
+	record.Package = &installer.Package
+
+	return changed
+}
@@ -0,0 +1,216 @@
+package tracker
+
+import (
+	"github.com/phoebesimon/version_tracker/metrics"
+)
+
+const (
+	metricsNamespace = "os_version_tracker_"
+
+	fetchResultOK          = "ok"
+	fetchResultNotModified = "not_modified"
+	fetchResultError       = "error"
+
+	productStateChanged   = "changed"
+	productStateUnchanged = "unchanged"
+
+	parseKindCatalog        = "catalog"
+	parseKindDistribution   = "distribution"
+	parseKindVersion        = "version"
+	parseKindServerMetadata = "server_metadata"
+)
+
+var (
+	catalogFetches = metrics.NewCounterVec(
+		metricsNamespace+"catalog_fetches_total",
+		"Number of catalog fetches, by catalog and result",
+		"catalog", "result",
+	)
+	distributionFetches = metrics.NewCounterVec(
+		metricsNamespace+"distribution_fetches_total",
+		"Number of distribution fetches, by result",
+		"result",
+	)
+	notModified = metrics.NewCounterVec(
+		metricsNamespace+"not_modified_total",
+		"Number of fetches short-circuited because the resource had not been modified",
+		"kind",
+	)
+	catalogProducts = metrics.NewCounterVec(
+		metricsNamespace+"catalog_products_total",
+		"Number of catalog products processed, by catalog and whether they were new/re-posted or unchanged",
+		"catalog", "state",
+	)
+	parseFailures = metrics.NewCounterVec(
+		metricsNamespace+"parse_failures_total",
+		"Number of responses that could not be parsed, by kind",
+		"kind",
+	)
+	httpRetries = metrics.NewCounterVec(
+		metricsNamespace+"http_retries_total",
+		"Number of requests retried, by status code (or \"error\" for network errors)",
+		"reason",
+	)
+	scrapeDuration = metrics.NewHistogramVec(
+		metricsNamespace+"scrape_duration_seconds",
+		"How long each scrape of a source took",
+		metrics.DefaultBuckets,
+		"source", "os_type",
+	)
+)
+
+func init() {
+	metrics.MustRegister(catalogFetches, distributionFetches, notModified, catalogProducts, parseFailures, httpRetries, scrapeDuration)
+}
+
+/**
+ * Returns the collectors that report the tracker's current state: one info-style
+ * gauge per OS/line/latest version, security update and full installer plus the time of each source's last scrape
+ */
+func (t *Tracker) Collectors() []metrics.Collector {
+	latestVersion := metrics.NewGaugeFunc(
+		metricsNamespace+"latest_version_info",
+		"The latest version (and build, where known) seen for each OS release line; always 1",
+		[]string{"os_type", "line", "version", "build"},
+		func() []metrics.Sample {
+			samples := []metrics.Sample{}
+			for _, osType := range t.OSTypes() {
+				versionsInfo := t.ReadVersions(osType)
+				if versionsInfo == nil {
+					continue
+				}
+
+				for line, ver := range versionsInfo.LatestVersions {
+					samples = append(samples, metrics.Sample{
+						LabelValues: []string{osType, line, ver.String(), versionsInfo.LatestBuilds[line]},
+						Value:       1,
+					})
+				}
+			}
+			return samples
+		},
+	)
+
+	latestSecurityUpdate := metrics.NewGaugeFunc(
+		metricsNamespace+"latest_security_update_info",
+		"The latest security update seen for each OS release line that gets them; always 1",
+		[]string{"os_type", "line", "name", "build"},
+		func() []metrics.Sample {
+			samples := []metrics.Sample{}
+			for _, osType := range t.OSTypes() {
+				versionsInfo := t.ReadVersions(osType)
+				if versionsInfo == nil {
+					continue
+				}
+
+				for line, update := range versionsInfo.SecurityUpdates {
+					samples = append(samples, metrics.Sample{
+						LabelValues: []string{osType, line, update.Name, update.Build},
+						Value:       1,
+					})
+				}
+			}
+			return samples
+		},
+	)
+
+	latestFullInstaller := metrics.NewGaugeFunc(
+		metricsNamespace+"latest_full_installer_info",
+		"The latest full installer seen for each OS release line; always 1",
+		[]string{"os_type", "line", "version", "build"},
+		func() []metrics.Sample {
+			samples := []metrics.Sample{}
+			for _, osType := range t.OSTypes() {
+				versionsInfo := t.ReadVersions(osType)
+				if versionsInfo == nil {
+					continue
+				}
+
+				for line, installer := range versionsInfo.FullInstallers {
+					samples = append(samples, metrics.Sample{
+						LabelValues: []string{osType, line, installer.Version, installer.Build},
+						Value:       1,
+					})
+				}
+			}
+			return samples
+		},
+	)
+
+	lastModified := metrics.NewGaugeFunc(
+		metricsNamespace+"last_modified_timestamp_seconds",
+		"When the versions for each OS type last changed",
+		[]string{"os_type"},
+		func() []metrics.Sample {
+			samples := []metrics.Sample{}
+			for _, osType := range t.OSTypes() {
+				versionsInfo := t.ReadVersions(osType)
+				if versionsInfo == nil || versionsInfo.LastModified.IsZero() {
+					continue
+				}
+
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{osType},
+					Value:       float64(versionsInfo.LastModified.Unix()),
+				})
+			}
+			return samples
+		},
+	)
+
+	lastScrape := metrics.NewGaugeFunc(
+		metricsNamespace+"last_scrape_timestamp_seconds",
+		"When each source was last scraped",
+		[]string{"source", "os_type"},
+		func() []metrics.Sample {
+			return t.scrapeStatusSamples(func(status ScrapeStatus) float64 {
+				return float64(status.LastScrape.Unix())
+			})
+		},
+	)
+
+	lastSuccess := metrics.NewGaugeFunc(
+		metricsNamespace+"last_success_timestamp_seconds",
+		"When each source was last scraped without error",
+		[]string{"source", "os_type"},
+		func() []metrics.Sample {
+			return t.scrapeStatusSamples(func(status ScrapeStatus) float64 {
+				if status.LastSuccess.IsZero() {
+					return 0
+				}
+				return float64(status.LastSuccess.Unix())
+			})
+		},
+	)
+
+	up := metrics.NewGaugeFunc(
+		metricsNamespace+"scrape_up",
+		"Whether the last scrape of each source succeeded",
+		[]string{"source", "os_type"},
+		func() []metrics.Sample {
+			return t.scrapeStatusSamples(func(status ScrapeStatus) float64 {
+				if status.LastError != nil {
+					return 0
+				}
+				return 1
+			})
+		},
+	)
+
+	return []metrics.Collector{latestVersion, latestSecurityUpdate, latestFullInstaller, lastModified, lastScrape, lastSuccess, up}
+}
+
+func (t *Tracker) scrapeStatusSamples(value func(ScrapeStatus) float64) []metrics.Sample {
+	samples := []metrics.Sample{}
+	for _, status := range t.ReadScrapeStatus("") {
+		if status.LastScrape.IsZero() {
+			continue
+		}
+
+		samples = append(samples, metrics.Sample{
+			LabelValues: []string{status.Source, status.OSType},
+			Value:       value(status),
+		})
+	}
+	return samples
+}
@@ -1,114 +1,126 @@
 package tracker
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/go-version"
 	log "github.com/sirupsen/logrus"
-	"howett.net/plist"
 )
 
 const (
-	catalogURL = "https://swscan.apple.com/content/catalogs/others/"
-)
+	CatalogURL = "https://swscan.apple.com/content/catalogs/others/"
 
-var MacCatalogs = map[string]string{
-	//"10.6":  "index-leopard-snowleopard.merged-1.sucatalog",
-	//"10.7":  "index-lion-snowleopard-leopard.merged-1.sucatalog",
-	//"10.8":  "index-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog",
-	//"10.9":  "index-10.9-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog",
-	//"10.10": "index-10.10-10.9-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog",
-	//"10.11": "index-10.11-10.10-10.9-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog",
-	//"10.12": "index-10.12-10.11-10.10-10.9-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog",
-	"10.13": "index-10.13-10.12-10.11-10.10-10.9-mountainlion-lion-snowleopard-leopard.merged-1.sucatalog",
-}
+	MacScraperName = "macos-catalog"
+)
 
 var VersionRegex = regexp.MustCompile(`(?ms)\s*"\s*(SU_VERS|SU_VERSION)\s*"\s*=\s*"\s*([0-9a-zA-Z\.\s]+)\s*"\s*;$`)
-var TitleRegex = regexp.MustCompile(`(?ms)\s*"\s*(SU_TITLE)\s*"\s*=\s*"\s*(macOS 10|macOS Sierra|OS X)([0-9a-zA-Z\.\s]+)\s*"\s*;$`)
-var DiscardRegex = regexp.MustCompile(`(?ms)\s*([0-9a-zA-Z\.\s]*)\s*(Mavericks|Recovery|Installer|Mail)\s*([0-9a-zA-Z\.\s]*)\s*$`)
-
-var elCapitanMajor *version.Version
-var sierraMajor *version.Version
-var highSierraMajor *version.Version
-
-const (
-	version1011 = "10.11.0"
-	version1012 = "10.12.0"
-	version1013 = "10.13.0"
+var TitleRegex = regexp.MustCompile(`(?ms)\s*"\s*(SU_TITLE)\s*"\s*=\s*"\s*(macOS|OS X)(\s[0-9a-zA-Z\.\s]+)\s*"\s*;$`)
+var SecurityUpdateTitleRegex = regexp.MustCompile(`(?ms)\s*"\s*SU_TITLE\s*"\s*=\s*"\s*(Security Update\s[0-9a-zA-Z\.\-\s\(\)]+)\s*"\s*;$`)
+var DiscardRegex = regexp.MustCompile(`(?ms)\s*([0-9a-zA-Z\.\s]*)\s*(Mavericks|Recovery|Mail)\s*([0-9a-zA-Z\.\s]*)\s*$`)
+var InstallerRegex = regexp.MustCompile(`(?ms)\bInstaller\b`)
+
+type MacScraper struct {
+	name         string
+	catalogs     []Catalog
+	releaseLines *ReleaseLineTable
+	versionsInfo *VersionsInfo
+	cache        *RequestCache              // URL --> validators and parsed body from its last 200
+	products     map[string]*ProductRecord  // Product key --> what we learned about it
+	snapshots    map[string]CatalogSnapshot // Catalog name --> products as of its last scrape
+	changes      []ChangeEvent              // Changes since the tracker last asked for them
+	fetchOptions FetchOptions
+	limiter      *RateLimiter
+	fetcher      Fetcher
+	mtx          sync.RWMutex
+}
 
-	versionNameElCapitan  = "ElCapitan"
-	versionNameSierra     = "Sierra"
-	versionNameHighSierra = "HighSierra"
-)
+/**
+ * Retrieves and parses the distribution at the distribution URL.
+ * If the server says it hasn't changed since we last fetched it, the cached parse is returned.
+ */
+func (s *MacScraper) getDistribution(ctx context.Context, distributionURL string) (*Distribution, error) {
+	// Only make the request conditional if we have something to fall back on
+	var validator *Validator
+	cached, ok := s.cache.Get(distributionURL)
+	if ok && cached.Distribution != nil && !s.unconditional() {
+		validator = &cached.Validator
+	}
 
-func init() {
-	var err error
-	elCapitanMajor, err = version.NewVersion(version1011)
+	// Request the distribution info
+	resp, err := s.fetch(ctx, distributionURL, validator)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"err":     err,
-			"version": version1011,
-		}).Error("Could not parse static version")
-		panic("Error parsing static version")
+			"timestamp":       time.Now().UnixNano(),
+			"distributionURL": distributionURL,
+			"err":             err,
+		}).Error("Error making request")
+		distributionFetches.WithLabelValues(fetchResultError).Inc()
+		return nil, err
 	}
 
-	sierraMajor, err = version.NewVersion(version1012)
-	if err != nil {
+	if resp.StatusCode == http.StatusNotModified && validator != nil {
+		distributionFetches.WithLabelValues(fetchResultNotModified).Inc()
+		notModified.WithLabelValues(parseKindDistribution).Inc()
 		log.WithFields(log.Fields{
-			"err":     err,
-			"version": version1012,
-		}).Error("Could not parse static version")
-		panic("Error parsing static version")
+			"timestamp":       time.Now().UnixNano(),
+			"distributionURL": distributionURL,
+			"last_modified":   validator.LastModified,
+			"etag":            validator.ETag,
+		}).Debug("Distribution URL has not been updated since we last pulled it; using cached distribution.")
+		return cached.Distribution, nil
 	}
 
-	highSierraMajor, err = version.NewVersion(version1013)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"err":     err,
-			"version": version1013,
-		}).Error("Could not parse static version")
-		panic("Error parsing static version")
+	if resp.StatusCode != http.StatusOK {
+		distributionFetches.WithLabelValues(fetchResultError).Inc()
+		return nil, fmt.Errorf("Unexpected status %d fetching distribution", resp.StatusCode)
 	}
-}
+	distributionFetches.WithLabelValues(fetchResultOK).Inc()
 
-/**
- * Retrieves the latest version from the distribution URL
- */
-func (t *Tracker) getLatestVersion(distributionURL string, lastModified time.Time) (string, error) {
-	// Request the distribution info
-	resp, err := t.makeRequest(distributionURL, lastModified)
-	if err != nil {
+	body := resp.Body
+	dist, err := ParseDistribution(body)
+	// Security updates are named in their title, so they don't need a version,
+	// and full installers can fall back on the catalog's ProductVersion
+	if err != nil || (dist.Version == "" && dist.ProductType != ProductTypeSecurityUpdate && dist.ProductType != ProductTypeFullInstaller) {
 		log.WithFields(log.Fields{
 			"timestamp":       time.Now().UnixNano(),
 			"distributionURL": distributionURL,
 			"err":             err,
-		}).Error("Error making request")
-		return "", err
-	}
+		}).Debug("Could not parse distribution; falling back to regexes")
 
-	if resp.StatusCode != 200 {
-		log.WithFields(log.Fields{
-			"timestamp":     time.Now().Format("Mon, 2 Jan 2006 15:04:05 GMT"),
-			"last_modified": lastModified.Format("Mon, 2 Jan 2006 15:04:05 GMT"),
-			"status_code":   resp.StatusCode,
-		}).Debug("Distribution URL has not been updated since we last pulled it; short-circuiting.")
-		return "", nil
+		dist, err = getDistributionFromRegexes(body)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	body, err := ioutil.ReadAll(io.Reader(resp.Body))
-	if err != nil {
-		log.WithFields(log.Fields{
-			"timestamp": time.Now().UnixNano(),
-			"err":       err,
-		}).Error("Error reading response")
-		return "", err
+	entry := newCacheEntry(resp.Header)
+	entry.Distribution = dist
+	s.cache.Put(distributionURL, entry)
+
+	return dist, nil
+}
+
+/**
+ * Pulls the title and version out of a distribution with regexes, for distributions the XML parser can't handle
+ */
+func getDistributionFromRegexes(body []byte) (*Distribution, error) {
+	if securityMatch := SecurityUpdateTitleRegex.FindStringSubmatch(string(body)); len(securityMatch) == 2 {
+		dist := &Distribution{
+			Title:       strings.TrimSpace(securityMatch[1]),
+			ProductType: ProductTypeSecurityUpdate,
+		}
+		if matches := VersionRegex.FindStringSubmatch(string(body)); len(matches) == 3 {
+			dist.Version = strings.TrimSpace(matches[2])
+		}
+		return dist, nil
 	}
 
 	titleMatch := TitleRegex.FindStringSubmatch(string(body))
@@ -116,9 +128,19 @@ func (t *Tracker) getLatestVersion(distributionURL string, lastModified time.Tim
 		log.WithFields(log.Fields{
 			"timestamp":  time.Now().UnixNano(),
 			"titleMatch": titleMatch,
-			"err":        err,
 		}).Debug("Was not a macOS version")
-		return "", nil
+		return &Distribution{ProductType: ProductTypeOther}, nil
+	}
+
+	title := strings.TrimSpace(titleMatch[2] + titleMatch[3])
+
+	// Full installers are tracked separately, and don't always have a version in their strings
+	if InstallerRegex.MatchString(titleMatch[3]) {
+		dist := &Distribution{Title: title, ProductType: ProductTypeFullInstaller}
+		if matches := VersionRegex.FindStringSubmatch(string(body)); len(matches) == 3 {
+			dist.Version = strings.TrimSpace(matches[2])
+		}
+		return dist, nil
 	}
 
 	discardMatch := DiscardRegex.FindStringSubmatch(titleMatch[3])
@@ -126,9 +148,8 @@ func (t *Tracker) getLatestVersion(distributionURL string, lastModified time.Tim
 		log.WithFields(log.Fields{
 			"timestamp":    time.Now().UnixNano(),
 			"discardMatch": discardMatch,
-			"err":          err,
 		}).Debug("Was not a macOS version")
-		return "", nil
+		return &Distribution{Title: title, ProductType: ProductTypeOther}, nil
 	}
 
 	// Pull out the version
@@ -137,133 +158,344 @@ func (t *Tracker) getLatestVersion(distributionURL string, lastModified time.Tim
 		log.WithFields(log.Fields{
 			"timestamp": time.Now().UnixNano(),
 			"matches":   matches,
-			"err":       err,
 		}).Error("Error finding latest version")
-		return "", errors.New("Could not find version in distribution")
+		parseFailures.WithLabelValues(parseKindDistribution).Inc()
+		return nil, errors.New("Could not find version in distribution")
 	}
 
-	return matches[2], nil
+	return &Distribution{
+		Title:       title,
+		Version:     strings.TrimSpace(matches[2]),
+		ProductType: ProductTypeMacOSUpdate,
+	}, nil
 }
 
 /**
- * Update the version info from the product map info.
- * Returns true if it was updated, false otherwise.
+ * Looks up the build in a product's server metadata, for distributions that don't carry one
+ */
+func (s *MacScraper) getServerMetadataBuild(ctx context.Context, serverMetadataURL string) string {
+	resp, err := s.fetch(ctx, serverMetadataURL, nil)
+	if err != nil {
+		return ""
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	metadata, err := ParseServerMetadata(resp.Body)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"timestamp":         time.Now().UnixNano(),
+			"serverMetadataURL": serverMetadataURL,
+			"err":               err,
+		}).Debug("Could not parse server metadata")
+		parseFailures.WithLabelValues(parseKindServerMetadata).Inc()
+		return ""
+	}
+
+	if BuildRegex.MatchString(metadata.CFBundleVersion) {
+		return metadata.CFBundleVersion
+	}
+	return ""
+}
+
+/**
+ * Adds a release to the history, or bumps its last-seen time if we already know it.
+ * Must be called with s.mtx held.
  */
-func (t *Tracker) updateOSVersionsMapFromProductMap(productCatalogInterface interface{}, versionsInfo *VersionsInfo, lastModified time.Time) (bool, error) {
-	productCatalogMap := productCatalogInterface.(map[string]interface{})
+func (s *MacScraper) recordProduct(key string, kind string, line string, ver string, dist *Distribution, distributionURL string, postDate time.Time, catalog Catalog) *ProductRecord {
+	now := time.Now()
 
-	productsMap, ok := productCatalogMap["Products"].(map[string]interface{})
+	id := productRecordID(key, ver, dist.Build)
+	product, ok := s.products[id]
 	if !ok {
-		return false, errors.New("Could not parse products")
+		product = &ProductRecord{
+			Key:             key,
+			Kind:            kind,
+			OSType:          OSTypeMac,
+			Line:            line,
+			Version:         ver,
+			Build:           dist.Build,
+			Title:           dist.Title,
+			DistributionURL: distributionURL,
+			Channel:         catalog.Channel,
+			FirstSeen:       now,
+		}
+		s.products[id] = product
 	}
 
-	changed := false
-	for key, product := range productsMap {
-		productInfo, ok := product.(map[string]interface{})
+	if !postDate.IsZero() {
+		product.PostDate = postDate
+	}
+
+	// Seed catalogs also carry GA products; a product counts as GA once any release catalog lists it
+	if catalog.Channel == ChannelRelease {
+		product.Channel = ChannelRelease
+		product.Line = line
+	}
+
+	if !containsString(product.Catalogs, catalog.Name) {
+		product.Catalogs = append(product.Catalogs, catalog.Name)
+	}
+
+	product.LastSeen = now
+
+	return product
+}
+
+/**
+ * Queues a change event for the tracker to pick up, filling in the fields common to every event.
+ * Must be called with s.mtx held.
+ */
+func (s *MacScraper) recordChange(event ChangeEvent, catalog Catalog) {
+	event.OSType = OSTypeMac
+	event.Source = s.name
+	event.Catalog = catalog.Name
+	event.Channel = catalog.Channel
+	event.DetectedAt = time.Now()
+
+	s.changes = append(s.changes, event)
+}
+
+func (s *MacScraper) Changes() []ChangeEvent {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	changes := s.changes
+	s.changes = nil
+	return changes
+}
+
+/**
+ * What a scrape found in a single catalog, before any of it is applied to the versions info
+ */
+type catalogFetch struct {
+	catalog   Catalog
+	suCatalog *SUCatalog // nil if the catalog hasn't changed since we last processed it
+	products  []*productFetch
+	err       error
+}
+
+/**
+ * A product from a catalog along with its parsed distribution
+ */
+type productFetch struct {
+	key             string
+	product         *Product
+	distributionURL string
+	dist            *Distribution
+	err             error
+}
+
+/**
+ * Diffs the catalog against its last snapshot and lists the products to apply, in key order.
+ * Products that haven't been re-posted keep the distribution we already have for them
+ * (unless fetches are unconditional); the rest are left for fetchDistributions.
+ */
+func (s *MacScraper) planProducts(fetch *catalogFetch) {
+	s.mtx.RLock()
+	previous := s.snapshots[fetch.catalog.Name]
+	reuse := !s.fetchOptions.Unconditional
+	s.mtx.RUnlock()
+
+	changedKeys, unchangedKeys, removed := previous.Diff(fetch.suCatalog)
+	catalogProducts.WithLabelValues(fetch.catalog.Name, productStateChanged).Add(float64(len(changedKeys)))
+	catalogProducts.WithLabelValues(fetch.catalog.Name, productStateUnchanged).Add(float64(len(unchangedKeys)))
+	log.WithFields(log.Fields{
+		"timestamp": time.Now().UnixNano(),
+		"catalog":   fetch.catalog.Name,
+		"changed":   len(changedKeys),
+		"unchanged": len(unchangedKeys),
+		"removed":   removed,
+	}).Debug("Diffed catalog against last snapshot")
+
+	unchanged := make(map[string]bool, len(unchangedKeys))
+	for _, key := range unchangedKeys {
+		unchanged[key] = true
+	}
+
+	keys := append(changedKeys, unchangedKeys...)
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		product := fetch.suCatalog.Products[key]
+
+		englishDistribution, ok := product.Distributions.English()
 		if !ok {
 			continue
 		}
-		distributions, ok := productInfo["Distributions"].(map[string]interface{})
-		if !ok {
-			continue
+
+		productFetch := &productFetch{
+			key:             key,
+			product:         product,
+			distributionURL: englishDistribution,
+		}
+		if cached, ok := s.cache.Get(englishDistribution); ok && reuse && unchanged[key] && cached.Distribution != nil {
+			productFetch.dist = cached.Distribution
 		}
 
-		englishDistribution, ok := distributions["English"].(string)
-		if !ok {
-			continue
+		fetch.products = append(fetch.products, productFetch)
+	}
+}
+
+/**
+ * Fetches every distribution the catalogs still need, using the worker pool.
+ * Catalogs share a lot of products, so each URL is only fetched once per scrape.
+ */
+func (s *MacScraper) fetchDistributions(ctx context.Context, fetches []*catalogFetch, workers int) {
+	pending := make(map[string][]*productFetch)
+	urls := []string{}
+	for _, fetch := range fetches {
+		for _, product := range fetch.products {
+			if product.dist != nil {
+				continue
+			}
+			if _, ok := pending[product.distributionURL]; !ok {
+				urls = append(urls, product.distributionURL)
+			}
+			pending[product.distributionURL] = append(pending[product.distributionURL], product)
 		}
+	}
 
-		ver, err := t.getLatestVersion(englishDistribution, lastModified)
-		if err != nil {
+	runPool(len(urls), workers, func(i int) {
+		products := pending[urls[i]]
+
+		dist, err := s.getDistribution(ctx, urls[i])
+		if err == nil && dist.ProductType == ProductTypeMacOSUpdate && dist.Build == "" {
+			if serverMetadataURL := products[0].product.ServerMetadataURL; serverMetadataURL != "" {
+				// The distribution belongs to the cache, so fill in the build on a copy and put that back
+				withBuild := *dist
+				withBuild.Build = s.getServerMetadataBuild(ctx, serverMetadataURL)
+				dist = &withBuild
+				s.cache.SetDistribution(urls[i], dist)
+			}
+		}
+
+		for _, product := range products {
+			product.dist, product.err = dist, err
+		}
+	})
+}
+
+/**
+ * Applies a catalog's products to the version info, in key order.
+ * Returns true if it was updated, false otherwise.
+ */
+func (s *MacScraper) applyProducts(fetch *catalogFetch) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	catalog := fetch.catalog
+	versionsInfo := s.versionsInfo
+
+	changed := false
+	for _, productFetch := range fetch.products {
+		key := productFetch.key
+		dist := productFetch.dist
+		if productFetch.err != nil {
 			log.WithFields(log.Fields{
-				"err": err,
-				"englishDistributionURL": englishDistribution,
-				"key": key,
+				"err":                    productFetch.err,
+				"englishDistributionURL": productFetch.distributionURL,
+				"key":                    key,
 			}).Info("Failed to get version info")
 			continue
 		}
 
-		if ver == "" {
+		if dist == nil {
 			continue
 		}
 
+		// Only the catalog can say for sure that a product is a full installer: their distributions
+		// look like updates, and plenty of other products have "Install" in their title
+		if IsFullInstallerProduct(productFetch.product) {
+			if s.applyFullInstaller(productFetch, catalog) {
+				changed = true
+			}
+			continue
+		}
+
+		if dist.ProductType == ProductTypeSecurityUpdate {
+			if s.applySecurityUpdate(productFetch, catalog) {
+				changed = true
+			}
+			continue
+		}
+
+		if dist.ProductType != ProductTypeMacOSUpdate {
+			log.WithFields(log.Fields{
+				"timestamp":    time.Now().UnixNano(),
+				"title":        dist.Title,
+				"product_type": dist.ProductType,
+			}).Debug("Was not a macOS version")
+			continue
+		}
+
+		ver := dist.Version
 		v1, err := version.NewVersion(ver)
 		if err != nil {
 			log.WithFields(log.Fields{
-				"err": err,
-				"englishDistributionURL": englishDistribution,
-				"key":     key,
-				"version": ver,
+				"err":                    err,
+				"englishDistributionURL": productFetch.distributionURL,
+				"key":                    key,
+				"version":                ver,
 			}).Error("Could not parse version")
+			parseFailures.WithLabelValues(parseKindVersion).Inc()
 			continue
 		}
 
-		//The oldest major version we support
-		if v1.LessThan(elCapitanMajor) {
+		releaseLine, ok := s.releaseLines.Line(v1)
+		if !ok {
 			log.WithFields(log.Fields{
-				"err": err,
-				"englishDistributionURL": englishDistribution,
-				"key":     key,
-				"version": ver,
+				"englishDistributionURL": productFetch.distributionURL,
+				"key":                    key,
+				"version":                ver,
 			}).Debug("Not tracked version")
 			continue
 		}
 
-		if v1.GreaterThan(highSierraMajor) {
-			t.mtx.Lock()
-			latestHighSierraVersion, ok := versionsInfo.LatestVersions[versionNameHighSierra]
-			if !ok || v1.GreaterThan(latestHighSierraVersion) {
-				versionsInfo.LatestVersions[versionNameHighSierra] = v1
-				versionsInfo.LastModified = time.Now()
-				changed = true
-			}
-			t.mtx.Unlock()
-		} else if v1.GreaterThan(sierraMajor) {
-			t.mtx.Lock()
-			latestSierraVersion, ok := versionsInfo.LatestVersions[versionNameSierra]
-			if !ok || v1.GreaterThan(latestSierraVersion) {
-
-				versionsInfo.LatestVersions[versionNameSierra] = v1
-				versionsInfo.LastModified = time.Now()
-				changed = true
+		line := channelLine(releaseLine, catalog.Channel)
+
+		latestVersion, latestBuild := versionsInfo.LatestVersions[line], versionsInfo.LatestBuilds[line]
+		if versionsInfo.Update(line, v1, dist.Build) {
+			event := ChangeEvent{
+				Kind:       changeKind(v1, dist, latestVersion),
+				Line:       line,
+				OldBuild:   latestBuild,
+				NewVersion: v1.String(),
+				NewBuild:   dist.Build,
+				Title:      dist.Title,
+				ProductKey: key,
 			}
-			t.mtx.Unlock()
-		} else if v1.GreaterThan(elCapitanMajor) {
-			t.mtx.Lock()
-			latestElCapitanVersion, ok := versionsInfo.LatestVersions[versionNameElCapitan]
-			if !ok || v1.GreaterThan(latestElCapitanVersion) {
-				versionsInfo.LatestVersions[versionNameElCapitan] = v1
-				versionsInfo.LastModified = time.Now()
-				changed = true
+			if latestVersion != nil {
+				event.OldVersion = latestVersion.String()
 			}
-			t.mtx.Unlock()
+			s.recordChange(event, catalog)
+
+			versionsInfo.LastModified = time.Now()
+			changed = true
 		}
+		s.recordProduct(key, ReleaseKindUpdate, line, v1.String(), dist, productFetch.distributionURL, productFetch.product.PostDate, catalog)
 	}
 
-	return changed, nil
+	s.snapshots[catalog.Name] = MakeCatalogSnapshot(fetch.suCatalog)
+
+	return changed
 }
 
 /**
- * Parses a response from the catalog URL into a ProductMap
+ * Parses a response from the catalog URL into an SUCatalog
  */
-func (t *Tracker) parseCatalogResponse(resp *http.Response) (interface{}, error) {
-	body, err := ioutil.ReadAll(io.Reader(resp.Body))
-	if err != nil {
-		log.WithFields(log.Fields{
-			"timestamp": time.Now().UnixNano(),
-			"err":       err,
-		}).Error("Error reading response")
-		return nil, err
-	}
-
-	var parsedCatalog interface{}
-	_, err = plist.Unmarshal(body, &parsedCatalog)
+func (s *MacScraper) parseCatalogResponse(resp *FetchResponse) (*SUCatalog, error) {
+	body := resp.Body
+	parsedCatalog, err := ParseSUCatalog(body)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"timestamp": time.Now().UnixNano(),
 			"err":       err,
 			"body":      string(body),
 		}).Error("Error unmarshalling response")
+		parseFailures.WithLabelValues(parseKindCatalog).Inc()
 		return nil, err
 	}
 
@@ -271,97 +503,393 @@ func (t *Tracker) parseCatalogResponse(resp *http.Response) (interface{}, error)
 }
 
 /**
- * Attempts to request/parse info from the product catalog
- * Returns true if the it successfully updates the osVersionsMap, false otherwise
+ * Attempts to request/parse the product catalog.
+ * Returns a nil catalog if it hasn't changed since we last processed it.
  */
-func (t *Tracker) updateOSVersionsMap(url string) (bool, error) {
-	// Look up the most recent version info
-	var lastModified time.Time
-	versionsInfo, ok := t.osVersionsMap[OSTypeMac]
-	if !ok {
-		lastModified = time.Time{}
-	} else {
-		lastModified = versionsInfo.LastModified
-	}
-
-	if versionsInfo.LatestVersions == nil {
-		versionsInfo.LatestVersions = make(map[string]*version.Version)
+func (s *MacScraper) fetchCatalog(ctx context.Context, catalog Catalog) (*SUCatalog, error) {
+	url := catalog.URL
+
+	// Only make the request conditional if we still have the parsed catalog in memory
+	var validator *Validator
+	cached, ok := s.cache.Get(url)
+	if ok && cached.catalog != nil && !s.unconditional() {
+		validator = &cached.Validator
 	}
 
 	// Request product info from the catalog
-	resp, err := t.makeRequest(url, lastModified)
+	resp, err := s.fetch(ctx, url, validator)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"timestamp": time.Now().UnixNano(),
 			"err":       err,
 		}).Error("Error making request")
-		return false, err
+		catalogFetches.WithLabelValues(catalog.Name, fetchResultError).Inc()
+		return nil, err
 	}
 
-	// Short-circuit if we don't get a 200 (most likely means the catalog has not been updated lately)
-	if resp.StatusCode != 200 {
+	// Short-circuit on a 304; the cached catalog has already been processed
+	if resp.StatusCode == http.StatusNotModified && validator != nil {
+		catalogFetches.WithLabelValues(catalog.Name, fetchResultNotModified).Inc()
+		notModified.WithLabelValues(parseKindCatalog).Inc()
 		log.WithFields(log.Fields{
-			"timestamp":     time.Now().Format("Mon, 2 Jan 2006 15:04:05 GMT"),
-			"last_modified": lastModified.Format("Mon, 2 Jan 2006 15:04:05 GMT"),
-			"status_code":   resp.StatusCode,
+			"timestamp":     time.Now().UnixNano(),
+			"catalog":       catalog.Name,
+			"last_modified": validator.LastModified,
+			"etag":          validator.ETag,
 		}).Debug("Catalog has not been updated since we last pulled it; short-circuiting.")
-		return false, nil
+		return nil, nil
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		catalogFetches.WithLabelValues(catalog.Name, fetchResultError).Inc()
+		return nil, fmt.Errorf("Unexpected status %d fetching catalog", resp.StatusCode)
+	}
+	catalogFetches.WithLabelValues(catalog.Name, fetchResultOK).Inc()
+
 	// Parse response into product info
-	productMap, err := t.parseCatalogResponse(resp)
+	suCatalog, err := s.parseCatalogResponse(resp)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"timestamp": time.Now().UnixNano(),
 			"err":       err,
 		}).Error("Error parsing response")
-		return false, err
+		return nil, err
 	}
 
-	return t.updateOSVersionsMapFromProductMap(productMap, versionsInfo, lastModified)
+	entry := newCacheEntry(resp.Header)
+	entry.catalog = suCatalog
+	s.cache.Put(url, entry)
+
+	return suCatalog, nil
+}
+
+func (s *MacScraper) Name() string {
+	return s.name
+}
+
+func (s *MacScraper) OSType() string {
+	return OSTypeMac
 }
 
 /**
- * Scrape the mac catalog and possibly update the osVersionsMap
+ * Scrapes every configured catalog. Catalogs and distributions are fetched concurrently by
+ * the worker pool, then applied one catalog at a time in configuration order so the result
+ * doesn't depend on which request finished first.
+ * Returns a copy of the versions found, along with the first catalog error hit, if any.
  */
-func (t *Tracker) scrapeForMacVersions(url string) error {
-	updated, err := t.updateOSVersionsMap(url)
-	if err != nil {
+func (s *MacScraper) Scrape(ctx context.Context) (*VersionsInfo, error) {
+	s.mtx.RLock()
+	catalogs := s.catalogs
+	workers := s.fetchOptions.Workers
+	s.mtx.RUnlock()
+
+	fetches := make([]*catalogFetch, len(catalogs))
+	runPool(len(catalogs), workers, func(i int) {
+		fetch := &catalogFetch{catalog: catalogs[i]}
+		fetch.suCatalog, fetch.err = s.fetchCatalog(ctx, catalogs[i])
+		if fetch.suCatalog != nil {
+			s.planProducts(fetch)
+		}
+		fetches[i] = fetch
+	})
+
+	s.fetchDistributions(ctx, fetches, workers)
+
+	var firstErr error
+	for _, fetch := range fetches {
+		if fetch.err != nil {
+			log.WithFields(log.Fields{
+				"timestamp": time.Now().UnixNano(),
+				"catalog":   fetch.catalog.Name,
+				"err":       fetch.err,
+			}).Error("Error updating versions")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", fetch.catalog.Name, fetch.err)
+			}
+			continue
+		}
+
+		if fetch.suCatalog == nil {
+			continue
+		}
+
+		updated := s.applyProducts(fetch)
+
+		s.mtx.RLock()
+		fields := log.Fields{
+			"timestamp":       time.Now().UnixNano(),
+			"catalog":         fetch.catalog.Name,
+			"latest_versions": s.versionsInfo.LatestVersions,
+			"modified_at":     s.versionsInfo.LastModified,
+		}
+		s.mtx.RUnlock()
+
+		if updated {
+			log.WithFields(fields).Info("Updated version map")
+		} else {
+			log.WithFields(fields).Debug("Did not update version map")
+		}
+	}
+
+	if firstErr == nil {
+		s.pruneCache(fetches)
+	}
+
+	s.mtx.RLock()
+	versionsInfo := s.versionsInfo.Copy()
+	s.mtx.RUnlock()
+
+	return versionsInfo, firstErr
+}
+
+/**
+ * Drops cached catalogs and distributions that no configured catalog lists any more.
+ * Catalogs that returned a 304 are read from the cache; if one of them isn't there we can't tell
+ * what it still lists, so nothing is dropped.
+ */
+func (s *MacScraper) pruneCache(fetches []*catalogFetch) {
+	keep := make(map[string]bool)
+	for _, fetch := range fetches {
+		suCatalog := fetch.suCatalog
+		if suCatalog == nil {
+			cached, ok := s.cache.Get(fetch.catalog.URL)
+			if !ok || cached.catalog == nil {
+				return
+			}
+			suCatalog = cached.catalog
+		}
+
+		keep[fetch.catalog.URL] = true
+		for _, product := range suCatalog.Products {
+			if product == nil {
+				continue
+			}
+			if englishDistribution, ok := product.Distributions.English(); ok {
+				keep[englishDistribution] = true
+			}
+		}
+	}
+
+	pruned := s.cache.Prune(keep)
+	if pruned > 0 {
 		log.WithFields(log.Fields{
 			"timestamp": time.Now().UnixNano(),
-			"err":       err,
-		}).Error("Error updating versions")
+			"pruned":    pruned,
+		}).Debug("Dropped cache entries no catalog lists any more")
+	}
+}
+
+func (s *MacScraper) SaveState() *SourceState {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	products := make(map[string]*ProductRecord, len(s.products))
+	for id, product := range s.products {
+		productCopy := *product
+		productCopy.Catalogs = append([]string(nil), product.Catalogs...)
+		products[id] = &productCopy
+	}
+
+	snapshots := make(map[string]CatalogSnapshot, len(s.snapshots))
+	for name, snapshot := range s.snapshots {
+		snapshots[name] = snapshot
+	}
+
+	return &SourceState{
+		Versions:   s.versionsInfo.Copy(),
+		Validators: s.cache.Entries(),
+		Products:   products,
+		Snapshots:  snapshots,
+	}
+}
+
+func (s *MacScraper) LoadState(state *SourceState) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if state.Versions != nil {
+		s.versionsInfo = state.Versions.Copy()
+	}
+	if state.Validators != nil {
+		s.cache.Load(state.Validators)
+	}
+	if state.Products != nil {
+		// Older state files were keyed by product key alone
+		s.products = make(map[string]*ProductRecord, len(state.Products))
+		for _, product := range state.Products {
+			if product != nil {
+				s.products[productRecordID(product.Key, product.Version, product.Build)] = product
+			}
+		}
+	}
+	if state.Snapshots != nil {
+		s.snapshots = state.Snapshots
+	}
+}
+
+/**
+ * Returns a copy of every release we have seen, in no particular order
+ */
+func (s *MacScraper) History() []ProductRecord {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	history := make([]ProductRecord, 0, len(s.products))
+	for _, product := range s.products {
+		productCopy := *product
+		productCopy.Catalogs = append([]string(nil), product.Catalogs...)
+		history = append(history, productCopy)
+	}
+	return history
+}
+
+/**
+ * Returns the GA release line a version would be filed under
+ */
+func (s *MacScraper) ReleaseLine(ver *version.Version) (string, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.releaseLines.Line(ver)
+}
+
+/**
+ * Replaces the table used to bucket versions into release lines
+ */
+func (s *MacScraper) SetReleaseLines(releaseLines *ReleaseLineTable) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.releaseLines = releaseLines
+}
+
+/**
+ * Replaces the catalogs scraped from the next scrape on. Versions already found are kept;
+ * the snapshots of catalogs that were dropped or now point somewhere else are forgotten.
+ */
+func (s *MacScraper) SetCatalogs(catalogs []Catalog) error {
+	err := ValidateCatalogs(catalogs)
+	if err != nil {
 		return err
 	}
 
-	if updated {
-		log.WithFields(log.Fields{
-			"timestamp":       time.Now().UnixNano(),
-			"latest_versions": t.osVersionsMap[OSTypeMac].LatestVersions,
-			"modified_at":     t.osVersionsMap[OSTypeMac].LastModified,
-		}).Info("Updated version map")
-	} else {
-		log.WithFields(log.Fields{
-			"timestamp":       time.Now().UnixNano(),
-			"latest_versions": t.osVersionsMap[OSTypeMac].LatestVersions,
-			"modified_at":     t.osVersionsMap[OSTypeMac].LastModified,
-		}).Debug("Did not update version map")
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	urls := make(map[string]string, len(catalogs))
+	for _, catalog := range catalogs {
+		urls[catalog.Name] = catalog.URL
+	}
+	for _, catalog := range s.catalogs {
+		if urls[catalog.Name] != catalog.URL {
+			delete(s.snapshots, catalog.Name)
+		}
 	}
 
+	s.catalogs = catalogs
 	return nil
 }
 
-func (t *Tracker) ScrapeForMacVersions() {
-	wg := sync.WaitGroup{}
+/**
+ * Sets how many fetches run at once and how fast they may be made
+ */
+func (s *MacScraper) SetFetchOptions(fetchOptions FetchOptions) error {
+	err := fetchOptions.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
 
-	for _, url := range MacCatalogs {
-		wg.Add(1)
+	s.fetchOptions = fetchOptions
+	s.limiter = NewRateLimiter(fetchOptions.GlobalRate, fetchOptions.HostRate, fetchOptions.Burst)
+	return nil
+}
 
-		go func(url string) {
-			defer wg.Done()
-			t.scrapeForMacVersions(fmt.Sprintf("%s%s", catalogURL, url))
-		}(url)
+/**
+ * Replaces the fetcher used for every catalog, distribution and server metadata request
+ */
+func (s *MacScraper) SetFetcher(fetcher Fetcher) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.fetcher = fetcher
+}
+
+func (s *MacScraper) httpFetcher() Fetcher {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.fetcher
+}
+
+/**
+ * Fetches the URL under the scraper's rate limits; the fetcher waits on them before each attempt
+ */
+func (s *MacScraper) fetch(ctx context.Context, url string, validator *Validator) (*FetchResponse, error) {
+	return s.httpFetcher().Fetch(WithRateLimiter(ctx, s.rateLimiter()), url, validator)
+}
+
+func (s *MacScraper) unconditional() bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.fetchOptions.Unconditional
+}
+
+func (s *MacScraper) rateLimiter() *RateLimiter {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.limiter
+}
+
+func NewMacScraper(catalogs []Catalog) (*MacScraper, error) {
+	err := ValidateCatalogs(catalogs)
+	if err != nil {
+		return nil, err
 	}
 
-	wg.Wait()
+	fetcher, err := NewHTTPFetcher(DefaultHTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MacScraper{
+		name:         MacScraperName,
+		catalogs:     catalogs,
+		releaseLines: defaultMacReleaseLines,
+		versionsInfo: MakeVersionsInfo(),
+		cache:        MakeRequestCache(),
+		snapshots:    make(map[string]CatalogSnapshot),
+		fetchOptions: DefaultFetchOptions,
+		limiter:      NewRateLimiter(DefaultFetchOptions.GlobalRate, DefaultFetchOptions.HostRate, DefaultFetchOptions.Burst),
+		fetcher:      fetcher,
+		products:     make(map[string]*ProductRecord),
+		mtx:          sync.RWMutex{},
+	}, nil
+}
+
+/**
+ * Works out why (ver, dist.Build) replaced the previous latest version of a line
+ */
+func changeKind(ver *version.Version, dist *Distribution, previous *version.Version) string {
+	if previous == nil || !ver.Equal(previous) {
+		return ChangeKindVersion
+	}
+
+	if strings.Contains(dist.Title, "Supplemental") {
+		return ChangeKindSupplemental
+	}
+	return ChangeKindBuild
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,165 @@
+package tracker
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+/**
+ * TokenBucket allows rate events per second on average, with bursts of up to burst events.
+ * A nil bucket never blocks.
+ */
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time // time.Now, except in tests
+	mtx    sync.Mutex
+}
+
+/**
+ * Returns a bucket that starts full. A rate <= 0 means unlimited, which is represented by nil.
+ */
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+/**
+ * Takes a token and returns how long the caller has to wait before it may use it
+ */
+func (b *TokenBucket) reserve() time.Duration {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+/**
+ * Blocks until a token is available or the context is done
+ */
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	delay := b.reserve()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/**
+ * RateLimiter applies a global token bucket plus one bucket per host
+ */
+type RateLimiter struct {
+	global    *TokenBucket
+	hostRate  float64
+	hostBurst int
+	hosts     map[string]*TokenBucket
+	mtx       sync.Mutex
+}
+
+/**
+ * Rates are in requests per second; a rate <= 0 disables that limit
+ */
+func NewRateLimiter(globalRate float64, hostRate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		global:    NewTokenBucket(globalRate, burst),
+		hostRate:  hostRate,
+		hostBurst: burst,
+		hosts:     make(map[string]*TokenBucket),
+	}
+}
+
+func (l *RateLimiter) hostBucket(rawURL string) *TokenBucket {
+	if l.hostRate <= 0 {
+		return nil
+	}
+
+	host := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	bucket, ok := l.hosts[host]
+	if !ok {
+		bucket = NewTokenBucket(l.hostRate, l.hostBurst)
+		l.hosts[host] = bucket
+	}
+	return bucket
+}
+
+/**
+ * Blocks until a request to the URL is allowed by both the per-host and the global limit
+ */
+func (l *RateLimiter) Wait(ctx context.Context, rawURL string) error {
+	if l == nil {
+		return nil
+	}
+
+	err := l.hostBucket(rawURL).Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	return l.global.Wait(ctx)
+}
+
+type rateLimiterKey struct{}
+
+/**
+ * Returns a context carrying the limiter. Fetchers wait on it before every attempt, retries included,
+ * so a request that has to be retried still counts against the rates.
+ */
+func WithRateLimiter(ctx context.Context, l *RateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, l)
+}
+
+/**
+ * Returns the limiter carried by the context, or nil (which never blocks) if there isn't one
+ */
+func RateLimiterFrom(ctx context.Context) *RateLimiter {
+	l, _ := ctx.Value(rateLimiterKey{}).(*RateLimiter)
+	return l
+}
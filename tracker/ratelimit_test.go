@@ -0,0 +1,134 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+/**
+ * A clock that only moves when the test says so
+ */
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeBucket(rate float64, burst int) (*TokenBucket, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC)}
+
+	bucket := NewTokenBucket(rate, burst)
+	bucket.now = clock.Now
+	bucket.last = clock.now
+
+	return bucket, clock
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	bucket, _ := newFakeBucket(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if delay := bucket.reserve(); delay != 0 {
+			t.Fatalf("Request %d within the burst waited %v", i+1, delay)
+		}
+	}
+
+	// The next tokens come every half second, and each reservation queues behind the last
+	if delay := bucket.reserve(); delay != 500*time.Millisecond {
+		t.Errorf("First request past the burst waits %v, want 500ms", delay)
+	}
+	if delay := bucket.reserve(); delay != time.Second {
+		t.Errorf("Second request past the burst waits %v, want 1s", delay)
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	bucket, clock := newFakeBucket(2, 3)
+
+	for i := 0; i < 3; i++ {
+		bucket.reserve()
+	}
+
+	clock.Advance(time.Second)
+	for i := 0; i < 2; i++ {
+		if delay := bucket.reserve(); delay != 0 {
+			t.Fatalf("Request %d after refilling 2 tokens waited %v", i+1, delay)
+		}
+	}
+	if delay := bucket.reserve(); delay != 500*time.Millisecond {
+		t.Errorf("Request after the refilled tokens waits %v, want 500ms", delay)
+	}
+
+	// A long idle period only refills up to the burst
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if delay := bucket.reserve(); delay != 0 {
+			t.Fatalf("Request %d after idling waited %v", i+1, delay)
+		}
+	}
+	if delay := bucket.reserve(); delay == 0 {
+		t.Error("Idling let the bucket fill past its burst")
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	bucket := NewTokenBucket(0, 5)
+	if bucket != nil {
+		t.Fatal("A rate of 0 should be unlimited")
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := bucket.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTokenBucketWaitHonoursContext(t *testing.T) {
+	bucket := NewTokenBucket(0.001, 1)
+	bucket.reserve()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := bucket.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait() on a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRateLimiterBucketsPerHost(t *testing.T) {
+	limiter := NewRateLimiter(0, 1, 1)
+
+	a := limiter.hostBucket("https://swscan.apple.com/content/catalogs/index.sucatalog")
+	b := limiter.hostBucket("https://swscan.apple.com/content/downloads/a.dist")
+	c := limiter.hostBucket("https://swdist.apple.com/content/downloads/b.dist")
+
+	if a != b {
+		t.Error("URLs on the same host got different buckets")
+	}
+	if a == c {
+		t.Error("URLs on different hosts share a bucket")
+	}
+
+	if NewRateLimiter(5, 0, 1).hostBucket("https://swscan.apple.com/") != nil {
+		t.Error("A host rate of 0 should be unlimited")
+	}
+}
+
+func TestRateLimiterFromContext(t *testing.T) {
+	if RateLimiterFrom(context.Background()) != nil {
+		t.Error("A bare context carries a rate limiter")
+	}
+
+	limiter := NewRateLimiter(1, 1, 1)
+	if RateLimiterFrom(WithRateLimiter(context.Background(), limiter)) != limiter {
+		t.Error("RateLimiterFrom didn't return the limiter the context was given")
+	}
+}
@@ -0,0 +1,249 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+ * A ReleaseLine maps a version prefix (e.g. "10.13" or "14") to the name we file it under
+ */
+type ReleaseLine struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+}
+
+var DefaultMacReleaseLines = []ReleaseLine{
+	{Prefix: "10.11", Name: "ElCapitan"},
+	{Prefix: "10.12", Name: "Sierra"},
+	{Prefix: "10.13", Name: "HighSierra"},
+	{Prefix: "10.14", Name: "Mojave"},
+	{Prefix: "10.15", Name: "Catalina"},
+	{Prefix: "10.16", Name: "BigSur"},
+	{Prefix: "11", Name: "BigSur"},
+	{Prefix: "12", Name: "Monterey"},
+	{Prefix: "13", Name: "Ventura"},
+	{Prefix: "14", Name: "Sonoma"},
+	{Prefix: "15", Name: "Sequoia"},
+	{Prefix: "26", Name: "Tahoe"},
+}
+
+var defaultMacReleaseLines *ReleaseLineTable
+
+func init() {
+	var err error
+	defaultMacReleaseLines, err = NewReleaseLineTable(DefaultMacReleaseLines)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Could not parse default release lines")
+		panic("Error parsing default release lines")
+	}
+}
+
+type releaseLineEntry struct {
+	ReleaseLine
+	segments []int
+}
+
+/**
+ * ReleaseLineTable buckets versions into release lines.
+ * Versions older than the oldest line in the table are not tracked; newer versions that
+ * have no entry are bucketed generically by major (11+) or major.minor (10.x).
+ */
+type ReleaseLineTable struct {
+	entries []releaseLineEntry // Longest prefix first
+	oldest  []int
+}
+
+func NewReleaseLineTable(lines []ReleaseLine) (*ReleaseLineTable, error) {
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("No release lines given")
+	}
+
+	table := &ReleaseLineTable{}
+	for _, line := range lines {
+		segments, err := parsePrefix(line.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		if line.Name == "" {
+			return nil, fmt.Errorf("Release line %q has no name", line.Prefix)
+		}
+
+		table.entries = append(table.entries, releaseLineEntry{
+			ReleaseLine: line,
+			segments:    segments,
+		})
+
+		if table.oldest == nil || compareSegments(segments, table.oldest) < 0 {
+			table.oldest = segments
+		}
+	}
+
+	sort.SliceStable(table.entries, func(i, j int) bool {
+		return len(table.entries[i].segments) > len(table.entries[j].segments)
+	})
+
+	return table, nil
+}
+
+/**
+ * Returns the release line a version belongs to, or false if it is too old to track
+ */
+func (t *ReleaseLineTable) Line(v *version.Version) (string, bool) {
+	segments := v.Segments()
+
+	for _, entry := range t.entries {
+		if hasPrefix(segments, entry.segments) {
+			return entry.Name, true
+		}
+	}
+
+	if compareSegments(segments, t.oldest) < 0 {
+		return "", false
+	}
+
+	// Nothing in the table yet; bucket by major for 11+ and major.minor for 10.x
+	if segments[0] >= 11 {
+		return strconv.Itoa(segments[0]), true
+	}
+	return fmt.Sprintf("%d.%d", segments[0], segments[1]), true
+}
+
+/**
+ * Returns the release line for a version string, or false if it doesn't parse or is too old to track
+ */
+func (t *ReleaseLineTable) LineFor(ver string) (string, bool) {
+	v, err := version.NewVersion(ver)
+	if err != nil {
+		return "", false
+	}
+	return t.Line(v)
+}
+
+/**
+ * Looks up a line by its marketing name as Apple writes it in titles, e.g. "High Sierra" for HighSierra.
+ * Case and spaces are ignored.
+ */
+func (t *ReleaseLineTable) LineNamed(name string) (string, bool) {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Replace(s, " ", "", -1))
+	}
+
+	for _, entry := range t.entries {
+		if normalize(entry.Name) == normalize(name) {
+			return entry.Name, true
+		}
+	}
+	return "", false
+}
+
+/**
+ * Returns the lines in the table, oldest first
+ */
+func (t *ReleaseLineTable) Lines() []ReleaseLine {
+	entries := append([]releaseLineEntry(nil), t.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareSegments(entries[i].segments, entries[j].segments) < 0
+	})
+
+	lines := make([]ReleaseLine, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.ReleaseLine)
+	}
+	return lines
+}
+
+/**
+ * Returns base with extra layered on top; an entry in extra replaces any entry in base with the same prefix
+ */
+func MergeReleaseLines(base []ReleaseLine, extra []ReleaseLine) []ReleaseLine {
+	merged := append([]ReleaseLine(nil), base...)
+	for _, line := range extra {
+		replaced := false
+		for i := range merged {
+			if merged[i].Prefix == line.Prefix {
+				merged[i] = line
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, line)
+		}
+	}
+
+	return merged
+}
+
+/**
+ * Reads a JSON list of release lines, e.g. [{"prefix": "16", "name": "NextOS"}]
+ */
+func LoadReleaseLines(path string) ([]ReleaseLine, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []ReleaseLine
+	err = json.Unmarshal(data, &lines)
+	if err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+func parsePrefix(prefix string) ([]int, error) {
+	parts := strings.Split(strings.TrimSpace(prefix), ".")
+	segments := make([]int, 0, len(parts))
+	for _, part := range parts {
+		segment, err := strconv.Atoi(part)
+		if err != nil || segment < 0 {
+			return nil, fmt.Errorf("Invalid release line prefix %q", prefix)
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+func hasPrefix(segments []int, prefix []int) bool {
+	if len(prefix) > len(segments) {
+		return false
+	}
+
+	for i := range prefix {
+		if segments[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func compareSegments(a []int, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
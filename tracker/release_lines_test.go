@@ -0,0 +1,158 @@
+package tracker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+func newReleaseLineTable(t *testing.T, lines []tracker.ReleaseLine) *tracker.ReleaseLineTable {
+	table, err := tracker.NewReleaseLineTable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestReleaseLineTableLineFor(t *testing.T) {
+	table := newReleaseLineTable(t, tracker.DefaultMacReleaseLines)
+
+	tests := []struct {
+		version string
+		line    string
+		ok      bool
+	}{
+		{"10.13.6", "HighSierra", true},
+		{"10.15.7", "Catalina", true},
+		// Big Sur went out as both 10.16 and 11
+		{"10.16", "BigSur", true},
+		{"11.0.1", "BigSur", true},
+		{"11.7.10", "BigSur", true},
+		{"15.2", "Sequoia", true},
+		{"26.0.1", "Tahoe", true},
+		// Unknown majors are bucketed by major from 11 on and by major.minor before that
+		{"10.17.1", "10.17", true},
+		{"16.0", "16", true},
+		{"27.1", "27", true},
+		// Older than anything in the table
+		{"10.10.5", "", false},
+		{"10.9", "", false},
+		{"9.2.2", "", false},
+		{"not a version", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			line, ok := table.LineFor(test.version)
+			if line != test.line || ok != test.ok {
+				t.Errorf("LineFor(%q) = %q, %v, want %q, %v", test.version, line, ok, test.line, test.ok)
+			}
+		})
+	}
+}
+
+func TestMergeReleaseLines(t *testing.T) {
+	defaults := append([]tracker.ReleaseLine(nil), tracker.DefaultMacReleaseLines...)
+
+	merged := tracker.MergeReleaseLines(tracker.DefaultMacReleaseLines, []tracker.ReleaseLine{
+		{Prefix: "15", Name: "SequoiaLTS"},
+		{Prefix: "14.7", Name: "SonomaLate"},
+		{Prefix: "16", Name: "NextOS"},
+	})
+
+	if len(merged) != len(defaults)+2 {
+		t.Errorf("Merged %d lines, want %d: the override should replace 15, not add to it", len(merged), len(defaults)+2)
+	}
+	if !reflect.DeepEqual(tracker.DefaultMacReleaseLines, defaults) {
+		t.Errorf("MergeReleaseLines() modified the base lines: %v", tracker.DefaultMacReleaseLines)
+	}
+
+	table := newReleaseLineTable(t, merged)
+
+	tests := []struct {
+		version string
+		line    string
+	}{
+		{"15.2", "SequoiaLTS"},
+		{"14.7.1", "SonomaLate"}, // The longer prefix wins
+		{"14.6", "Sonoma"},
+		{"16.0", "NextOS"},
+		{"13.7", "Ventura"},
+	}
+
+	for _, test := range tests {
+		line, ok := table.LineFor(test.version)
+		if !ok || line != test.line {
+			t.Errorf("LineFor(%q) = %q, %v, want %q", test.version, line, ok, test.line)
+		}
+	}
+
+	if _, ok := table.LineNamed("Sequoia"); ok {
+		t.Error("The replaced Sequoia line is still in the table")
+	}
+}
+
+func TestReleaseLineTableLineNamed(t *testing.T) {
+	table := newReleaseLineTable(t, tracker.DefaultMacReleaseLines)
+
+	tests := []struct {
+		name string
+		line string
+		ok   bool
+	}{
+		{"High Sierra", "HighSierra", true},
+		{"highsierra", "HighSierra", true},
+		{"Big Sur", "BigSur", true},
+		{"El Capitan", "ElCapitan", true},
+		{"Tahoe", "Tahoe", true},
+		{"Cheetah", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		line, ok := table.LineNamed(test.name)
+		if line != test.line || ok != test.ok {
+			t.Errorf("LineNamed(%q) = %q, %v, want %q, %v", test.name, line, ok, test.line, test.ok)
+		}
+	}
+}
+
+func TestNewReleaseLineTableErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []tracker.ReleaseLine
+	}{
+		{"empty", nil},
+		{"bad prefix", []tracker.ReleaseLine{{Prefix: "15.x", Name: "Sequoia"}}},
+		{"negative prefix", []tracker.ReleaseLine{{Prefix: "-1", Name: "Sequoia"}}},
+		{"no name", []tracker.ReleaseLine{{Prefix: "15"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := tracker.NewReleaseLineTable(test.lines)
+			if err == nil {
+				t.Errorf("NewReleaseLineTable(%v) didn't fail", test.lines)
+			}
+		})
+	}
+}
+
+func TestLoadReleaseLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "release_lines.json")
+	err := ioutil.WriteFile(path, []byte(`[{"prefix": "16", "name": "NextOS"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := tracker.LoadReleaseLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []tracker.ReleaseLine{{Prefix: "16", Name: "NextOS"}}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("LoadReleaseLines() = %v, want %v", lines, want)
+	}
+}
@@ -0,0 +1,185 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+ * Maps a URL to where its body lives under dir: <dir>/<host>/<path>, so
+ * https://swscan.apple.com/content/catalogs/others/index-14.merged-1.sucatalog is kept at
+ * <dir>/swscan.apple.com/content/catalogs/others/index-14.merged-1.sucatalog.
+ * Paths ending in "/" get an "index" file, and a query string is escaped onto the file name.
+ */
+func URLPath(dir string, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("URL %q has no host", rawURL)
+	}
+
+	urlPath := u.Path
+	if urlPath == "" || strings.HasSuffix(urlPath, "/") {
+		urlPath += "index"
+	}
+	if u.RawQuery != "" {
+		urlPath += "%3F" + url.QueryEscape(u.RawQuery)
+	}
+
+	// Clean as an absolute path first so ".." can't climb out of dir
+	return filepath.Join(dir, u.Host, filepath.FromSlash(path.Clean("/"+urlPath))), nil
+}
+
+/**
+ * ReplayFetcher serves every request from files on disk instead of the network, so captured
+ * catalogs and distributions can be parsed offline. http(s) URLs are looked up under Dir with
+ * URLPath; file:// URLs are read directly. A missing file is a 404, and the file's modification
+ * time stands in for Last-Modified so conditional requests get a 304 until the file changes.
+ */
+type ReplayFetcher struct {
+	Dir string
+}
+
+func NewReplayFetcher(dir string) (*ReplayFetcher, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return &ReplayFetcher{Dir: dir}, nil
+}
+
+func (f *ReplayFetcher) Fetch(ctx context.Context, rawURL string, validator *Validator) (*FetchResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filePath, err := f.path(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"url":       rawURL,
+			"path":      filePath,
+		}).Warn("No recorded response for URL")
+		return &FetchResponse{StatusCode: http.StatusNotFound, Header: http.Header{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+
+	if validator != nil && validator.LastModified != "" {
+		since, err := http.ParseTime(validator.LastModified)
+		if err == nil && !modTime.After(since) {
+			return &FetchResponse{StatusCode: http.StatusNotModified, Header: header}, nil
+		}
+	}
+
+	body, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &FetchResponse{StatusCode: http.StatusOK, Header: header, Body: body}, nil
+}
+
+func (f *ReplayFetcher) path(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return filepath.FromSlash(u.Path), nil
+	case "http", "https":
+		return URLPath(f.Dir, rawURL)
+	default:
+		return "", fmt.Errorf("Can't replay URL %q", rawURL)
+	}
+}
+
+/**
+ * RecordingFetcher passes requests through to another Fetcher and saves every 200 response
+ * under Dir in the layout ReplayFetcher reads, so a live run can be replayed later.
+ * Requests are always made unconditionally so there is a body to save; scrapers should also set
+ * FetchOptions.Unconditional so they don't skip URLs they have cached.
+ * Failing to save a response is logged but doesn't fail the fetch.
+ */
+type RecordingFetcher struct {
+	Fetcher Fetcher
+	Dir     string
+}
+
+func NewRecordingFetcher(fetcher Fetcher, dir string) *RecordingFetcher {
+	return &RecordingFetcher{
+		Fetcher: fetcher,
+		Dir:     dir,
+	}
+}
+
+func (f *RecordingFetcher) Fetch(ctx context.Context, rawURL string, validator *Validator) (*FetchResponse, error) {
+	// A 304 has nothing to record, and replaying without the same state would 404
+	resp, err := f.Fetcher.Fetch(ctx, rawURL, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	err = f.record(rawURL, resp)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"url":       rawURL,
+			"err":       err,
+		}).Error("Error recording response")
+	}
+
+	return resp, nil
+}
+
+func (f *RecordingFetcher) record(rawURL string, resp *FetchResponse) error {
+	filePath, err := URLPath(f.Dir, rawURL)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = WriteFileAtomic(filePath, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Keep the server's Last-Modified so replaying the recording answers conditional requests the same way
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		return os.Chtimes(filePath, lastModified, lastModified)
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package tracker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+)
+
+func TestURLPath(t *testing.T) {
+	dir := filepath.FromSlash("/recordings")
+
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{
+			url:  "https://swscan.apple.com/content/catalogs/others/index-14.merged-1.sucatalog",
+			want: "/recordings/swscan.apple.com/content/catalogs/others/index-14.merged-1.sucatalog",
+		},
+		{
+			url:  "http://127.0.0.1:8080/content/downloads/062-01234.English.dist",
+			want: "/recordings/127.0.0.1:8080/content/downloads/062-01234.English.dist",
+		},
+		{url: "https://example.com", want: "/recordings/example.com/index"},
+		{url: "https://example.com/catalogs/", want: "/recordings/example.com/catalogs/index"},
+		{url: "https://example.com/catalog?seed=1&b=2", want: "/recordings/example.com/catalog%3Fseed%3D1%26b%3D2"},
+		{url: "https://example.com/../../etc/passwd", want: "/recordings/example.com/etc/passwd"},
+		{url: "/content/catalogs/index.sucatalog", wantErr: true},
+		{url: "://bad", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			got, err := tracker.URLPath(dir, test.url)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("URLPath(%q) = %q, want an error", test.url, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.FromSlash(test.want); got != want {
+				t.Errorf("URLPath(%q) = %q, want %q", test.url, got, want)
+			}
+		})
+	}
+}
+
+/**
+ * Writes body where a ReplayFetcher under dir looks for url, dated modTime
+ */
+func writeRecording(t *testing.T, dir string, url string, body string, modTime time.Time) string {
+	filePath, err := tracker.URLPath(dir, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filePath, []byte(body), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(filePath, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestReplayFetcher(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC)
+	const url = "https://swscan.apple.com/content/catalogs/index.sucatalog"
+	filePath := writeRecording(t, dir, url, "catalog", modTime)
+
+	fetcher, err := tracker.NewReplayFetcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		validator  *tracker.Validator
+		wantStatus int
+		wantBody   string
+	}{
+		{"plain", url, nil, http.StatusOK, "catalog"},
+		{"unchanged since", url, &tracker.Validator{LastModified: modTime.Format(http.TimeFormat)}, http.StatusNotModified, ""},
+		{"newer than we have", url, &tracker.Validator{LastModified: modTime.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified, ""},
+		{"changed since", url, &tracker.Validator{LastModified: modTime.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK, "catalog"},
+		{"ETag only", url, &tracker.Validator{ETag: `"abc"`}, http.StatusOK, "catalog"},
+		{"file URL", "file://" + filepath.ToSlash(filePath), nil, http.StatusOK, "catalog"},
+		{"not recorded", "https://swscan.apple.com/content/catalogs/missing.sucatalog", nil, http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := fetcher.Fetch(context.Background(), test.url, test.validator)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("Status = %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			if string(resp.Body) != test.wantBody {
+				t.Errorf("Body = %q, want %q", resp.Body, test.wantBody)
+			}
+			if test.wantStatus != http.StatusNotFound && resp.Header.Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified = %q, want %q", resp.Header.Get("Last-Modified"), modTime.Format(http.TimeFormat))
+			}
+		})
+	}
+
+	_, err = fetcher.Fetch(context.Background(), "ftp://swscan.apple.com/index.sucatalog", nil)
+	if err == nil {
+		t.Error("Fetching an ftp:// URL didn't fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = fetcher.Fetch(ctx, url, nil)
+	if err != context.Canceled {
+		t.Errorf("Fetch() with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNewReplayFetcherNeedsADirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := tracker.NewReplayFetcher(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("NewReplayFetcher() on a missing directory didn't fail")
+	}
+
+	file := filepath.Join(dir, "file")
+	err = ioutil.WriteFile(file, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tracker.NewReplayFetcher(file)
+	if err == nil {
+		t.Error("NewReplayFetcher() on a file didn't fail")
+	}
+}
+
+/**
+ * A Fetcher that answers every URL with the same response
+ */
+type cannedFetcher struct {
+	resp *tracker.FetchResponse
+}
+
+func (f cannedFetcher) Fetch(ctx context.Context, url string, validator *tracker.Validator) (*tracker.FetchResponse, error) {
+	return f.resp, nil
+}
+
+func TestRecordingFetcher(t *testing.T) {
+	lastModified := time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC)
+	const url = "https://swscan.apple.com/content/catalogs/index.sucatalog"
+
+	tests := []struct {
+		name         string
+		resp         *tracker.FetchResponse
+		wantRecorded bool
+	}{
+		{
+			name:         "200",
+			resp:         &tracker.FetchResponse{StatusCode: http.StatusOK, Header: http.Header{"Last-Modified": {lastModified.Format(http.TimeFormat)}}, Body: []byte("catalog")},
+			wantRecorded: true,
+		},
+		{
+			name:         "200 without Last-Modified",
+			resp:         &tracker.FetchResponse{StatusCode: http.StatusOK, Header: http.Header{}, Body: []byte("catalog")},
+			wantRecorded: true,
+		},
+		{
+			name: "304",
+			resp: &tracker.FetchResponse{StatusCode: http.StatusNotModified, Header: http.Header{}},
+		},
+		{
+			name: "500",
+			resp: &tracker.FetchResponse{StatusCode: http.StatusInternalServerError, Header: http.Header{}, Body: []byte("oops")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fetcher := tracker.NewRecordingFetcher(cannedFetcher{test.resp}, dir)
+
+			resp, err := fetcher.Fetch(context.Background(), url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp != test.resp {
+				t.Errorf("Fetch() = %+v, want the wrapped fetcher's response", resp)
+			}
+
+			filePath, err := tracker.URLPath(dir, url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadFile(filePath)
+			if !test.wantRecorded {
+				if !os.IsNotExist(err) {
+					t.Errorf("A %d response was recorded", test.resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != "catalog" {
+				t.Errorf("Recorded %q, want %q", body, "catalog")
+			}
+
+			if test.resp.Header.Get("Last-Modified") != "" {
+				info, err := os.Stat(filePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.ModTime().Equal(lastModified) {
+					t.Errorf("Recording is dated %v, want the server's Last-Modified %v", info.ModTime(), lastModified)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayRecordedScrape(t *testing.T) {
+	server := trackertest.NewServer()
+	for _, product := range []trackertest.Product{sonoma, ventura, highSierra, safari} {
+		server.SetProduct(product)
+	}
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key, ventura.Key, highSierra.Key, safari.Key)
+	catalog := server.Catalog("14", tracker.ChannelRelease)
+
+	dir := t.TempDir()
+	recording, _ := newTrackerWithFetcher(t, tracker.NewRecordingFetcher(newFastFetcher(t), dir), catalog)
+	recording.ScrapeForMacVersions()
+	if err := scrapeError(recording); err != nil {
+		t.Fatalf("Recorded scrape failed: %v", err)
+	}
+	live := latestVersions(t, recording)
+
+	// Nothing from here on touches the network
+	server.Close()
+
+	replayFetcher, err := tracker.NewReplayFetcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaying, _ := newTrackerWithFetcher(t, replayFetcher, catalog)
+	replaying.ScrapeForMacVersions()
+	if err := scrapeError(replaying); err != nil {
+		t.Fatalf("Replayed scrape failed: %v", err)
+	}
+
+	checkVersions(t, replaying, live)
+	checkVersions(t, replaying, map[string]string{
+		"Sonoma":     "14.7.1 (23H222)",
+		"Ventura":    "13.7.1 (22H221)",
+		"HighSierra": "10.13.6 (17G65)",
+	})
+}
+
+/**
+ * Returns a mac scraper for the catalog that fetches through fetcher
+ */
+func newMacScraper(t *testing.T, fetcher tracker.Fetcher, fetchOptions tracker.FetchOptions, catalog tracker.Catalog) *tracker.MacScraper {
+	macScraper, err := tracker.NewMacScraper([]tracker.Catalog{catalog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	macScraper.SetFetcher(fetcher)
+
+	err = macScraper.SetFetchOptions(fetchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return macScraper
+}
+
+func TestRecordWithLoadedStateReplaysWithout(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	for _, product := range []trackertest.Product{sonoma, ventura, highSierra} {
+		server.SetProduct(product)
+	}
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key, ventura.Key, highSierra.Key)
+	catalog := server.Catalog("14", tracker.ChannelRelease)
+
+	// An earlier run leaves validators, distributions and snapshots in the state
+	earlier := newMacScraper(t, newFastFetcher(t), tracker.FetchOptions{Workers: 4}, catalog)
+	_, err := earlier.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := earlier.SaveState()
+
+	dir := t.TempDir()
+	recording := newMacScraper(t, tracker.NewRecordingFetcher(newFastFetcher(t), dir), tracker.FetchOptions{Workers: 4, Unconditional: true}, catalog)
+	recording.LoadState(state)
+	server.ResetResponses()
+	_, err = recording.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, product := range []trackertest.Product{sonoma, ventura, highSierra} {
+		if responses := server.Responses(server.DistributionPath(product.Key)); len(responses) != 1 || responses[0] != http.StatusOK {
+			t.Errorf("Recording got %v for the %s distribution, want a single 200", responses, product.Title)
+		}
+	}
+
+	replayFetcher, err := tracker.NewReplayFetcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaying := newMacScraper(t, replayFetcher, tracker.FetchOptions{Workers: 4}, catalog)
+	versionsInfo, err := replaying.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for line, want := range map[string]string{"Sonoma": "14.7.1", "Ventura": "13.7.1", "HighSierra": "10.13.6"} {
+		if got := versionsInfo.LatestVersions[line]; got == nil || got.String() != want {
+			t.Errorf("Replayed %s = %v, want %s", line, got, want)
+		}
+	}
+}
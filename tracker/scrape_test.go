@@ -0,0 +1,734 @@
+package tracker_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+	"github.com/phoebesimon/version_tracker/tracker/trackertest"
+)
+
+var (
+	sonoma = trackertest.Product{
+		Key:      "062-01234",
+		PostDate: time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC),
+		Title:    "macOS Sonoma 14.7.1",
+		Version:  "14.7.1",
+		Build:    "23H222",
+	}
+	ventura = trackertest.Product{
+		Key:      "062-01235",
+		PostDate: time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC),
+		Title:    "macOS Ventura 13.7.1",
+		Version:  "13.7.1",
+		Build:    "22H221",
+	}
+	highSierra = trackertest.Product{
+		Key:      "041-91758",
+		PostDate: time.Date(2018, time.July, 9, 17, 0, 0, 0, time.UTC),
+		Title:    "macOS High Sierra 10.13.6 Update",
+		Version:  "10.13.6",
+		Build:    "17G65",
+	}
+	safari = trackertest.Product{
+		Key:     "061-99999",
+		Title:   "Safari",
+		Version: "18.1",
+	}
+	recovery = trackertest.Product{
+		Key:     "061-88888",
+		Title:   "macOS Recovery Update",
+		Version: "14.7.1",
+	}
+)
+
+/**
+ * Records every change event the tracker publishes
+ */
+type recordingNotifier struct {
+	events []tracker.ChangeEvent
+	mtx    sync.Mutex
+}
+
+func (n *recordingNotifier) Notify(event tracker.ChangeEvent) error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	n.events = append(n.events, event)
+	return nil
+}
+
+func (n *recordingNotifier) Events() []tracker.ChangeEvent {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	events := n.events
+	n.events = nil
+	return events
+}
+
+/**
+ * Returns a tracker scraping the given catalogs with retries fast enough for tests and no rate limit
+ */
+func newTracker(t *testing.T, catalogs ...tracker.Catalog) (*tracker.Tracker, *recordingNotifier) {
+	return newTrackerWithFetcher(t, newFastFetcher(t), catalogs...)
+}
+
+func newFastFetcher(t *testing.T) *tracker.HTTPFetcher {
+	fetcher, err := tracker.NewHTTPFetcher(tracker.HTTPConfig{
+		Timeout:    time.Second,
+		MaxRetries: 2,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetcher
+}
+
+/**
+ * Like newTracker, but the mac scraper fetches everything through fetcher
+ */
+func newTrackerWithFetcher(t *testing.T, fetcher tracker.Fetcher, catalogs ...tracker.Catalog) (*tracker.Tracker, *recordingNotifier) {
+	macScraper, err := tracker.NewMacScraper(catalogs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	macScraper.SetFetcher(fetcher)
+
+	err = macScraper.SetFetchOptions(tracker.FetchOptions{Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versionTracker := tracker.MakeTracker(300)
+	err = versionTracker.Register(macScraper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &recordingNotifier{}
+	versionTracker.AddNotifier(notifier)
+
+	return versionTracker, notifier
+}
+
+/**
+ * Returns the tracker's macOS versions as line --> "version (build)"
+ */
+func latestVersions(t *testing.T, versionTracker *tracker.Tracker) map[string]string {
+	versionsInfo := versionTracker.ReadVersions(tracker.OSTypeMac)
+	if versionsInfo == nil {
+		t.Fatal("No macOS versions")
+	}
+
+	latest := make(map[string]string)
+	for line, ver := range versionsInfo.LatestVersions {
+		latest[line] = ver.String() + " (" + versionsInfo.LatestBuilds[line] + ")"
+	}
+	return latest
+}
+
+func checkVersions(t *testing.T, versionTracker *tracker.Tracker, want map[string]string) {
+	got := latestVersions(t, versionTracker)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Latest versions = %v, want %v", got, want)
+	}
+}
+
+func scrapeError(versionTracker *tracker.Tracker) error {
+	for _, status := range versionTracker.ReadScrapeStatus(tracker.OSTypeMac) {
+		if status.LastError != nil {
+			return status.LastError
+		}
+	}
+	return nil
+}
+
+func TestScrapeForMacVersionsBucketsByReleaseLine(t *testing.T) {
+	for _, format := range []string{trackertest.FormatXML, trackertest.FormatBinary} {
+		t.Run(format, func(t *testing.T) {
+			server := trackertest.NewServer()
+			defer server.Close()
+
+			for _, product := range []trackertest.Product{sonoma, ventura, highSierra, safari, recovery} {
+				server.SetProduct(product)
+			}
+			server.SetCatalog("14", format, sonoma.Key, ventura.Key, highSierra.Key, safari.Key, recovery.Key)
+
+			versionTracker, notifier := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+			versionTracker.ScrapeForMacVersions()
+
+			if err := scrapeError(versionTracker); err != nil {
+				t.Fatalf("Scrape failed: %v", err)
+			}
+
+			checkVersions(t, versionTracker, map[string]string{
+				"Sonoma":     "14.7.1 (23H222)",
+				"Ventura":    "13.7.1 (22H221)",
+				"HighSierra": "10.13.6 (17G65)",
+			})
+
+			// The first scrape is only the baseline
+			if events := notifier.Events(); len(events) != 0 {
+				t.Errorf("Got %d change events from the first scrape, want none: %+v", len(events), events)
+			}
+
+			newer := trackertest.Product{
+				Key:      "062-01240",
+				PostDate: time.Date(2024, time.December, 11, 17, 0, 0, 0, time.UTC),
+				Title:    "macOS Sonoma 14.7.2",
+				Version:  "14.7.2",
+				Build:    "23H311",
+			}
+			server.SetProduct(newer)
+			server.SetCatalog("14", format, sonoma.Key, ventura.Key, highSierra.Key, safari.Key, recovery.Key, newer.Key)
+			versionTracker.ScrapeForMacVersions()
+
+			events := notifier.Events()
+			if len(events) != 1 || events[0].Line != "Sonoma" || events[0].OldVersion != "14.7.1" || events[0].NewVersion != "14.7.2" {
+				t.Errorf("Got change events %+v, want just Sonoma 14.7.1 -> 14.7.2", events)
+			}
+		})
+	}
+}
+
+func TestScrapeForMacVersionsTagsSeedChannels(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	beta := trackertest.Product{
+		Key:     "072-00001",
+		Title:   "macOS Sequoia 15.2 beta",
+		Version: "15.2",
+		Build:   "24C5057p",
+	}
+	server.SetProduct(sonoma)
+	server.SetProduct(beta)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key)
+	server.SetCatalog("15-seed", trackertest.FormatXML, sonoma.Key, beta.Key)
+
+	versionTracker, _ := newTracker(t,
+		server.Catalog("14", tracker.ChannelRelease),
+		server.Catalog("15-seed", tracker.ChannelDeveloperSeed),
+	)
+	versionTracker.ScrapeForMacVersions()
+
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma":                "14.7.1 (23H222)",
+		"Sonoma-DeveloperSeed":  "14.7.1 (23H222)",
+		"Sequoia-DeveloperSeed": "15.2.0 (24C5057p)",
+	})
+}
+
+func TestScrapeForMacVersionsFallsBackToRegexes(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	// Not XML at all, but the localization strings are still in there
+	legacy := trackertest.Product{
+		Key: "031-12345",
+		Distribution: `"SU_TITLE" = "OS X El Capitan 10.11.6";
+"SU_VERS" = "10.11.6";
+`,
+	}
+	server.SetProduct(legacy)
+	server.SetCatalog("10.11", trackertest.FormatXML, legacy.Key)
+
+	versionTracker, _ := newTracker(t, server.Catalog("10.11", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+
+	checkVersions(t, versionTracker, map[string]string{
+		"ElCapitan": "10.11.6 ()",
+	})
+}
+
+func TestScrapeForMacVersionsUsesServerMetadataBuild(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	noBuild := sonoma
+	noBuild.Build = ""
+	noBuild.ServerMetadataBuild = "23H222"
+	server.SetProduct(noBuild)
+	server.SetCatalog("14", trackertest.FormatBinary, noBuild.Key)
+
+	versionTracker, _ := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma": "14.7.1 (23H222)",
+	})
+}
+
+func TestScrapeForMacVersionsSkipsUnchangedCatalogs(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	server.SetProduct(sonoma)
+	server.SetProduct(ventura)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key, ventura.Key)
+
+	versionTracker, notifier := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+	notifier.Events()
+	server.ResetResponses()
+
+	versionTracker.ScrapeForMacVersions()
+
+	if got := server.Responses(server.CatalogPath("14")); !reflect.DeepEqual(got, []int{http.StatusNotModified}) {
+		t.Errorf("Catalog responses = %v, want a single 304", got)
+	}
+	if got := server.Responses(server.DistributionPath(sonoma.Key)); len(got) != 0 {
+		t.Errorf("Distribution was fetched again for an unchanged catalog: %v", got)
+	}
+	if events := notifier.Events(); len(events) != 0 {
+		t.Errorf("Got change events for an unchanged catalog: %+v", events)
+	}
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma":  "14.7.1 (23H222)",
+		"Ventura": "13.7.1 (22H221)",
+	})
+}
+
+func TestScrapeForMacVersionsOnlyFetchesChangedProducts(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	server.SetProduct(sonoma)
+	server.SetProduct(ventura)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key, ventura.Key)
+
+	versionTracker, notifier := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+	notifier.Events()
+	server.ResetResponses()
+
+	// Apple re-posts Sonoma as a supplemental update with a new build
+	supplemental := sonoma
+	supplemental.Title = "macOS Sonoma 14.7.1 Supplemental Update"
+	supplemental.Build = "23H224"
+	supplemental.PostDate = sonoma.PostDate.Add(7 * 24 * time.Hour)
+	server.SetProduct(supplemental)
+
+	versionTracker.ScrapeForMacVersions()
+
+	if got := server.Responses(server.DistributionPath(sonoma.Key)); !reflect.DeepEqual(got, []int{http.StatusOK}) {
+		t.Errorf("Changed distribution responses = %v, want a single 200", got)
+	}
+	if got := server.Responses(server.DistributionPath(ventura.Key)); len(got) != 0 {
+		t.Errorf("Unchanged distribution was fetched again: %v", got)
+	}
+
+	events := notifier.Events()
+	if len(events) != 1 || events[0].Kind != tracker.ChangeKindSupplemental || events[0].OldBuild != "23H222" || events[0].NewBuild != "23H224" {
+		t.Errorf("Got change events %+v, want one supplemental 23H222 -> 23H224", events)
+	}
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma":  "14.7.1 (23H224)",
+		"Ventura": "13.7.1 (22H221)",
+	})
+}
+
+func TestScrapeForMacVersionsRetriesServerErrors(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	server.SetProduct(sonoma)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key)
+	server.InjectFault(server.CatalogPath("14"), trackertest.Fault{StatusCode: http.StatusServiceUnavailable, Times: 1})
+	server.InjectFault(server.DistributionPath(sonoma.Key), trackertest.Fault{StatusCode: http.StatusTooManyRequests, RetryAfter: "0", Times: 1})
+
+	versionTracker, _ := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+
+	if err := scrapeError(versionTracker); err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	if got := server.Responses(server.CatalogPath("14")); !reflect.DeepEqual(got, []int{http.StatusServiceUnavailable, http.StatusOK}) {
+		t.Errorf("Catalog responses = %v, want a 503 then a 200", got)
+	}
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma": "14.7.1 (23H222)",
+	})
+}
+
+func TestScrapeForMacVersionsKeepsVersionsOnFailure(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	server.SetProduct(sonoma)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key)
+
+	versionTracker, _ := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+
+	// Ventura is there to be found once the catalog can be fetched again
+	server.SetProduct(ventura)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key, ventura.Key)
+
+	for _, fault := range []trackertest.Fault{
+		{StatusCode: http.StatusInternalServerError},
+		{StatusCode: http.StatusNotFound},
+		{Body: "<plist><dict><key>Products</key>"},
+	} {
+		server.ClearFaults()
+		server.InjectFault(server.CatalogPath("14"), fault)
+
+		versionTracker.ScrapeForMacVersions()
+
+		if err := scrapeError(versionTracker); err == nil {
+			t.Errorf("Scrape with fault %+v succeeded", fault)
+		}
+		checkVersions(t, versionTracker, map[string]string{
+			"Sonoma": "14.7.1 (23H222)",
+		})
+	}
+
+	server.ClearFaults()
+	versionTracker.ScrapeForMacVersions()
+
+	if err := scrapeError(versionTracker); err != nil {
+		t.Fatalf("Scrape failed after faults were cleared: %v", err)
+	}
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma":  "14.7.1 (23H222)",
+		"Ventura": "13.7.1 (22H221)",
+	})
+}
+
+func TestScrapeForMacVersionsIgnoresOlderReleases(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	// Products are applied in key order, so this one comes after the newer release
+	older := trackertest.Product{
+		Key:      "062-09999",
+		PostDate: time.Date(2024, time.September, 16, 17, 0, 0, 0, time.UTC),
+		Title:    "macOS Sonoma 14.7",
+		Version:  "14.7",
+		Build:    "23H124",
+	}
+	server.SetProduct(ventura)
+	server.SetCatalog("14", trackertest.FormatXML, ventura.Key)
+
+	versionTracker, notifier := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+	notifier.Events()
+
+	server.SetProduct(sonoma)
+	server.SetProduct(older)
+	server.SetCatalog("14", trackertest.FormatXML, ventura.Key, sonoma.Key, older.Key)
+	versionTracker.ScrapeForMacVersions()
+
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma":  "14.7.1 (23H222)",
+		"Ventura": "13.7.1 (22H221)",
+	})
+
+	history := versionTracker.History(tracker.HistoryQuery{OSType: tracker.OSTypeMac, Line: "Sonoma"})
+	if len(history) != 2 || history[0].Version != "14.7.0" || history[1].Version != "14.7.1" {
+		t.Errorf("History = %+v, want 14.7 then 14.7.1", history)
+	}
+
+	events := notifier.Events()
+	if len(events) != 1 || events[0].NewVersion != "14.7.1" {
+		t.Errorf("Got change events %+v, want just 14.7.1", events)
+	}
+}
+
+func TestScrapeForMacVersionsTracksSecurityUpdates(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	elCapitan := trackertest.Product{
+		Key:      "091-76037",
+		PostDate: time.Date(2018, time.July, 9, 17, 0, 0, 0, time.UTC),
+		Title:    "Security Update 2018-004 El Capitan",
+		Version:  "10.11.6",
+		Build:    "15G22010",
+	}
+	mojave := trackertest.Product{
+		Key:      "061-41823",
+		PostDate: time.Date(2020, time.January, 28, 17, 0, 0, 0, time.UTC),
+		Title:    "Security Update 2020-001 (Mojave)",
+		Build:    "18G3020",
+	}
+	olderMojave := trackertest.Product{
+		Key:      "061-99998",
+		PostDate: time.Date(2019, time.December, 10, 17, 0, 0, 0, time.UTC),
+		Title:    "Security Update 2019-002 (Mojave)",
+		Build:    "18G2022",
+	}
+	server.SetCatalog("10.15", trackertest.FormatXML)
+
+	versionTracker, notifier := newTracker(t, server.Catalog("10.15", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+
+	server.SetProduct(elCapitan)
+	server.SetProduct(mojave)
+	server.SetProduct(olderMojave)
+	server.SetProduct(highSierra)
+	server.SetCatalog("10.15", trackertest.FormatXML, elCapitan.Key, mojave.Key, olderMojave.Key, highSierra.Key)
+	versionTracker.ScrapeForMacVersions()
+
+	if err := scrapeError(versionTracker); err != nil {
+		t.Fatal(err)
+	}
+
+	// Security updates don't count as point releases
+	checkVersions(t, versionTracker, map[string]string{
+		"HighSierra": "10.13.6 (17G65)",
+	})
+
+	securityUpdates := versionTracker.ReadVersions(tracker.OSTypeMac).SecurityUpdates
+	got := make(map[string]string)
+	for line, update := range securityUpdates {
+		got[line] = update.Name + " (" + update.Build + ")"
+	}
+	want := map[string]string{
+		"ElCapitan": "2018-004 (15G22010)",
+		"Mojave":    "2020-001 (18G3020)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Security updates = %v, want %v", got, want)
+	}
+
+	kinds := make(map[string]int)
+	for _, event := range notifier.Events() {
+		kinds[event.Kind]++
+		if event.Kind == tracker.ChangeKindSecurityUpdate && event.NewVersion == "2019-002" {
+			t.Errorf("Got a change event for an older security update: %+v", event)
+		}
+	}
+	if kinds[tracker.ChangeKindSecurityUpdate] != 2 || kinds[tracker.ChangeKindVersion] != 1 {
+		t.Errorf("Got change events by kind %v, want 2 security updates and 1 version", kinds)
+	}
+
+	history := versionTracker.History(tracker.HistoryQuery{OSType: tracker.OSTypeMac, Kind: tracker.ReleaseKindSecurityUpdate})
+	if len(history) != 3 {
+		t.Errorf("Got %d security updates in history, want 3", len(history))
+	}
+}
+
+func TestScrapeForMacVersionsTracksFullInstallers(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	// Full installer distributions are titled like updates; only the catalog gives them away
+	installer := trackertest.Product{
+		Key:      "072-12345",
+		PostDate: time.Date(2024, time.October, 28, 17, 0, 0, 0, time.UTC),
+		Title:    "macOS Sonoma",
+		Version:  "14.7.1",
+		Build:    "23H222",
+		Packages: []trackertest.Package{
+			{Name: "BuildManifest.plist", Size: 1893, Digest: "0c1b2a"},
+			{Name: "InstallAssistant.pkg", Size: 13338400178, Digest: "a1b2c3d4"},
+		},
+		InstallAssistant: true,
+	}
+	olderInstaller := trackertest.Product{
+		Key:              "072-99999",
+		PostDate:         time.Date(2024, time.September, 16, 17, 0, 0, 0, time.UTC),
+		Title:            "macOS Sonoma",
+		Version:          "14.7",
+		Build:            "23H124",
+		Packages:         []trackertest.Package{{Name: "InstallAssistant.pkg", Size: 13321007325, Digest: "e5f6a7b8"}},
+		InstallAssistant: true,
+	}
+	// Older installers only have their package to go on
+	legacyInstaller := trackertest.Product{
+		Key:      "041-91750",
+		PostDate: time.Date(2018, time.July, 9, 17, 0, 0, 0, time.UTC),
+		Title:    "Install macOS High Sierra",
+		Version:  "10.13.6",
+		Build:    "17G66",
+		Packages: []trackertest.Package{
+			{Name: "InstallAssistantAuto.pkg", Size: 11290256, Digest: "c9d0e1f2"},
+			{Name: "InstallESDDmg.pkg", Size: 5179484928, Digest: "a3b4c5d6"},
+		},
+	}
+	server.SetCatalog("14", trackertest.FormatXML)
+
+	versionTracker, notifier := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	versionTracker.ScrapeForMacVersions()
+
+	// Neither of these is a full installer we can hand out
+	notification := trackertest.Product{
+		Key:     "072-55555",
+		Title:   "macOS Installer Notification",
+		Version: "14.8",
+	}
+	noPackage := trackertest.Product{
+		Key:              "072-66666",
+		Title:            "macOS Sonoma",
+		Version:          "14.9",
+		Build:            "23J999",
+		InstallAssistant: true,
+	}
+	server.SetProduct(sonoma)
+	server.SetProduct(installer)
+	server.SetProduct(olderInstaller)
+	server.SetProduct(legacyInstaller)
+	server.SetProduct(notification)
+	server.SetProduct(noPackage)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key, installer.Key, olderInstaller.Key, legacyInstaller.Key, notification.Key, noPackage.Key)
+	versionTracker.ScrapeForMacVersions()
+
+	if err := scrapeError(versionTracker); err != nil {
+		t.Fatal(err)
+	}
+
+	// Installers don't count as point releases
+	checkVersions(t, versionTracker, map[string]string{
+		"Sonoma": "14.7.1 (23H222)",
+	})
+
+	want := map[string]tracker.FullInstaller{
+		"Sonoma": {
+			Version:    "14.7.1",
+			Build:      "23H222",
+			Title:      "macOS Sonoma",
+			ProductKey: installer.Key,
+			PostDate:   installer.PostDate,
+			Package: tracker.InstallerPackage{
+				URL:    server.PackageURL(installer.Key, "InstallAssistant.pkg"),
+				Size:   13338400178,
+				Digest: "a1b2c3d4",
+			},
+		},
+		"HighSierra": {
+			Version:    "10.13.6",
+			Build:      "17G66",
+			Title:      "Install macOS High Sierra",
+			ProductKey: legacyInstaller.Key,
+			PostDate:   legacyInstaller.PostDate,
+			Package: tracker.InstallerPackage{
+				URL:    server.PackageURL(legacyInstaller.Key, "InstallAssistantAuto.pkg"),
+				Size:   11290256,
+				Digest: "c9d0e1f2",
+			},
+		},
+	}
+	got := versionTracker.ReadVersions(tracker.OSTypeMac).FullInstallers
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Full installers = %+v, want %+v", got, want)
+	}
+
+	kinds := make(map[string]int)
+	for _, event := range notifier.Events() {
+		kinds[event.Kind]++
+	}
+	if kinds[tracker.ChangeKindFullInstaller] != 2 || kinds[tracker.ChangeKindVersion] != 1 {
+		t.Errorf("Got change events by kind %v, want 2 full installers and 1 version", kinds)
+	}
+
+	history := versionTracker.History(tracker.HistoryQuery{OSType: tracker.OSTypeMac, Kind: tracker.ReleaseKindFullInstaller})
+	if len(history) != 3 || history[2].Package == nil || history[2].Package.Digest != "a1b2c3d4" {
+		t.Errorf("Full installer history = %+v, want 3 installers with their packages", history)
+	}
+
+	asOf := versionTracker.VersionsAsOf(tracker.OSTypeMac, time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	if asOf.FullInstallers["Sonoma"].Version != "14.7.0" {
+		t.Errorf("Sonoma installer as of October 1st = %+v, want 14.7", asOf.FullInstallers["Sonoma"])
+	}
+}
+
+func TestScrapeOnlyNotifiesAfterBaseline(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	server.SetProduct(ventura)
+	server.SetCatalog("14", trackertest.FormatXML, ventura.Key)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	// A failed scrape doesn't count as the baseline
+	server.InjectFault(server.CatalogPath("14"), trackertest.Fault{StatusCode: http.StatusNotFound, Times: 1})
+	versionTracker, notifier := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	err := versionTracker.LoadState(tracker.NewFileStorage(statePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionTracker.Scrape(context.Background())
+	if scrapeError(versionTracker) == nil {
+		t.Fatal("Expected the first scrape to fail")
+	}
+
+	versionTracker.Scrape(context.Background())
+	if events := notifier.Events(); len(events) != 0 {
+		t.Errorf("Got change events from the baseline scrape, want none: %+v", events)
+	}
+
+	// Scrape saves the state as it goes, and after a restart that is the baseline: what changed while we were down is news
+	server.SetProduct(sonoma)
+	server.SetCatalog("14", trackertest.FormatXML, ventura.Key, sonoma.Key)
+
+	restarted, notifier := newTracker(t, server.Catalog("14", tracker.ChannelRelease))
+	err = restarted.LoadState(tracker.NewFileStorage(statePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.Scrape(context.Background())
+
+	events := notifier.Events()
+	if len(events) != 1 || events[0].NewVersion != "14.7.1" {
+		t.Errorf("Got change events %+v after a restart, want just 14.7.1", events)
+	}
+}
+
+func TestScrapeForMacVersionsPrunesWithdrawnProducts(t *testing.T) {
+	server := trackertest.NewServer()
+	defer server.Close()
+
+	server.SetProduct(sonoma)
+	server.SetProduct(ventura)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key, ventura.Key)
+
+	macScraper, err := tracker.NewMacScraper([]tracker.Catalog{server.Catalog("14", tracker.ChannelRelease)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = macScraper.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := macScraper.SaveState().Validators[server.DistributionURL(ventura.Key)]; !ok {
+		t.Fatal("Ventura's distribution wasn't cached")
+	}
+
+	// Apple withdraws Ventura
+	server.RemoveProduct(ventura.Key)
+	server.SetCatalog("14", trackertest.FormatXML, sonoma.Key)
+
+	_, err = macScraper.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validators := macScraper.SaveState().Validators
+	if _, ok := validators[server.DistributionURL(ventura.Key)]; ok {
+		t.Error("Withdrawn product's distribution is still cached")
+	}
+	for _, url := range []string{server.CatalogURL("14"), server.DistributionURL(sonoma.Key)} {
+		if _, ok := validators[url]; !ok {
+			t.Errorf("%s was pruned but is still listed", url)
+		}
+	}
+
+	// A 304 keeps everything the cached catalog still lists
+	_, err = macScraper.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := macScraper.SaveState().Validators[server.DistributionURL(sonoma.Key)]; !ok {
+		t.Error("Distribution was pruned after the catalog returned a 304")
+	}
+}
@@ -0,0 +1,91 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+/**
+ * A Scraper is a single source of version info for one OS type.
+ * Scrape returns a snapshot of everything the source currently knows about;
+ * the tracker owns the returned value.
+ */
+type Scraper interface {
+	Name() string
+	OSType() string
+	Scrape(ctx context.Context) (*VersionsInfo, error)
+}
+
+var ErrDuplicateScraper = errors.New("A scraper with that name is already registered")
+
+/**
+ * Registry holds the set of scrapers the tracker runs, keyed by name
+ */
+type Registry struct {
+	scrapers map[string]Scraper
+	mtx      sync.RWMutex
+}
+
+func MakeRegistry() *Registry {
+	return &Registry{
+		scrapers: make(map[string]Scraper),
+	}
+}
+
+func (r *Registry) Register(s Scraper) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.scrapers[s.Name()]; ok {
+		return ErrDuplicateScraper
+	}
+
+	r.scrapers[s.Name()] = s
+	return nil
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.scrapers, name)
+}
+
+func (r *Registry) Get(name string) (Scraper, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	s, ok := r.scrapers[name]
+	return s, ok
+}
+
+/**
+ * Returns every registered scraper, ordered by name
+ */
+func (r *Registry) Scrapers() []Scraper {
+	return r.ScrapersFor("")
+}
+
+/**
+ * Returns the registered scrapers for an OS type, ordered by name.
+ * An empty osType matches every scraper.
+ */
+func (r *Registry) ScrapersFor(osType string) []Scraper {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	scrapers := make([]Scraper, 0, len(r.scrapers))
+	for _, s := range r.scrapers {
+		if osType == "" || s.OSType() == osType {
+			scrapers = append(scrapers, s)
+		}
+	}
+
+	sort.Slice(scrapers, func(i, j int) bool {
+		return scrapers[i].Name() < scrapers[j].Name()
+	})
+
+	return scrapers
+}
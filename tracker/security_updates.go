@@ -0,0 +1,147 @@
+package tracker
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+ * Matches titles like "Security Update 2018-002 El Capitan" or "Security Update 2020-001 (Mojave)",
+ * capturing the update name and the marketing name of the release line it applies to
+ */
+var SecurityUpdateRegex = regexp.MustCompile(`^\s*Security Update\s+(\d{4}-\d{3})\s*\(?\s*([A-Za-z][A-Za-z ]*?)?\s*\)?\s*$`)
+
+/**
+ * A SecurityUpdate is one of the dated security patches Apple ships for older release lines
+ * instead of a point release, e.g. "2018-002" for El Capitan
+ */
+type SecurityUpdate struct {
+	Name       string    `json:"name"` // e.g. "2018-002"
+	Build      string    `json:"build,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	ProductKey string    `json:"product_key,omitempty"`
+	PostDate   time.Time `json:"post_date,omitempty"`
+}
+
+/**
+ * Splits a security update title into the update name and the marketing name of its line.
+ * The line name is empty if the title doesn't give one.
+ */
+func ParseSecurityUpdateTitle(title string) (name string, lineName string, ok bool) {
+	match := SecurityUpdateRegex.FindStringSubmatch(title)
+	if match == nil {
+		return "", "", false
+	}
+
+	return match[1], strings.TrimSpace(match[2]), true
+}
+
+/**
+ * Reports whether update supersedes latest. Names are YYYY-NNN, so they sort as strings;
+ * a re-release under the same name only counts if both builds are known and the build went up.
+ */
+func IsNewerSecurityUpdate(update SecurityUpdate, latest SecurityUpdate) bool {
+	if latest.Name == "" || update.Name > latest.Name {
+		return true
+	}
+
+	return update.Name == latest.Name && update.Build != "" && latest.Build != "" && CompareBuilds(update.Build, latest.Build) > 0
+}
+
+/**
+ * Records update as the latest security update for a line if it is newer than what we have.
+ * Returns true if it was.
+ */
+func (v *VersionsInfo) UpdateSecurityUpdate(line string, update SecurityUpdate) bool {
+	if v.SecurityUpdates == nil {
+		v.SecurityUpdates = map[string]SecurityUpdate{}
+	}
+
+	latest, ok := v.SecurityUpdates[line]
+	if ok && !IsNewerSecurityUpdate(update, latest) {
+		// Same update, but we may only just have learnt its build
+		if update.Name == latest.Name && latest.Build == "" && update.Build != "" {
+			latest.Build = update.Build
+			v.SecurityUpdates[line] = latest
+		}
+		return false
+	}
+
+	v.SecurityUpdates[line] = update
+	return true
+}
+
+/**
+ * Works out which release line a security update is for: by the marketing name in its title
+ * if it has one, otherwise by the OS version its distribution targets
+ */
+func (s *MacScraper) securityUpdateLine(lineName string, dist *Distribution) (string, bool) {
+	if lineName != "" {
+		return s.releaseLines.LineNamed(lineName)
+	}
+
+	return s.releaseLines.LineFor(dist.Version)
+}
+
+/**
+ * Applies a security update product to the versions info.
+ * Returns true if it is the newest for its line. Must be called with s.mtx held.
+ */
+func (s *MacScraper) applySecurityUpdate(productFetch *productFetch, catalog Catalog) bool {
+	dist := productFetch.dist
+
+	name, lineName, ok := ParseSecurityUpdateTitle(dist.Title)
+	if !ok {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"title":     dist.Title,
+		}).Debug("Was not a dated security update")
+		return false
+	}
+
+	releaseLine, ok := s.securityUpdateLine(lineName, dist)
+	if !ok {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"key":       productFetch.key,
+			"title":     dist.Title,
+		}).Debug("Security update is not for a tracked release line")
+		return false
+	}
+
+	line := channelLine(releaseLine, catalog.Channel)
+	update := SecurityUpdate{
+		Name:       name,
+		Build:      dist.Build,
+		Title:      dist.Title,
+		ProductKey: productFetch.key,
+		PostDate:   productFetch.product.PostDate,
+	}
+
+	previous, hadPrevious := s.versionsInfo.SecurityUpdates[line]
+	changed := s.versionsInfo.UpdateSecurityUpdate(line, update)
+	if changed {
+		event := ChangeEvent{
+			Kind:       ChangeKindSecurityUpdate,
+			Line:       line,
+			NewVersion: name,
+			NewBuild:   dist.Build,
+			Title:      dist.Title,
+			ProductKey: productFetch.key,
+		}
+		if hadPrevious {
+			event.OldVersion = previous.Name
+			event.OldBuild = previous.Build
+		}
+		s.recordChange(event, catalog)
+
+		s.versionsInfo.LastModified = time.Now()
+	}
+
+	s.recordProduct(productFetch.key, ReleaseKindSecurityUpdate, line, name, dist, productFetch.distributionURL, productFetch.product.PostDate, catalog)
+
+	return changed
+}
@@ -0,0 +1,229 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stateFormatVersion = 1
+)
+
+/**
+ * The HTTP validators the server sent the last time we fetched a URL
+ */
+type Validator struct {
+	LastModified string    `json:"last_modified,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+/**
+ * Everything we have learned about a single release of a catalog product
+ */
+type ProductRecord struct {
+	Key             string            `json:"key"`
+	Kind            string            `json:"kind,omitempty"` // A ReleaseKind; empty in older state files, where everything was an update
+	OSType          string            `json:"os_type"`
+	Line            string            `json:"line"`
+	Version         string            `json:"version"` // For security updates, the update name (e.g. "2018-002")
+	Build           string            `json:"build,omitempty"`
+	Title           string            `json:"title,omitempty"`
+	DistributionURL string            `json:"distribution_url"`
+	Package         *InstallerPackage `json:"package,omitempty"` // For full installers
+	Channel         string            `json:"channel"`
+	Catalogs        []string          `json:"catalogs"`
+	PostDate        time.Time         `json:"post_date,omitempty"` // When Apple posted it to the catalog
+	FirstSeen       time.Time         `json:"first_seen"`
+	LastSeen        time.Time         `json:"last_seen"`
+}
+
+/**
+ * The persisted state of a single source
+ */
+type SourceState struct {
+	Versions   *VersionsInfo              `json:"versions"`
+	Validators map[string]*CacheEntry     `json:"validators"` // URL --> validators and cached parse
+	Products   map[string]*ProductRecord  `json:"products"`   // Release ID --> what we learned about it
+	Snapshots  map[string]CatalogSnapshot `json:"snapshots"`  // Catalog name --> products as of the last scrape
+}
+
+type State struct {
+	FormatVersion int                     `json:"format_version"`
+	SavedAt       time.Time               `json:"saved_at"`
+	Sources       map[string]*SourceState `json:"sources"` // Scraper name --> state
+}
+
+func MakeState() *State {
+	return &State{
+		FormatVersion: stateFormatVersion,
+		Sources:       make(map[string]*SourceState),
+	}
+}
+
+/**
+ * A StatefulScraper can hand its state to the tracker to be persisted,
+ * and pick up where it left off when that state is loaded again
+ */
+type StatefulScraper interface {
+	Scraper
+	SaveState() *SourceState
+	LoadState(state *SourceState)
+}
+
+type Storage interface {
+	Load() (*State, error)
+	Save(state *State) error
+}
+
+/**
+ * FileStorage keeps the state as a JSON file, replacing it atomically on every save
+ */
+type FileStorage struct {
+	path string
+}
+
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{
+		path: path,
+	}
+}
+
+/**
+ * Reads the state file. A missing file is not an error; it just means we have no state yet.
+ * A file in any format other than ours is, rather than risk misreading its validators and
+ * products. Files from before the format was versioned are the current format.
+ */
+func (f *FileStorage) Load() (*State, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return MakeState(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := MakeState()
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.FormatVersion != stateFormatVersion {
+		log.WithFields(log.Fields{
+			"timestamp":      time.Now().UnixNano(),
+			"path":           f.path,
+			"format_version": state.FormatVersion,
+			"supported":      stateFormatVersion,
+		}).Error("State file is in an unsupported format; move it aside to start from scratch")
+		return nil, fmt.Errorf("State file %s has format version %d; only version %d is supported", f.path, state.FormatVersion, stateFormatVersion)
+	}
+
+	if state.Sources == nil {
+		state.Sources = make(map[string]*SourceState)
+	}
+
+	return state, nil
+}
+
+func (f *FileStorage) Save(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(f.path, data)
+}
+
+/**
+ * Writes data to a temp file next to path and renames it into place,
+ * so a crash mid-write never leaves a truncated file behind
+ */
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+type versionsInfoJSON struct {
+	LatestVersions  map[string]string         `json:"latest_versions"`
+	LatestBuilds    map[string]string         `json:"latest_builds,omitempty"`
+	SecurityUpdates map[string]SecurityUpdate `json:"security_updates,omitempty"`
+	FullInstallers  map[string]FullInstaller  `json:"full_installers,omitempty"`
+	LastModified    time.Time                 `json:"last_modified"`
+}
+
+func (v *VersionsInfo) MarshalJSON() ([]byte, error) {
+	latestVersions := make(map[string]string, len(v.LatestVersions))
+	for line, ver := range v.LatestVersions {
+		latestVersions[line] = ver.String()
+	}
+
+	return json.Marshal(versionsInfoJSON{
+		LatestVersions:  latestVersions,
+		LatestBuilds:    v.LatestBuilds,
+		SecurityUpdates: v.SecurityUpdates,
+		FullInstallers:  v.FullInstallers,
+		LastModified:    v.LastModified,
+	})
+}
+
+func (v *VersionsInfo) UnmarshalJSON(data []byte) error {
+	var raw versionsInfoJSON
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	v.LatestVersions = make(map[string]*version.Version, len(raw.LatestVersions))
+	for line, ver := range raw.LatestVersions {
+		parsed, err := version.NewVersion(ver)
+		if err != nil {
+			return err
+		}
+		v.LatestVersions[line] = parsed
+	}
+	v.LatestBuilds = raw.LatestBuilds
+	if v.LatestBuilds == nil {
+		v.LatestBuilds = make(map[string]string)
+	}
+	v.SecurityUpdates = raw.SecurityUpdates
+	if v.SecurityUpdates == nil {
+		v.SecurityUpdates = make(map[string]SecurityUpdate)
+	}
+	v.FullInstallers = raw.FullInstallers
+	if v.FullInstallers == nil {
+		v.FullInstallers = make(map[string]FullInstaller)
+	}
+	v.LastModified = raw.LastModified
+
+	return nil
+}
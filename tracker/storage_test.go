@@ -0,0 +1,69 @@
+package tracker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+func TestFileStorageLoadChecksFormatVersion(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"current", `{"format_version": 1, "sources": {}}`, true},
+		{"unversioned", `{"sources": {}}`, true},
+		{"future", `{"format_version": 2, "sources": {}}`, false},
+		{"zero", `{"format_version": 0, "sources": {}}`, false},
+		{"corrupt", `{"format_version": `, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			err := ioutil.WriteFile(path, []byte(test.data), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state, err := tracker.NewFileStorage(path).Load()
+			if (err == nil) != test.valid {
+				t.Fatalf("Load() error = %v, want valid %v", err, test.valid)
+			}
+			if test.valid && state.Sources == nil {
+				t.Error("Loaded state has no sources map")
+			}
+		})
+	}
+}
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	storage := tracker.NewFileStorage(path)
+
+	state, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Loading a missing state file: %v", err)
+	}
+
+	versionsInfo := tracker.MakeVersionsInfo()
+	versionsInfo.UpdateSecurityUpdate("Mojave", tracker.SecurityUpdate{Name: "2020-001", Build: "18G3020"})
+	state.Sources["macos-catalog"] = &tracker.SourceState{Versions: versionsInfo}
+
+	err = storage.Save(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := loaded.Sources["macos-catalog"].Versions.SecurityUpdates["Mojave"]
+	if got.Name != "2020-001" || got.Build != "18G3020" {
+		t.Errorf("Loaded security update %+v, want 2020-001 (18G3020)", got)
+	}
+}
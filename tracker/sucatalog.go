@@ -0,0 +1,183 @@
+package tracker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"howett.net/plist"
+)
+
+/**
+ * SUCatalog is an Apple software update catalog (.sucatalog)
+ */
+type SUCatalog struct {
+	CatalogVersion int                 `plist:"CatalogVersion"`
+	ApplePostURL   string              `plist:"ApplePostURL"`
+	IndexDate      time.Time           `plist:"IndexDate"`
+	Products       map[string]*Product `plist:"Products"`
+
+	Extra map[string]interface{} `plist:"-"` // Keys we don't model
+}
+
+type Product struct {
+	ServerMetadataURL        string           `plist:"ServerMetadataURL"`
+	Packages                 []*Package       `plist:"Packages"`
+	PostDate                 time.Time        `plist:"PostDate"`
+	Distributions            Distributions    `plist:"Distributions"`
+	ExtendedMetaInfo         ExtendedMetaInfo `plist:"ExtendedMetaInfo"`
+	DeferredSUEnablementDate time.Time        `plist:"DeferredSUEnablementDate"`
+
+	Extra map[string]interface{} `plist:"-"`
+}
+
+type Package struct {
+	URL               string `plist:"URL"`
+	Size              int64  `plist:"Size"`
+	Digest            string `plist:"Digest"`
+	MetadataURL       string `plist:"MetadataURL"`
+	IntegrityDataURL  string `plist:"IntegrityDataURL"`
+	IntegrityDataSize int64  `plist:"IntegrityDataSize"`
+
+	Extra map[string]interface{} `plist:"-"`
+}
+
+type ExtendedMetaInfo struct {
+	ProductType                        string            `plist:"ProductType"`
+	ProductVersion                     string            `plist:"ProductVersion"`
+	InstallAssistantPackageIdentifiers map[string]string `plist:"InstallAssistantPackageIdentifiers"`
+
+	Extra map[string]interface{} `plist:"-"`
+}
+
+/**
+ * Distributions maps a language (e.g. "English" or "en") to the URL of that language's .dist file
+ */
+type Distributions map[string]string
+
+/**
+ * Returns the English distribution URL; older products key it "English", newer ones "en"
+ */
+func (d Distributions) English() (string, bool) {
+	if url, ok := d["English"]; ok {
+		return url, true
+	}
+
+	url, ok := d["en"]
+	return url, ok
+}
+
+/**
+ * ServerMetadata is the document behind a product's ServerMetadataURL (.smd)
+ */
+type ServerMetadata struct {
+	CFBundleShortVersionString string                           `plist:"CFBundleShortVersionString"`
+	CFBundleVersion            string                           `plist:"CFBundleVersion"`
+	Localization               map[string]ServerMetadataStrings `plist:"localization"`
+
+	Extra map[string]interface{} `plist:"-"`
+}
+
+type ServerMetadataStrings struct {
+	Title       string `plist:"title"`
+	Description []byte `plist:"description"`
+}
+
+func (c *SUCatalog) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	type rawCatalog SUCatalog
+	return unmarshalKeepingExtra(unmarshal, (*rawCatalog)(c), &c.Extra)
+}
+
+func (p *Product) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	type rawProduct Product
+	return unmarshalKeepingExtra(unmarshal, (*rawProduct)(p), &p.Extra)
+}
+
+func (p *Package) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	type rawPackage Package
+	return unmarshalKeepingExtra(unmarshal, (*rawPackage)(p), &p.Extra)
+}
+
+func (e *ExtendedMetaInfo) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	type rawExtendedMetaInfo ExtendedMetaInfo
+	return unmarshalKeepingExtra(unmarshal, (*rawExtendedMetaInfo)(e), &e.Extra)
+}
+
+func (m *ServerMetadata) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	type rawServerMetadata ServerMetadata
+	return unmarshalKeepingExtra(unmarshal, (*rawServerMetadata)(m), &m.Extra)
+}
+
+/**
+ * Decodes into the typed struct v, then decodes again as a dictionary and keeps
+ * every key that isn't one of v's plist tags in extra
+ */
+func unmarshalKeepingExtra(unmarshal func(interface{}) error, v interface{}, extra *map[string]interface{}) error {
+	err := unmarshal(v)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]interface{}
+	err = unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	known := plistKeys(reflect.TypeOf(v).Elem())
+	for key, value := range raw {
+		if known[key] {
+			continue
+		}
+
+		if *extra == nil {
+			*extra = make(map[string]interface{})
+		}
+		(*extra)[key] = value
+	}
+
+	return nil
+}
+
+func plistKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("plist"), ",")[0]
+		if tag == "" {
+			tag = t.Field(i).Name
+		}
+		if tag != "-" {
+			keys[tag] = true
+		}
+	}
+
+	return keys
+}
+
+/**
+ * Decodes a catalog, in any of the plist formats
+ */
+func ParseSUCatalog(data []byte) (*SUCatalog, error) {
+	var catalog SUCatalog
+	_, err := plist.Unmarshal(data, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse catalog: %v", err)
+	}
+
+	if catalog.Products == nil {
+		return nil, fmt.Errorf("Could not parse catalog: no Products dictionary")
+	}
+
+	return &catalog, nil
+}
+
+func ParseServerMetadata(data []byte) (*ServerMetadata, error) {
+	var metadata ServerMetadata
+	_, err := plist.Unmarshal(data, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse server metadata: %v", err)
+	}
+
+	return &metadata, nil
+}
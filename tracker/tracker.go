@@ -2,7 +2,7 @@ package tracker
 
 import (
 	"context"
-	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,15 +17,114 @@ const (
 )
 
 type VersionsInfo struct {
-	LatestVersions map[string]*version.Version
-	LastModified   time.Time
+	LatestVersions  map[string]*version.Version
+	LatestBuilds    map[string]string         // Line --> build of the latest version, where known
+	SecurityUpdates map[string]SecurityUpdate // Line --> latest security update, for lines that get them
+	FullInstallers  map[string]FullInstaller  // Line --> latest full installer
+	LastModified    time.Time
+}
+
+func MakeVersionsInfo() *VersionsInfo {
+	return &VersionsInfo{
+		LatestVersions:  map[string]*version.Version{},
+		LatestBuilds:    map[string]string{},
+		SecurityUpdates: map[string]SecurityUpdate{},
+		FullInstallers:  map[string]FullInstaller{},
+		LastModified:    time.Time{},
+	}
+}
+
+/**
+ * Returns a copy of the versions info that can be handed out without holding a lock
+ */
+func (v *VersionsInfo) Copy() *VersionsInfo {
+	latestVersions := make(map[string]*version.Version, len(v.LatestVersions))
+	for line, ver := range v.LatestVersions {
+		latestVersions[line] = ver
+	}
+
+	latestBuilds := make(map[string]string, len(v.LatestBuilds))
+	for line, build := range v.LatestBuilds {
+		latestBuilds[line] = build
+	}
+
+	securityUpdates := make(map[string]SecurityUpdate, len(v.SecurityUpdates))
+	for line, update := range v.SecurityUpdates {
+		securityUpdates[line] = update
+	}
+
+	fullInstallers := make(map[string]FullInstaller, len(v.FullInstallers))
+	for line, installer := range v.FullInstallers {
+		fullInstallers[line] = installer
+	}
+
+	return &VersionsInfo{
+		LatestVersions:  latestVersions,
+		LatestBuilds:    latestBuilds,
+		SecurityUpdates: securityUpdates,
+		FullInstallers:  fullInstallers,
+		LastModified:    v.LastModified,
+	}
+}
+
+/**
+ * Records (ver, build) as the latest release for a line if it is newer than what we have.
+ * Returns true if it was.
+ */
+func (v *VersionsInfo) Update(line string, ver *version.Version, build string) bool {
+	if v.LatestVersions == nil {
+		v.LatestVersions = map[string]*version.Version{}
+	}
+	if v.LatestBuilds == nil {
+		v.LatestBuilds = map[string]string{}
+	}
+
+	latest, ok := v.LatestVersions[line]
+	if ok && !IsNewerRelease(ver, build, latest, v.LatestBuilds[line]) {
+		// Same release, but we may only just have learnt its build
+		if ver.Equal(latest) && v.LatestBuilds[line] == "" && build != "" {
+			v.LatestBuilds[line] = build
+		}
+		return false
+	}
+
+	v.LatestVersions[line] = ver
+	if build != "" {
+		v.LatestBuilds[line] = build
+	} else {
+		delete(v.LatestBuilds, line)
+	}
+
+	return true
+}
+
+/**
+ * The outcome of the most recent scrape of a single source
+ */
+type ScrapeStatus struct {
+	Source      string
+	OSType      string
+	LastScrape  time.Time
+	LastSuccess time.Time
+	LastError   error
+	Duration    time.Duration
 }
 
 type Tracker struct {
-	interval      int
-	osVersionsMap map[string]*VersionsInfo // OS Type --> latest versions/lastModified
-	wg            sync.WaitGroup
-	mtx           sync.RWMutex
+	interval       int
+	registry       *Registry
+	osVersionsMap  map[string]*VersionsInfo // OS Type --> latest versions/lastModified
+	sourceVersions map[string]*VersionsInfo // Scraper name --> versions from its last scrape
+	baselined      map[string]bool          // Scraper names whose changes are news: restored from state or scraped successfully
+	scrapeStatus   map[string]*ScrapeStatus // Scraper name --> status of its last scrape
+	intervals      map[string]time.Duration // Scraper name --> how often to scrape it, if not every interval
+	reschedule     chan struct{}            // Wakes the main loop when sources or intervals change
+	storage        Storage
+	state          *State
+	notifiers      []Notifier
+	updateMtx      sync.Mutex // Serializes folding a scrape into osVersionsMap and publishing its changes
+	wg             sync.WaitGroup
+	mtx            sync.RWMutex
 }
 
 func (t *Tracker) Close() {
@@ -46,48 +145,453 @@ func (t *Tracker) ReadVersions(os string) *VersionsInfo {
 	return t.osVersionsMap[os]
 }
 
-func (t *Tracker) makeRequest(path string, lastModified time.Time) (*http.Response, error) {
-	req, err := http.NewRequest("GET", path, nil)
+/**
+ * Returns the OS types the tracker knows about, in sorted order
+ */
+func (t *Tracker) OSTypes() []string {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	osTypes := make([]string, 0, len(t.osVersionsMap))
+	for osType := range t.osVersionsMap {
+		osTypes = append(osTypes, osType)
+	}
+	sort.Strings(osTypes)
+
+	return osTypes
+}
+
+/**
+ * Returns the status of the last scrape of every source for an OS type.
+ * An empty osType returns the status of every source.
+ */
+func (t *Tracker) ReadScrapeStatus(osType string) []ScrapeStatus {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	statuses := []ScrapeStatus{}
+	for _, s := range t.registry.ScrapersFor(osType) {
+		status, ok := t.scrapeStatus[s.Name()]
+		if !ok {
+			status = &ScrapeStatus{
+				Source: s.Name(),
+				OSType: s.OSType(),
+			}
+		}
+		statuses = append(statuses, *status)
+	}
+
+	return statuses
+}
+
+/**
+ * Adds a source for the tracker to scrape on every interval
+ */
+func (t *Tracker) Register(s Scraper) error {
+	err := t.registry.Register(s)
+	if err != nil {
+		return err
+	}
+
+	t.restoreSource(s)
+	return nil
+}
+
+/**
+ * Stops scraping a source and drops its versions from the merged view of its OS type
+ */
+func (t *Tracker) Unregister(name string) {
+	s, ok := t.registry.Get(name)
+	if !ok {
+		return
+	}
+	t.updateMtx.Lock()
+	defer t.updateMtx.Unlock()
+
+	t.registry.Unregister(name)
+
+	t.mtx.Lock()
+	delete(t.sourceVersions, name)
+	delete(t.baselined, name)
+	delete(t.scrapeStatus, name)
+	delete(t.intervals, name)
+	t.mtx.Unlock()
+
+	t.rebuildVersions(s.OSType())
+	t.Reschedule()
+}
+
+/**
+ * Loads the persisted state from storage and hands it back to any stateful scrapers.
+ * After this the state is saved back to storage at the end of every scrape.
+ */
+func (t *Tracker) LoadState(storage Storage) error {
+	state, err := storage.Load()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	t.mtx.Lock()
+	t.storage = storage
+	t.state = state
+	t.mtx.Unlock()
+
+	for _, s := range t.registry.Scrapers() {
+		t.restoreSource(s)
+	}
+
+	log.WithFields(log.Fields{
+		"timestamp": time.Now().UnixNano(),
+		"saved_at":  state.SavedAt,
+		"sources":   len(state.Sources),
+	}).Info("Loaded state")
+
+	return nil
+}
+
+func (t *Tracker) restoreSource(s Scraper) {
+	t.mtx.RLock()
+	state := t.state
+	t.mtx.RUnlock()
+
+	if state == nil {
+		return
+	}
+
+	sourceState, ok := state.Sources[s.Name()]
+	if !ok {
+		return
+	}
+
+	if stateful, ok := s.(StatefulScraper); ok {
+		stateful.LoadState(sourceState)
 	}
 
-	req.Header.Set("If-Modified-Since", lastModified.Format("Mon, 2 Jan 2006 15:04:05 GMT"))
+	if sourceState.Versions != nil {
+		t.updateSourceVersions(s, sourceState.Versions.Copy())
 
-	client := http.DefaultClient
-	resp, err := client.Do(req)
+		t.mtx.Lock()
+		t.baselined[s.Name()] = true
+		t.mtx.Unlock()
+	}
+}
+
+/**
+ * Collects the state of every stateful scraper and writes it to storage
+ */
+func (t *Tracker) saveState() error {
+	t.mtx.RLock()
+	storage := t.storage
+	t.mtx.RUnlock()
+
+	if storage == nil {
+		return nil
+	}
+
+	state := MakeState()
+	for _, s := range t.registry.Scrapers() {
+		if stateful, ok := s.(StatefulScraper); ok {
+			state.Sources[s.Name()] = stateful.SaveState()
+		}
+	}
+	state.SavedAt = time.Now()
+
+	err := storage.Save(state)
 	if err != nil {
+		return err
+	}
+
+	t.mtx.Lock()
+	t.state = state
+	t.mtx.Unlock()
+
+	return nil
+}
+
+func (t *Tracker) Registry() *Registry {
+	return t.registry
+}
+
+/**
+ * Runs the given scrapers concurrently and folds each result into the osVersionsMap
+ */
+func (t *Tracker) runScrapers(ctx context.Context, scrapers []Scraper) {
+	wg := sync.WaitGroup{}
+
+	for _, s := range scrapers {
+		wg.Add(1)
+
+		go func(s Scraper) {
+			defer wg.Done()
+
+			start := time.Now()
+			versionsInfo, err := s.Scrape(ctx)
+			t.updateScrapeStatus(s, start, err)
+			scrapeDuration.WithLabelValues(s.Name(), s.OSType()).Observe(time.Since(start).Seconds())
+			if err != nil {
+				log.WithFields(log.Fields{
+					"timestamp": time.Now().UnixNano(),
+					"scraper":   s.Name(),
+					"os_type":   s.OSType(),
+					"err":       err,
+				}).Error("Error scraping")
+			}
+
+			if versionsInfo != nil {
+				t.applyScrape(s, versionsInfo, err == nil)
+			}
+		}(s)
+	}
+
+	wg.Wait()
+}
+
+/**
+ * Folds a scraper's versions into the osVersionsMap and publishes its changes.
+ * Scrapers finish concurrently, so this is serialized: otherwise two scrapes could
+ * both compare against the same previous versions and announce the same release twice.
+ *
+ * A source with no saved state starts out knowing nothing, so everything in its first scrape
+ * looks new. Until it has scraped successfully once its changes are only the baseline and
+ * aren't published; otherwise every restart would announce every release all over again.
+ */
+func (t *Tracker) applyScrape(s Scraper, versionsInfo *VersionsInfo, succeeded bool) {
+	t.updateMtx.Lock()
+	defer t.updateMtx.Unlock()
+
+	// The source may have been unregistered while it was being scraped
+	if registered, ok := t.registry.Get(s.Name()); !ok || registered != s {
+		return
+	}
+
+	previous := t.ReadVersions(s.OSType())
+	t.updateSourceVersions(s, versionsInfo)
+
+	t.mtx.Lock()
+	baselined := t.baselined[s.Name()]
+	if succeeded {
+		t.baselined[s.Name()] = true
+	}
+	t.mtx.Unlock()
+
+	reporter, ok := s.(ChangeReporter)
+	if !ok {
+		return
+	}
+
+	changes := reporter.Changes()
+	if !baselined {
 		log.WithFields(log.Fields{
 			"timestamp": time.Now().UnixNano(),
-			"err":       err,
-		}).Error("Error making request")
-		return nil, err
+			"scraper":   s.Name(),
+			"changes":   len(changes),
+		}).Info("Recorded baseline versions; not notifying")
+		return
 	}
 
-	return resp, nil
+	t.publishChanges(previous, changes)
 }
 
-func (t *Tracker) mainLoop(ctx context.Context) {
+func (t *Tracker) updateScrapeStatus(s Scraper, start time.Time, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	status, ok := t.scrapeStatus[s.Name()]
+	if !ok {
+		status = &ScrapeStatus{
+			Source: s.Name(),
+			OSType: s.OSType(),
+		}
+		t.scrapeStatus[s.Name()] = status
+	}
+
+	status.LastScrape = start
+	status.Duration = time.Since(start)
+	status.LastError = err
+	if err == nil {
+		status.LastSuccess = start
+	}
+}
+
+/**
+ * Stores the versions a scraper found and rebuilds the entry for its OS type
+ * from every source that reports on that OS
+ */
+func (t *Tracker) updateSourceVersions(s Scraper, versionsInfo *VersionsInfo) {
+	t.mtx.Lock()
+	t.sourceVersions[s.Name()] = versionsInfo
+	t.mtx.Unlock()
+
+	t.rebuildVersions(s.OSType())
+}
+
+/**
+ * Rebuilds the entry for an OS type from every source that reports on it
+ */
+func (t *Tracker) rebuildVersions(osType string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	merged := MakeVersionsInfo()
+	for _, source := range t.registry.ScrapersFor(osType) {
+		sourceInfo, ok := t.sourceVersions[source.Name()]
+		if !ok {
+			continue
+		}
+
+		for line, ver := range sourceInfo.LatestVersions {
+			merged.Update(line, ver, sourceInfo.LatestBuilds[line])
+		}
+		for line, update := range sourceInfo.SecurityUpdates {
+			merged.UpdateSecurityUpdate(line, update)
+		}
+		for line, installer := range sourceInfo.FullInstallers {
+			merged.UpdateFullInstaller(line, installer)
+		}
+
+		if sourceInfo.LastModified.After(merged.LastModified) {
+			merged.LastModified = sourceInfo.LastModified
+		}
+	}
+
+	t.osVersionsMap[osType] = merged
+}
+
+/**
+ * Changes how often (in seconds) sources without an interval of their own are scraped
+ */
+func (t *Tracker) SetInterval(interval int) {
+	t.mtx.Lock()
+	t.interval = interval
+	t.mtx.Unlock()
+
+	t.Reschedule()
+}
+
+/**
+ * Scrapes a source on its own schedule rather than every interval.
+ * A zero interval puts it back on the tracker-wide one.
+ */
+func (t *Tracker) SetSourceInterval(name string, interval time.Duration) {
+	t.mtx.Lock()
+	if interval <= 0 {
+		delete(t.intervals, name)
+	} else {
+		t.intervals[name] = interval
+	}
+	t.mtx.Unlock()
+
+	t.Reschedule()
+}
+
+func (t *Tracker) sourceInterval(name string) time.Duration {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	if interval, ok := t.intervals[name]; ok {
+		return interval
+	}
+	return time.Duration(t.interval) * time.Second
+}
+
+func (t *Tracker) defaultInterval() time.Duration {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	return time.Duration(t.interval) * time.Second
+}
+
+/**
+ * Tells the main loop to work out again which sources are due, e.g. after a config reload.
+ * Sources that have never been scraped are scraped straight away.
+ */
+func (t *Tracker) Reschedule() {
+	select {
+	case t.reschedule <- struct{}{}:
+	default:
+	}
+}
+
+/**
+ * Runs every registered scraper once
+ */
+func (t *Tracker) Scrape(ctx context.Context) {
+	t.scrapeSources(ctx, t.registry.Scrapers())
+}
+
+/**
+ * Runs the given scrapers once and saves the state afterwards
+ */
+func (t *Tracker) scrapeSources(ctx context.Context, scrapers []Scraper) {
 	log.WithField("timestamp", time.Now().UnixNano()).Debug("Scraping...")
 
-	t.ScrapeForMacVersions()
+	t.runScrapers(ctx, scrapers)
 
 	log.WithField("timestamp", time.Now().UnixNano()).Debug("Finished scraping.")
-	timer := time.NewTicker(time.Duration(t.interval) * time.Second)
+
+	err := t.saveState()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"timestamp": time.Now().UnixNano(),
+			"err":       err,
+		}).Error("Error saving state")
+	}
+}
+
+func (t *Tracker) ScrapeForMacVersions() {
+	t.runScrapers(context.Background(), t.registry.ScrapersFor(OSTypeMac))
+}
+
+/**
+ * Scrapes each source whenever its interval comes round. Sources we haven't scraped yet
+ * (everything, at startup) are due straight away.
+ */
+func (t *Tracker) mainLoop(ctx context.Context) {
+	lastRun := make(map[string]time.Time) // Scraper name --> when we last started scraping it
 
 	for {
+		if ctx.Err() != nil {
+			log.Info("Shutting down tracker.")
+			return
+		}
+
+		now := time.Now()
+		wait := t.defaultInterval()
+		due := []Scraper{}
+
+		for _, s := range t.registry.Scrapers() {
+			next := now
+			if last, ok := lastRun[s.Name()]; ok {
+				next = last.Add(t.sourceInterval(s.Name()))
+			}
+
+			if !next.After(now) {
+				due = append(due, s)
+				lastRun[s.Name()] = now
+				next = now.Add(t.sourceInterval(s.Name()))
+			}
+
+			if next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		}
+
+		if len(due) > 0 {
+			t.scrapeSources(ctx, due)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
 			log.Info("Shutting down tracker.")
 			timer.Stop()
 			return
 
-		case <-timer.C:
-			log.WithField("timestamp", time.Now().UnixNano()).Debug("Scraping...")
-
-			t.ScrapeForMacVersions()
+		case <-t.reschedule:
+			timer.Stop()
 
-			log.WithField("timestamp", time.Now().UnixNano()).Debug("Finished scraping.")
+		case <-timer.C:
 		}
 	}
 }
@@ -95,22 +599,19 @@ func (t *Tracker) mainLoop(ctx context.Context) {
 func MakeTracker(interval int) *Tracker {
 	osVersionsMap := make(map[string]*VersionsInfo)
 
-	osVersionsMap[OSTypeMac] = &VersionsInfo{
-		LatestVersions: map[string]*version.Version{},
-		LastModified:   time.Time{},
-	}
-	osVersionsMap[OSTypeWindows] = &VersionsInfo{
-		LatestVersions: map[string]*version.Version{},
-		LastModified:   time.Time{},
-	}
-	osVersionsMap[OSTypeLinux] = &VersionsInfo{
-		LatestVersions: map[string]*version.Version{},
-		LastModified:   time.Time{},
-	}
+	osVersionsMap[OSTypeMac] = MakeVersionsInfo()
+	osVersionsMap[OSTypeWindows] = MakeVersionsInfo()
+	osVersionsMap[OSTypeLinux] = MakeVersionsInfo()
 
 	return &Tracker{
-		interval:      interval,
-		osVersionsMap: osVersionsMap,
-		mtx:           sync.RWMutex{},
+		interval:       interval,
+		registry:       MakeRegistry(),
+		osVersionsMap:  osVersionsMap,
+		sourceVersions: make(map[string]*VersionsInfo),
+		baselined:      make(map[string]bool),
+		scrapeStatus:   make(map[string]*ScrapeStatus),
+		intervals:      make(map[string]time.Duration),
+		reschedule:     make(chan struct{}, 1),
+		mtx:            sync.RWMutex{},
 	}
 }
@@ -0,0 +1,90 @@
+package trackertest
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/phoebesimon/version_tracker/tracker"
+)
+
+/**
+ * Scraper is a canned macOS source, for testing what's built on top of a tracker without
+ * scraping anything. History returns Records; Scrape returns Versions, or if that's nil the
+ * latest update per line in Records. ReleaseLine looks a version's major up in Lines, or if
+ * that's nil in the lines Records file their GA updates under.
+ */
+type Scraper struct {
+	SourceName string                // Defaults to "fake"
+	Versions   *tracker.VersionsInfo // Worked out from Records if nil
+	Records    []tracker.ProductRecord
+	Lines      map[int]string // Major version --> GA release line
+}
+
+func (s *Scraper) Name() string {
+	if s.SourceName == "" {
+		return "fake"
+	}
+	return s.SourceName
+}
+
+func (s *Scraper) OSType() string {
+	return tracker.OSTypeMac
+}
+
+func (s *Scraper) Scrape(ctx context.Context) (*tracker.VersionsInfo, error) {
+	if s.Versions != nil {
+		return s.Versions.Copy(), nil
+	}
+
+	versionsInfo := tracker.MakeVersionsInfo()
+	for _, record := range s.Records {
+		if ver, ok := s.updateVersion(record); ok {
+			versionsInfo.Update(record.Line, ver, record.Build)
+		}
+	}
+	return versionsInfo, nil
+}
+
+func (s *Scraper) History() []tracker.ProductRecord {
+	return append([]tracker.ProductRecord(nil), s.Records...)
+}
+
+func (s *Scraper) ReleaseLine(ver *version.Version) (string, bool) {
+	major := ver.Segments()[0]
+	if s.Lines != nil {
+		line, ok := s.Lines[major]
+		return line, ok
+	}
+
+	for _, record := range s.Records {
+		if recordVersion, ok := s.updateVersion(record); ok && record.Channel == tracker.ChannelRelease && recordVersion.Segments()[0] == major {
+			return record.Line, true
+		}
+	}
+	return "", false
+}
+
+func (s *Scraper) updateVersion(record tracker.ProductRecord) (*version.Version, bool) {
+	if record.ReleaseKind() != tracker.ReleaseKindUpdate {
+		return nil, false
+	}
+
+	ver, err := version.NewVersion(record.Version)
+	return ver, err == nil
+}
+
+/**
+ * Returns a GA macOS update as a scraper would record it
+ */
+func Release(key string, line string, ver string, build string, postDate time.Time) tracker.ProductRecord {
+	return tracker.ProductRecord{
+		Key:      key,
+		OSType:   tracker.OSTypeMac,
+		Line:     line,
+		Version:  ver,
+		Build:    build,
+		Channel:  tracker.ChannelRelease,
+		PostDate: postDate,
+	}
+}
@@ -0,0 +1,472 @@
+package trackertest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phoebesimon/version_tracker/tracker"
+	"howett.net/plist"
+)
+
+const (
+	FormatXML    = "xml"
+	FormatBinary = "binary"
+
+	catalogsPath  = "/content/catalogs/others/"
+	downloadsPath = "/content/downloads/"
+)
+
+/**
+ * A Product is a single product as listed in the fake catalogs.
+ * Unless Distribution is set, its .dist is generated from Title, Version and Build.
+ */
+type Product struct {
+	Key      string
+	PostDate time.Time
+	Title    string // e.g. "macOS Sonoma 14.7.1"
+	Version  string
+	Build    string // Left out of the distribution if empty
+
+	Distribution        string // Raw .dist body, served instead of the generated one
+	ServerMetadataBuild string // If set the product gets a ServerMetadataURL whose CFBundleVersion is this
+
+	Packages         []Package // Listed in the catalog under the product's downloads; never served
+	InstallAssistant bool      // Marks it a full installer in ExtendedMetaInfo, as Apple does
+}
+
+/**
+ * A Package is one of a product's packages as listed in the catalog
+ */
+type Package struct {
+	Name   string // e.g. "InstallAssistant.pkg"
+	Size   int64
+	Digest string
+}
+
+/**
+ * A Fault replaces the normal response for a path
+ */
+type Fault struct {
+	StatusCode int           // Sent instead of the resource if non-zero
+	Body       string        // Sent instead of the resource if set, e.g. to serve a corrupt catalog
+	RetryAfter string        // Retry-After header to send with StatusCode
+	Delay      time.Duration // How long to wait before responding (or until the client gives up); on its own it just slows the response
+	Times      int           // How many requests it applies to; 0 is every request until cleared
+}
+
+type catalog struct {
+	format  string
+	keys    []string
+	modTime time.Time
+}
+
+type product struct {
+	Product
+	modTime time.Time
+}
+
+/**
+ * Server is a fake Apple Software Update server, for testing scrapers without going anywhere
+ * near swscan.apple.com. It is laid out like Apple's: catalogs live under
+ * /content/catalogs/others/<name>.sucatalog and each product's files under
+ * /content/downloads/<key>/. Catalogs, distributions and server metadata honour
+ * If-Modified-Since, and every change moves the server's clock on a minute so a
+ * change is never hidden by Last-Modified's one-second resolution.
+ */
+type Server struct {
+	URL string
+
+	server    *httptest.Server
+	catalogs  map[string]*catalog // Catalog name --> the products it lists
+	products  map[string]*product // Product key --> product
+	faults    map[string]*Fault   // Path --> what to do instead of serving it
+	responses map[string][]int    // Path --> status codes served, in order
+	clock     time.Time
+	mtx       sync.Mutex
+}
+
+func NewServer() *Server {
+	s := &Server{
+		catalogs:  make(map[string]*catalog),
+		products:  make(map[string]*product),
+		faults:    make(map[string]*Fault),
+		responses: make(map[string][]int),
+		clock:     time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+/**
+ * Returns a catalog for the scrapers pointing at the named catalog on this server
+ */
+func (s *Server) Catalog(name string, channel string) tracker.Catalog {
+	return tracker.Catalog{
+		Name:    name,
+		URL:     s.CatalogURL(name),
+		Channel: channel,
+	}
+}
+
+func (s *Server) CatalogURL(name string) string {
+	return s.URL + s.CatalogPath(name)
+}
+
+func (s *Server) CatalogPath(name string) string {
+	return catalogsPath + name + ".sucatalog"
+}
+
+func (s *Server) DistributionURL(key string) string {
+	return s.URL + s.DistributionPath(key)
+}
+
+func (s *Server) DistributionPath(key string) string {
+	return downloadsPath + key + "/" + key + ".English.dist"
+}
+
+func (s *Server) ServerMetadataURL(key string) string {
+	return s.URL + s.ServerMetadataPath(key)
+}
+
+func (s *Server) ServerMetadataPath(key string) string {
+	return downloadsPath + key + "/" + key + ".smd"
+}
+
+func (s *Server) PackageURL(key string, name string) string {
+	return s.URL + downloadsPath + key + "/" + name
+}
+
+/**
+ * Creates or replaces a catalog listing the given product keys, served as an XML or binary plist
+ */
+func (s *Server) SetCatalog(name string, format string, keys ...string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.catalogs[name] = &catalog{
+		format:  format,
+		keys:    keys,
+		modTime: s.tick(),
+	}
+}
+
+/**
+ * Adds or replaces a product. Catalogs listing it count as modified.
+ */
+func (s *Server) SetProduct(p Product) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.products[p.Key] = &product{Product: p, modTime: s.tick()}
+	s.touchCatalogs(p.Key)
+}
+
+/**
+ * Removes a product; catalogs still listing it just leave it out
+ */
+func (s *Server) RemoveProduct(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.products, key)
+	s.touchCatalogs(key)
+}
+
+/**
+ * Makes requests for path (e.g. CatalogPath("14")) fail or misbehave until the fault runs out or is cleared
+ */
+func (s *Server) InjectFault(path string, fault Fault) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.faults[path] = &fault
+}
+
+func (s *Server) ClearFaults() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.faults = make(map[string]*Fault)
+}
+
+/**
+ * Returns the status codes served for path so far, oldest first
+ */
+func (s *Server) Responses(path string) []int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]int(nil), s.responses[path]...)
+}
+
+/**
+ * Forgets the responses served so far
+ */
+func (s *Server) ResetResponses() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.responses = make(map[string][]int)
+}
+
+/**
+ * Must be called with s.mtx held
+ */
+func (s *Server) tick() time.Time {
+	s.clock = s.clock.Add(time.Minute)
+	return s.clock
+}
+
+/**
+ * Must be called with s.mtx held
+ */
+func (s *Server) touchCatalogs(key string) {
+	for _, catalog := range s.catalogs {
+		for _, listed := range catalog.keys {
+			if listed == key {
+				catalog.modTime = s.tick()
+				break
+			}
+		}
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+	defer func() {
+		s.mtx.Lock()
+		s.responses[r.URL.Path] = append(s.responses[r.URL.Path], recorder.statusCode)
+		s.mtx.Unlock()
+	}()
+
+	if fault, ok := s.takeFault(r.URL.Path); ok && serveFault(recorder, r, fault) {
+		return
+	}
+
+	body, modTime, err := s.resource(r.URL.Path)
+	if err != nil {
+		http.Error(recorder, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if body == nil {
+		http.NotFound(recorder, r)
+		return
+	}
+
+	http.ServeContent(recorder, r, r.URL.Path, modTime, bytes.NewReader(body))
+}
+
+/**
+ * Returns the fault for path, if there is one, and uses up one of its requests
+ */
+func (s *Server) takeFault(path string) (Fault, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	fault, ok := s.faults[path]
+	if !ok {
+		return Fault{}, false
+	}
+
+	if fault.Times > 0 {
+		fault.Times--
+		if fault.Times == 0 {
+			delete(s.faults, path)
+		}
+	}
+
+	return *fault, true
+}
+
+/**
+ * Returns false if the resource should still be served once the fault is done with
+ */
+func serveFault(w http.ResponseWriter, r *http.Request, fault Fault) bool {
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	switch {
+	case fault.StatusCode != 0:
+		if fault.RetryAfter != "" {
+			w.Header().Set("Retry-After", fault.RetryAfter)
+		}
+		http.Error(w, fault.Body, fault.StatusCode)
+		return true
+
+	case fault.Body != "":
+		w.Write([]byte(fault.Body))
+		return true
+	}
+
+	return false
+}
+
+/**
+ * Returns the body and modification time of whatever lives at path, or a nil body if nothing does
+ */
+func (s *Server) resource(path string) ([]byte, time.Time, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if strings.HasPrefix(path, catalogsPath) && strings.HasSuffix(path, ".sucatalog") {
+		name := strings.TrimSuffix(strings.TrimPrefix(path, catalogsPath), ".sucatalog")
+		catalog, ok := s.catalogs[name]
+		if !ok {
+			return nil, time.Time{}, nil
+		}
+
+		body, err := s.encodeCatalog(catalog)
+		return body, catalog.modTime, err
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, downloadsPath), "/")
+	if !strings.HasPrefix(path, downloadsPath) || len(parts) != 2 {
+		return nil, time.Time{}, nil
+	}
+
+	product, ok := s.products[parts[0]]
+	if !ok {
+		return nil, time.Time{}, nil
+	}
+
+	switch parts[1] {
+	case product.Key + ".English.dist":
+		if product.Distribution != "" {
+			return []byte(product.Distribution), product.modTime, nil
+		}
+		return []byte(MakeDistribution(product.Title, product.Version, product.Build)), product.modTime, nil
+
+	case product.Key + ".smd":
+		if product.ServerMetadataBuild == "" {
+			return nil, time.Time{}, nil
+		}
+
+		body, err := plist.Marshal(map[string]interface{}{
+			"CFBundleShortVersionString": product.Version,
+			"CFBundleVersion":            product.ServerMetadataBuild,
+		}, plist.XMLFormat)
+		return body, product.modTime, err
+	}
+
+	return nil, time.Time{}, nil
+}
+
+/**
+ * Must be called with s.mtx held
+ */
+func (s *Server) encodeCatalog(catalog *catalog) ([]byte, error) {
+	products := make(map[string]interface{}, len(catalog.keys))
+	for _, key := range catalog.keys {
+		product, ok := s.products[key]
+		if !ok {
+			continue
+		}
+
+		packages := []interface{}{}
+		for _, pkg := range product.Packages {
+			packages = append(packages, map[string]interface{}{
+				"URL":    s.PackageURL(key, pkg.Name),
+				"Size":   pkg.Size,
+				"Digest": pkg.Digest,
+			})
+		}
+
+		entry := map[string]interface{}{
+			"Distributions": map[string]string{
+				"English": s.DistributionURL(key),
+			},
+			"Packages": packages,
+		}
+		if !product.PostDate.IsZero() {
+			entry["PostDate"] = product.PostDate
+		}
+		if product.ServerMetadataBuild != "" {
+			entry["ServerMetadataURL"] = s.ServerMetadataURL(key)
+		}
+		if product.InstallAssistant {
+			entry["ExtendedMetaInfo"] = map[string]interface{}{
+				"InstallAssistantPackageIdentifiers": map[string]string{
+					"OSInstall":     "com.apple.mpkg.OSInstall",
+					"SharedSupport": "com.apple.pkg.InstallAssistant.macOS",
+				},
+			}
+		}
+
+		products[key] = entry
+	}
+
+	format := plist.XMLFormat
+	if catalog.format == FormatBinary {
+		format = plist.BinaryFormat
+	}
+
+	return plist.Marshal(map[string]interface{}{
+		"CatalogVersion": 2,
+		"ApplePostURL":   s.URL + "/",
+		"IndexDate":      catalog.modTime,
+		"Products":       products,
+	}, format)
+}
+
+/**
+ * Returns an installer-gui-script distribution like the ones Apple serves for software updates.
+ * The build goes in auxinfo, and is left out if empty.
+ */
+func MakeDistribution(title string, version string, build string) string {
+	auxInfo := ""
+	if build != "" {
+		auxInfo = fmt.Sprintf(`
+    <auxinfo>
+        <dict>
+            <key>BUILD</key>
+            <string>%s</string>
+        </dict>
+    </auxinfo>`, build)
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<installer-gui-script minSpecVersion="1">
+    <title>SU_TITLE</title>%s
+    <choices-outline>
+        <line choice="su"/>
+    </choices-outline>
+    <choice id="su" title="SU_TITLE" description="SU_DESCRIPTION"/>
+    <localization>
+        <strings language="English"><![CDATA["SU_TITLE" = "%s";
+"SU_VERS" = "%s";
+"SU_DESCRIPTION" = "This update is recommended for all users.";
+]]></strings>
+    </localization>
+</installer-gui-script>
+`, auxInfo, title, version)
+}
+
+/**
+ * Remembers the status code written, for Server.Responses
+ */
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
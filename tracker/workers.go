@@ -0,0 +1,67 @@
+package tracker
+
+import (
+	"errors"
+	"sync"
+)
+
+/**
+ * FetchOptions controls how many requests a scraper makes at once and how fast
+ */
+type FetchOptions struct {
+	Workers    int     // Number of fetches to run concurrently
+	GlobalRate float64 // Requests per second across all hosts; <= 0 is unlimited
+	HostRate   float64 // Requests per second to any one host; <= 0 is unlimited
+	Burst      int     // How many requests may be made back to back before the rates kick in
+
+	// Fetch every catalog and distribution in full, ignoring cached validators and snapshots,
+	// e.g. so a RecordingFetcher sees every response even when state was loaded
+	Unconditional bool
+}
+
+var DefaultFetchOptions = FetchOptions{
+	Workers:    8,
+	GlobalRate: 20,
+	HostRate:   10,
+	Burst:      5,
+}
+
+func (o FetchOptions) Validate() error {
+	if o.Workers < 1 {
+		return errors.New("Fetch workers must be at least 1")
+	}
+	return nil
+}
+
+/**
+ * Calls fn(i) for every i in [0, n) using at most workers goroutines, and waits for them all
+ */
+func runPool(n int, workers int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
+}
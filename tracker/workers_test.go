@@ -0,0 +1,40 @@
+package tracker
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRunPoolVisitsEveryIndexOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		workers int
+	}{
+		{"more jobs than workers", 100, 8},
+		{"more workers than jobs", 3, 8},
+		{"one worker", 10, 1},
+		{"zero workers", 10, 0},
+		{"negative workers", 10, -1},
+		{"no jobs", 0, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mtx sync.Mutex
+			visits := make([]int, test.n)
+
+			runPool(test.n, test.workers, func(i int) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				visits[i]++
+			})
+
+			for i, count := range visits {
+				if count != 1 {
+					t.Errorf("Index %d visited %d times, want 1", i, count)
+				}
+			}
+		})
+	}
+}